@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
@@ -32,6 +33,8 @@ type OnError func(event ErrorEvent)
 type OnClose func(reason string)
 type OnAddHistory func(event AddHistoryEvent)
 type OnOther func(event OtherEvent)
+type OnCandidate func(event CandidateEvent)
+type OnAudio func(payload []byte)
 
 type Client struct {
 	ctx                      context.Context
@@ -39,6 +42,7 @@ type Client struct {
 	eventChan                chan []byte
 	endpoint                 string
 	conn                     *websocket.Conn
+	writeMutex               sync.Mutex
 	logger                   *logrus.Logger
 	id                       string
 	onAnswer                 OnAnswer
@@ -63,6 +67,8 @@ type Client struct {
 	OnError                  OnError
 	OnAddHistory             OnAddHistory
 	OnOther                  OnOther
+	OnCandidate              OnCandidate
+	OnAudio                  OnAudio
 }
 
 type event struct {
@@ -204,6 +210,13 @@ type OtherEvent struct {
 	Extra     map[string]string `json:"extra,omitempty"`
 }
 
+// CandidateEvent carries remote ICE candidates when trickle ICE is used
+type CandidateEvent struct {
+	TrackID    string   `json:"trackId"`
+	Timestamp  uint64   `json:"timestamp"`
+	Candidates []string `json:"candidates"`
+}
+
 // Command represents WebSocket commands to be sent to the server
 type Command struct {
 	Command string `json:"command"`
@@ -367,6 +380,7 @@ type CallOption struct {
 	Sip              *SipOption        `json:"sip,omitempty"`
 	Extra            map[string]string `json:"extra,omitempty"`
 	Eou              *EouOption        `json:"eou,omitempty"`
+	Codec            string            `json:"codec,omitempty"`
 }
 
 type EouOption struct {
@@ -442,6 +456,11 @@ func (c *Client) Connect(callType string) error {
 				}
 				return
 			}
+			// websocket calls carry the media as binary messages, one encoded frame per message
+			if mt == websocket.BinaryMessage && c.OnAudio != nil {
+				c.OnAudio(message)
+				continue
+			}
 			if mt != websocket.TextMessage {
 				c.logger.Debugf("Received non-text message: %v", mt)
 				continue
@@ -671,6 +690,15 @@ func (c *Client) processEvent(message []byte) {
 		if c.OnOther != nil {
 			c.OnOther(event)
 		}
+	case "candidate":
+		var event CandidateEvent
+		if err := json.Unmarshal(message, &event); err != nil {
+			c.logger.Errorf("Error unmarshalling candidate event: %v", err)
+			return
+		}
+		if c.OnCandidate != nil {
+			c.OnCandidate(event)
+		}
 	default:
 		c.logger.Debugf("Unhandled event type: %s", ev.Event)
 	}
@@ -879,9 +907,22 @@ func (c *Client) sendCommand(cmd any) error {
 	c.logger.WithFields(logrus.Fields{
 		"command": cmd,
 	}).Debug("Sending command")
+	// commands may be sent from several goroutines, e.g. ICE candidates while TTS is streaming
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
 	return c.conn.WriteJSON(cmd)
 }
 
+// SendAudio sends an encoded audio frame as a binary message, used by websocket calls
+func (c *Client) SendAudio(payload []byte) error {
+	if c.conn == nil {
+		return errors.New("client not initialized")
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.conn.WriteMessage(websocket.BinaryMessage, payload)
+}
+
 func (c *Client) GetConn() *websocket.Conn {
     return c.conn
 }
\ No newline at end of file
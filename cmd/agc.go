@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+const (
+	// gateHysteresisDB 噪声门关闭阈值比打开阈值低的分贝数，避免在阈值附近反复开关
+	gateHysteresisDB = 6
+	// gateHold 电平低于关闭阈值持续该时长后才关闭噪声门，避免切掉字尾
+	gateHold = 200 * time.Millisecond
+	// levelMeterInterval 输入电平事件的间隔
+	levelMeterInterval = 100 * time.Millisecond
+)
+
+// AGCConfig 采集音频的自动增益控制和噪声门参数
+type AGCConfig struct {
+	AutoGain  bool          // 是否自动调整增益，关闭时只使用噪声门
+	TargetDB  float64       // 语音的目标电平，单位 dBFS
+	MaxGainDB float64       // 最大增益，避免把很小的声音和底噪放得过大
+	Attack    time.Duration // 增益下降的时间常数，声音突然变大时快速压低
+	Release   time.Duration // 增益上升的时间常数，声音变小时缓慢提升
+	GateDB    float64       // 噪声门阈值，单位 dBFS，0 表示不使用噪声门
+}
+
+// DefaultAGCConfig 返回默认的自动增益参数
+func DefaultAGCConfig() AGCConfig {
+	return AGCConfig{
+		AutoGain:  true,
+		TargetDB:  -20,
+		MaxGainDB: 30,
+		Attack:    10 * time.Millisecond,
+		Release:   500 * time.Millisecond,
+		GateDB:    -55,
+	}
+}
+
+// InputLevel 麦克风输入电平，用于界面显示电平表
+type InputLevel struct {
+	RMSDB  float64 `json:"rmsDb"`  // 统计周期内的均方根电平，单位 dBFS
+	PeakDB float64 `json:"peakDb"` // 统计周期内的峰值电平，单位 dBFS
+	GainDB float64 `json:"gainDb"` // 当前自动增益
+	Gated  bool    `json:"gated"`  // 噪声门是否关闭
+}
+
+// gainControl 在编码前按帧处理采集的音频：噪声门去除底噪，自动增益把语音调整到目标电平
+// 每帧内从上一帧的增益线性过渡到新的增益，避免增益跳变产生咔嗒声
+type gainControl struct {
+	config     AGCConfig
+	sampleRate int
+	gain       float64 // 当前自动增益，线性值
+	gate       float64 // 当前噪声门增益，1 为打开，0 为关闭
+	gateOpen   bool
+	quiet      time.Duration // 电平低于关闭阈值的持续时长
+}
+
+// newGainControl 创建增益控制，sampleRate 为输入数据的采样率
+func newGainControl(config AGCConfig, sampleRate int) *gainControl {
+	return &gainControl{config: config, sampleRate: sampleRate, gain: 1, gate: 1, gateOpen: true}
+}
+
+// smoothing 计算一帧内向目标值靠近的比例
+func smoothing(frame, constant time.Duration) float64 {
+	if constant <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-frame.Seconds()/constant.Seconds())
+}
+
+// Process 处理一帧 S16LE 数据，level 为这一帧处理前的电平，返回新的缓冲区
+func (g *gainControl) Process(frame []byte, level float64) []byte {
+	duration := time.Duration(len(frame)/2) * time.Second / time.Duration(g.sampleRate)
+
+	// 噪声门：高于阈值立即打开，低于关闭阈值持续一段时间后关闭
+	if g.config.GateDB != 0 {
+		switch {
+		case level >= g.config.GateDB:
+			g.gateOpen = true
+			g.quiet = 0
+		case level < g.config.GateDB-gateHysteresisDB:
+			g.quiet += duration
+			if g.quiet >= gateHold {
+				g.gateOpen = false
+			}
+		}
+	}
+	gate := 0.0
+	if g.gateOpen {
+		gate = 1
+	}
+
+	// 自动增益：只在噪声门打开、且电平不低于最大增益能调整到目标的电平时调整，避免在静音时把增益提升到最大
+	gain := g.gain
+	if g.config.AutoGain && g.gateOpen && level >= g.config.TargetDB-g.config.MaxGainDB {
+		desired := math.Min(g.config.TargetDB-level, g.config.MaxGainDB)
+		current := 20 * math.Log10(g.gain)
+		constant := g.config.Release
+		if desired < current {
+			constant = g.config.Attack
+		}
+		gain = math.Pow(10, (current+(desired-current)*smoothing(duration, constant))/20)
+	}
+	// 限制增益，保证这一帧的峰值不削波
+	if peak := framePeak(frame); peak > 0 {
+		gain = math.Min(gain, math.MaxInt16/peak)
+	}
+
+	samples := len(frame) / 2
+	out := make([]byte, len(frame))
+	for i := 0; i < samples; i++ {
+		t := float64(i+1) / float64(samples)
+		factor := (g.gain + (gain-g.gain)*t) * (g.gate + (gate-g.gate)*t)
+		sample := float64(int16(binary.LittleEndian.Uint16(frame[i*2:])))
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(clampSample(sample*factor)))
+	}
+	g.gain, g.gate = gain, gate
+	return out
+}
+
+// GainDB 当前自动增益，单位 dB
+func (g *gainControl) GainDB() float64 {
+	return 20 * math.Log10(g.gain)
+}
+
+// Gated 噪声门是否关闭
+func (g *gainControl) Gated() bool {
+	return !g.gateOpen
+}
+
+// framePeak 一帧 S16LE 数据的最大绝对值
+func framePeak(frame []byte) float64 {
+	peak := 0.0
+	for i := 0; i+1 < len(frame); i += 2 {
+		peak = math.Max(peak, math.Abs(float64(int16(binary.LittleEndian.Uint16(frame[i:])))))
+	}
+	return peak
+}
+
+// levelMeter 累计输入电平，每个统计周期输出一次
+type levelMeter struct {
+	sum      float64
+	samples  int
+	peak     float64
+	interval int // 每个统计周期的采样数
+}
+
+// newLevelMeter 创建电平表
+func newLevelMeter(sampleRate int) *levelMeter {
+	return &levelMeter{interval: int(levelMeterInterval.Seconds() * float64(sampleRate))}
+}
+
+// add 加入一帧数据，统计周期结束时返回 true 和这个周期的电平
+func (m *levelMeter) add(frame []byte) (InputLevel, bool) {
+	for i := 0; i+1 < len(frame); i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(frame[i:])))
+		m.sum += sample * sample
+		m.peak = math.Max(m.peak, math.Abs(sample))
+	}
+	m.samples += len(frame) / 2
+	if m.samples < m.interval {
+		return InputLevel{}, false
+	}
+	level := InputLevel{
+		RMSDB:  toDBFS(math.Sqrt(m.sum / float64(m.samples))),
+		PeakDB: toDBFS(m.peak),
+	}
+	m.sum, m.samples, m.peak = 0, 0, 0
+	return level, true
+}
+
+// toDBFS 把采样幅度转换为 dBFS，静音时返回 -100
+func toDBFS(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return -100
+	}
+	return math.Max(-100, 20*math.Log10(amplitude/32768))
+}
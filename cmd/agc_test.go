@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// scalePCM 按 gain 缩放 S16LE 数据
+func scalePCM(pcm []byte, gain float64) []byte {
+	out := make([]byte, len(pcm))
+	for i := 0; i+1 < len(pcm); i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcm[i:])))
+		binary.LittleEndian.PutUint16(out[i:], uint16(clampSample(sample*gain)))
+	}
+	return out
+}
+
+// 测试自动增益把小声和大声的语音都调整到目标电平附近，并且不削波
+func TestGainControl_ReachesTarget(t *testing.T) {
+	for _, gain := range []float64{0.05, 3} {
+		agc := newGainControl(DefaultAGCConfig(), 8000)
+		frame := scalePCM(sinePCM(440, 8000, 160), gain)
+		var out []byte
+		for i := 0; i < 150; i++ {
+			level, _ := frameLevel(frame)
+			out = agc.Process(frame, level)
+		}
+		level, _ := frameLevel(out)
+		if math.Abs(level-DefaultAGCConfig().TargetDB) > 2 {
+			t.Errorf("input gain %.2f: output level %.1fdBFS, want about %.0fdBFS", gain, level, DefaultAGCConfig().TargetDB)
+		}
+		if framePeak(out) >= math.MaxInt16 {
+			t.Errorf("input gain %.2f: output clipped", gain)
+		}
+	}
+}
+
+// 测试噪声门在底噪持续一段时间后关闭，语音出现时立即打开，静音时不提升增益
+func TestGainControl_NoiseGate(t *testing.T) {
+	agc := newGainControl(DefaultAGCConfig(), 8000)
+	noise := scalePCM(sinePCM(440, 8000, 160), 0.001)
+	var out []byte
+	for i := 0; i < 20; i++ {
+		level, _ := frameLevel(noise)
+		out = agc.Process(noise, level)
+	}
+	if !agc.Gated() || framePeak(out) != 0 {
+		t.Fatalf("expected the gate to mute the noise floor")
+	}
+	if agc.GainDB() != 0 {
+		t.Errorf("gain changed while gated: %.1fdB", agc.GainDB())
+	}
+	speech := sinePCM(440, 8000, 160)
+	level, _ := frameLevel(speech)
+	agc.Process(speech, level)
+	if agc.Gated() {
+		t.Errorf("expected the gate to open on speech")
+	}
+}
+
+// 测试电平表每 100 毫秒输出一次电平
+func TestLevelMeter(t *testing.T) {
+	meter := newLevelMeter(8000)
+	frame := sinePCM(440, 8000, 160)
+	for i := 0; i < 4; i++ {
+		if _, ok := meter.add(frame); ok {
+			t.Fatalf("level reported before the interval")
+		}
+	}
+	level, ok := meter.add(frame)
+	if !ok {
+		t.Fatalf("expected a level after 100ms")
+	}
+	// 幅度 10000 的正弦波：峰值约 -10.3dBFS，均方根约 -13.3dBFS
+	if math.Abs(level.PeakDB+10.3) > 0.5 || math.Abs(level.RMSDB+13.3) > 0.5 {
+		t.Errorf("unexpected level: %+v", level)
+	}
+}
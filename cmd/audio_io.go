@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// AudioSource 音频输入，按 sampleRate 产生 S16LE 单声道数据并通过 onData 回调交给 MediaHandler
+type AudioSource interface {
+	Start(sampleRate int, onData func(samples []byte)) error
+	Stop() error
+}
+
+// AudioSink 音频输出，需要数据时调用 fill 用 S16LE 单声道数据填满 output
+type AudioSink interface {
+	Start(sampleRate int, fill func(output []byte)) error
+	Stop() error
+}
+
+// audioFrameDuration 无声卡的输入输出每次处理的音频时长
+const audioFrameDuration = 20 * time.Millisecond
+
+// audioDeviceConfig 声卡输入输出的配置
+type audioDeviceConfig struct {
+	contextFn      func() (*malgo.AllocatedContext, error) // 获取共享的 malgo 上下文
+	sampleRate     int                                     // 打开声卡使用的采样率，0 表示使用声卡的原生采样率
+	captureDevice  string                                  // 采集设备的 ID 或名称，空表示默认设备
+	playbackDevice string                                  // 播放设备的 ID 或名称，空表示默认设备
+}
+
+// ParseAudioSource 解析 --audio-in 参数：device、silence 或 file:<path.wav>
+func ParseAudioSource(spec string, device audioDeviceConfig) (AudioSource, error) {
+	switch {
+	case spec == "" || spec == "device":
+		return &deviceSource{config: device}, nil
+	case spec == "silence" || spec == "null":
+		return &silenceSource{}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return &wavFileSource{path: strings.TrimPrefix(spec, "file:")}, nil
+	}
+	return nil, fmt.Errorf("invalid audio input: %s, expected device, silence or file:<path.wav>", spec)
+}
+
+// ParseAudioSink 解析 --audio-out 参数：device、null 或 file:<path.wav>
+func ParseAudioSink(spec string, device audioDeviceConfig) (AudioSink, error) {
+	switch {
+	case spec == "" || spec == "device":
+		return &deviceSink{config: device}, nil
+	case spec == "null" || spec == "silence":
+		return &nullSink{}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return &wavFileSink{path: strings.TrimPrefix(spec, "file:")}, nil
+	}
+	return nil, fmt.Errorf("invalid audio output: %s, expected device, null or file:<path.wav>", spec)
+}
+
+// deviceSource 使用 malgo 采集设备，声卡采样率与编解码器不同时做重采样
+type deviceSource struct {
+	config audioDeviceConfig
+	device *malgo.Device
+}
+
+func (s *deviceSource) Start(sampleRate int, onData func(samples []byte)) error {
+	audioCtx, err := s.config.contextFn()
+	if err != nil {
+		return err
+	}
+	deviceID, err := findDevice(audioCtx, malgo.Capture, s.config.captureDevice)
+	if err != nil {
+		return err
+	}
+	deviceConfig := malgo.DefaultDeviceConfig(malgo.Capture)
+	if deviceID != nil {
+		deviceConfig.Capture.DeviceID = deviceID.Pointer()
+	}
+	deviceConfig.Capture.Format = malgo.FormatS16
+	deviceConfig.Capture.Channels = 1
+	deviceConfig.SampleRate = uint32(s.config.sampleRate)
+	deviceConfig.Alsa.NoMMap = 1
+
+	// 处理设备的数据回调函数，将输入样本转换为编解码器的采样率后交给 MediaHandler
+	var converter *resampler
+	device, err := malgo.InitDevice(audioCtx.Context, deviceConfig, malgo.DeviceCallbacks{
+		Data: func(outputSamples, inputSamples []byte, frameCount uint32) {
+			onData(converter.Process(inputSamples))
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize capture device: %v", err)
+	}
+	converter = newResampler(int(device.SampleRate()), sampleRate)
+	if err := device.Start(); err != nil {
+		device.Uninit()
+		return fmt.Errorf("failed to start capture device: %v", err)
+	}
+	s.device = device
+	return nil
+}
+
+func (s *deviceSource) Stop() error {
+	if s.device != nil {
+		s.device.Stop()
+		s.device.Uninit()
+		s.device = nil
+	}
+	return nil
+}
+
+// deviceSink 使用 malgo 播放设备，声卡采样率与编解码器不同时做重采样
+type deviceSink struct {
+	config audioDeviceConfig
+	device *malgo.Device
+}
+
+func (s *deviceSink) Start(sampleRate int, fill func(output []byte)) error {
+	audioCtx, err := s.config.contextFn()
+	if err != nil {
+		return err
+	}
+	deviceID, err := findDevice(audioCtx, malgo.Playback, s.config.playbackDevice)
+	if err != nil {
+		return err
+	}
+	deviceConfig := malgo.DefaultDeviceConfig(malgo.Playback)
+	if deviceID != nil {
+		deviceConfig.Playback.DeviceID = deviceID.Pointer()
+	}
+	deviceConfig.Playback.Format = malgo.FormatS16
+	deviceConfig.Playback.Channels = 1
+	deviceConfig.SampleRate = uint32(s.config.sampleRate)
+	deviceConfig.Alsa.NoMMap = 1
+
+	// 处理设备的数据回调函数，从 MediaHandler 取数据转换为声卡的采样率后填充输出样本
+	var converted func(output []byte)
+	device, err := malgo.InitDevice(audioCtx.Context, deviceConfig, malgo.DeviceCallbacks{
+		Data: func(outputSamples, inputSamples []byte, frameCount uint32) {
+			converted(outputSamples)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize playback device: %w", err)
+	}
+	converted = resampledFill(fill, sampleRate, int(device.SampleRate()))
+	if err := device.Start(); err != nil {
+		device.Uninit()
+		return fmt.Errorf("failed to start playback device: %w", err)
+	}
+	s.device = device
+	return nil
+}
+
+func (s *deviceSink) Stop() error {
+	if s.device != nil {
+		s.device.Stop()
+		s.device.Uninit()
+		s.device = nil
+	}
+	return nil
+}
+
+// resampledFill 把编解码器采样率的 fill 包装为按声卡采样率填充输出的函数
+func resampledFill(fill func(output []byte), sampleRate, deviceRate int) func(output []byte) {
+	if sampleRate == deviceRate {
+		return fill
+	}
+	converter := newResampler(sampleRate, deviceRate)
+	var pending []byte
+	return func(output []byte) {
+		for len(pending) < len(output) {
+			// 按比例取足够的编解码器数据，多取一个采样补偿插值的相位
+			samples := (len(output)-len(pending))/2*sampleRate/deviceRate + 1
+			chunk := make([]byte, samples*2)
+			fill(chunk)
+			pending = append(pending, converter.Process(chunk)...)
+		}
+		n := copy(output, pending)
+		pending = pending[n:]
+	}
+}
+
+// pacedWorker 按实时节奏每 audioFrameDuration 调用一次 fn，用于模拟声卡的无设备输入输出
+type pacedWorker struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start 以绝对时间计算每一帧的截止时间，避免 ticker 抖动累积成漂移
+func (w *pacedWorker) start(fn func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		start := time.Now()
+		for frame := 1; ; frame++ {
+			deadline := start.Add(time.Duration(frame) * audioFrameDuration)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(deadline)):
+			}
+			if !fn() {
+				return
+			}
+		}
+	}()
+}
+
+func (w *pacedWorker) stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+	w.cancel = nil
+}
+
+// frameBytes 计算一帧 S16LE 单声道数据的字节数
+func frameBytes(sampleRate int) int {
+	return sampleRate * int(audioFrameDuration/time.Millisecond) / 1000 * 2
+}
+
+// silenceSource 持续产生静音，适合只需要听对方说话的场景
+type silenceSource struct {
+	worker pacedWorker
+}
+
+func (s *silenceSource) Start(sampleRate int, onData func(samples []byte)) error {
+	frame := make([]byte, frameBytes(sampleRate))
+	s.worker.start(func() bool {
+		onData(frame)
+		return true
+	})
+	return nil
+}
+
+func (s *silenceSource) Stop() error {
+	s.worker.stop()
+	return nil
+}
+
+// pcmSource 按实时节奏输出内存中的 S16LE 音频，采样率不同时先重采样，结束后输出静音
+type pcmSource struct {
+	pcm        []byte
+	sampleRate int
+	worker     pacedWorker
+}
+
+func (s *pcmSource) Start(sampleRate int, onData func(samples []byte)) error {
+	pcm := s.pcm
+	if s.sampleRate != sampleRate {
+		pcm = resamplePCM(pcm, s.sampleRate, sampleRate)
+	}
+	size := frameBytes(sampleRate)
+	silence := make([]byte, size)
+	offset := 0
+	s.worker.start(func() bool {
+		if offset >= len(pcm) {
+			onData(silence)
+			return true
+		}
+		end := offset + size
+		if end > len(pcm) {
+			end = len(pcm)
+		}
+		frame := make([]byte, size)
+		copy(frame, pcm[offset:end])
+		offset = end
+		onData(frame)
+		return true
+	})
+	return nil
+}
+
+func (s *pcmSource) Stop() error {
+	s.worker.stop()
+	return nil
+}
+
+// wavFileSource 按实时节奏读取 WAV 文件作为来电者音频，采样率不同时先重采样，文件结束后输出静音
+type wavFileSource struct {
+	path string
+	pcmSource
+}
+
+func (s *wavFileSource) Start(sampleRate int, onData func(samples []byte)) error {
+	pcm, fileRate, err := readWAV(s.path)
+	if err != nil {
+		return err
+	}
+	s.pcm, s.sampleRate = pcm, fileRate
+	return s.pcmSource.Start(sampleRate, onData)
+}
+
+// nullSink 按实时节奏取走播放数据并丢弃
+type nullSink struct {
+	worker pacedWorker
+}
+
+func (s *nullSink) Start(sampleRate int, fill func(output []byte)) error {
+	frame := make([]byte, frameBytes(sampleRate))
+	s.worker.start(func() bool {
+		clear(frame)
+		fill(frame)
+		return true
+	})
+	return nil
+}
+
+func (s *nullSink) Stop() error {
+	s.worker.stop()
+	return nil
+}
+
+// memorySink 按实时节奏取走播放数据并保存在内存中，用于本地回环测试
+type memorySink struct {
+	worker     pacedWorker
+	mu         sync.Mutex
+	pcm        []byte
+	sampleRate int
+}
+
+func (s *memorySink) Start(sampleRate int, fill func(output []byte)) error {
+	s.sampleRate = sampleRate
+	frame := make([]byte, frameBytes(sampleRate))
+	s.worker.start(func() bool {
+		clear(frame)
+		fill(frame)
+		s.mu.Lock()
+		s.pcm = append(s.pcm, frame...)
+		s.mu.Unlock()
+		return true
+	})
+	return nil
+}
+
+func (s *memorySink) Stop() error {
+	s.worker.stop()
+	return nil
+}
+
+// Data 返回已播放的全部数据
+func (s *memorySink) Data() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.pcm...)
+}
+
+// wavFileSink 按实时节奏取走播放数据并写入 WAV 文件
+type wavFileSink struct {
+	path   string
+	worker pacedWorker
+	writer *wavWriter
+}
+
+func (s *wavFileSink) Start(sampleRate int, fill func(output []byte)) error {
+	writer, err := newWAVWriter(s.path, sampleRate, 1)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", s.path, err)
+	}
+	s.writer = writer
+	frame := make([]byte, frameBytes(sampleRate))
+	s.worker.start(func() bool {
+		clear(frame)
+		fill(frame)
+		if _, err := writer.Write(frame); err != nil {
+			return false
+		}
+		return true
+	})
+	return nil
+}
+
+func (s *wavFileSink) Stop() error {
+	s.worker.stop()
+	if s.writer == nil {
+		return nil
+	}
+	err := s.writer.Close()
+	s.writer = nil
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试 WAV 文件写入后读取
+func TestWAV_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wav")
+	writer, err := newWAVWriter(path, 16000, 1)
+	if err != nil {
+		t.Fatalf("newWAVWriter returned an error: %v", err)
+	}
+	pcm := make([]byte, 640)
+	for i := range pcm {
+		pcm[i] = byte(i)
+	}
+	writer.Write(pcm)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	data, sampleRate, err := readWAV(path)
+	if err != nil {
+		t.Fatalf("readWAV returned an error: %v", err)
+	}
+	if sampleRate != 16000 || len(data) != len(pcm) || data[100] != pcm[100] {
+		t.Errorf("unexpected WAV content: rate %d length %d", sampleRate, len(data))
+	}
+}
+
+// 测试 WAV 文件输入按实时节奏输出，输出写入 WAV 文件
+func TestWAVFileSourceAndSink(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.wav")
+	writer, _ := newWAVWriter(input, 8000, 1)
+	writer.Write(make([]byte, frameBytes(8000)*5))
+	writer.Close()
+
+	var mutex sync.Mutex
+	var captured []byte
+	source, _ := ParseAudioSource("file:"+input, audioDeviceConfig{})
+	if err := source.Start(8000, func(samples []byte) {
+		mutex.Lock()
+		captured = append(captured, samples...)
+		mutex.Unlock()
+	}); err != nil {
+		t.Fatalf("Start returned an error: %v", err)
+	}
+
+	output := filepath.Join(dir, "out.wav")
+	sink, _ := ParseAudioSink("file:"+output, audioDeviceConfig{})
+	if err := sink.Start(8000, func(output []byte) {}); err != nil {
+		t.Fatalf("Start returned an error: %v", err)
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	source.Stop()
+	sink.Stop()
+
+	mutex.Lock()
+	frames := len(captured) / frameBytes(8000)
+	mutex.Unlock()
+	if frames < 4 || frames > 6 {
+		t.Errorf("expected about 5 frames in 110ms, got %d", frames)
+	}
+	data, _, err := readWAV(output)
+	if err != nil {
+		t.Fatalf("readWAV returned an error: %v", err)
+	}
+	if len(data) == 0 {
+		t.Errorf("output file is empty")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// BridgeOptions 桥接两路通话时本地坐席的参与方式
+type BridgeOptions struct {
+	Listen bool // 继续在本地播放两路通话收到的音频，坐席可以旁听
+	Talk   bool // 本地麦克风音频继续混入两路通话，否则桥接期间只发送对方的音频
+}
+
+// bridgesMutex 串行化桥接的建立和断开，保证一路通话同时只参与一个桥接
+var bridgesMutex sync.Mutex
+
+// bridgePort 桥接中的一端，保存从另一端转来的音频，按本端编解码器的采样率缓冲
+type bridgePort struct {
+	peer      *bridgePort
+	queue     *captureQueue // 另一端收到的音频，由本端的发送协程取出发送
+	converter *resampler    // 把本端收到的音频转换为另一端的采样率
+	options   BridgeOptions
+}
+
+// forward 把本端收到并解码的音频转给另一端发送
+func (p *bridgePort) forward(pcm []byte) {
+	p.peer.queue.Write(p.converter.Process(pcm))
+}
+
+// Bridge 在进程内把两路通话的音频互相转发，用于咨询转接等场景
+// 桥接期间两路通话仍然可以各自注入本地音频
+type Bridge struct {
+	a, b   *MediaHandler
+	active bool
+}
+
+// BridgeCalls 桥接两个已协商编解码器的媒体处理器，a 收到的音频发给 b 的对方，b 收到的音频发给 a 的对方
+func BridgeCalls(a, b *MediaHandler, options BridgeOptions) (*Bridge, error) {
+	if a == b {
+		return nil, fmt.Errorf("cannot bridge a call with itself")
+	}
+	if a.codec == nil || b.codec == nil {
+		return nil, fmt.Errorf("codec is not negotiated")
+	}
+	portA := &bridgePort{queue: newCaptureQueue(a.codec.SampleRate), options: options}
+	portB := &bridgePort{queue: newCaptureQueue(b.codec.SampleRate), options: options}
+	portA.peer, portB.peer = portB, portA
+	portA.converter = newResampler(a.codec.SampleRate, b.codec.SampleRate)
+	portB.converter = newResampler(b.codec.SampleRate, a.codec.SampleRate)
+
+	bridgesMutex.Lock()
+	defer bridgesMutex.Unlock()
+	if a.Bridged() || b.Bridged() {
+		return nil, fmt.Errorf("call is already bridged")
+	}
+	a.setBridgePort(portA)
+	b.setBridgePort(portB)
+	a.logger.Infof("Bridged %s call with %s call", a.codec.Name, b.codec.Name)
+	return &Bridge{a: a, b: b, active: true}, nil
+}
+
+// Unbridge 断开桥接，两路通话恢复使用本地的麦克风和播放
+func (br *Bridge) Unbridge() {
+	bridgesMutex.Lock()
+	defer bridgesMutex.Unlock()
+	if !br.active {
+		return
+	}
+	br.active = false
+	br.a.setBridgePort(nil)
+	br.b.setBridgePort(nil)
+	br.a.logger.Info("Unbridged calls")
+}
+
+// bridgePort 返回当前的桥接端口，没有桥接时返回 nil
+func (mh *MediaHandler) bridgePort() *bridgePort {
+	mh.bridgeMutex.Lock()
+	defer mh.bridgeMutex.Unlock()
+	return mh.bridge
+}
+
+// setBridgePort 设置或清除桥接端口
+func (mh *MediaHandler) setBridgePort(port *bridgePort) {
+	mh.bridgeMutex.Lock()
+	mh.bridge = port
+	mh.bridgeMutex.Unlock()
+}
+
+// Bridged 是否正在与另一路通话桥接
+func (mh *MediaHandler) Bridged() bool {
+	return mh.bridgePort() != nil
+}
+
+// outbound 桥接时用另一端的音频替换一帧麦克风音频，允许坐席说话时把两者混合
+func (p *bridgePort) outbound(mic []byte) []byte {
+	frame := p.queue.Next()
+	if !p.options.Talk {
+		return frame
+	}
+	mixInto(frame, mic)
+	return frame
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 测试桥接两路编解码器不同的通话：一路对方说话，经过本进程转发后另一路对方能听到
+func TestBridgeCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	newHandler := func(sink AudioSink) *MediaHandler {
+		mh, err := NewMediaHandler(ctx, logger, WithAudioSource(&silenceSource{}), WithAudioSink(sink))
+		if err != nil {
+			t.Fatalf("NewMediaHandler returned an error: %v", err)
+		}
+		t.Cleanup(func() { mh.Stop() })
+		return mh
+	}
+	// 第一路使用 PCMU，第二路使用 G.722，本进程分别持有 agent1 和 agent2
+	remote1, agent1 := newHandler(&nullSink{}), newHandler(&nullSink{})
+	if err := connectLoopback(ctx, agent1, remote1, "pcmu", "pcmu"); err != nil {
+		t.Fatalf("failed to connect the first call: %v", err)
+	}
+	sink := &memorySink{}
+	remote2, agent2 := newHandler(sink), newHandler(&nullSink{})
+	if err := connectLoopback(ctx, agent2, remote2, "g722", "g722"); err != nil {
+		t.Fatalf("failed to connect the second call: %v", err)
+	}
+
+	bridge, err := BridgeCalls(agent1, agent2, BridgeOptions{})
+	if err != nil {
+		t.Fatalf("BridgeCalls returned an error: %v", err)
+	}
+	if !agent1.Bridged() || !agent2.Bridged() {
+		t.Fatal("expected both calls to be bridged")
+	}
+	if _, err := BridgeCalls(agent1, remote2, BridgeOptions{}); err == nil {
+		t.Error("expected an error when bridging a call twice")
+	}
+
+	// 第一路的对方说话，第二路的对方应该收到同样的音频
+	reference := loopbackTestSignal(8000, 2*time.Second)
+	offset := len(sink.Data())
+	injection, err := remote1.PlayPCM(reference, 8000, InjectReplace)
+	if err != nil {
+		t.Fatalf("PlayPCM returned an error: %v", err)
+	}
+	<-injection.Done()
+	time.Sleep(loopbackMaxLatency)
+	received := sink.Data()[offset:]
+
+	comparison := compareWaveforms(resamplePCM(reference, 8000, 16000), received, 16000)
+	if comparison.Score < 0.5 {
+		t.Errorf("expected the bridged audio to match, score %.3f", comparison.Score)
+	}
+	if latency := float64(comparison.Lag) * 1000 / 16000; latency <= 0 || latency > 1000 {
+		t.Errorf("unexpected bridge latency %.1fms", latency)
+	}
+
+	bridge.Unbridge()
+	bridge.Unbridge()
+	if agent1.Bridged() || agent2.Bridged() {
+		t.Error("expected both calls to be unbridged")
+	}
+}
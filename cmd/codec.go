@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"github.com/shenjinti/go711"
+	"github.com/shenjinti/go722"
+)
+
+// audioEncoder 把 S16LE 单声道 PCM 编码为 RTP 负载
+type audioEncoder interface {
+	Encode(pcm []byte) ([]byte, error)
+}
+
+// audioDecoder 把 RTP 负载解码为 S16LE 单声道 PCM
+type audioDecoder interface {
+	Decode(payload []byte) ([]byte, error)
+}
+
+// fecDecoder 由支持带内 FEC 的解码器实现，用丢失帧之后的那个包携带的冗余数据恢复丢失的帧
+type fecDecoder interface {
+	DecodeFEC(next []byte) ([]byte, error)
+}
+
+// audioCodec 描述一种可以协商的音频编解码器
+type audioCodec struct {
+	Name        string             // 命令行中使用的名称
+	MimeType    string             // WebRTC 中的 MIME 类型
+	ClockRate   uint32             // RTP 时钟频率
+	Channels    uint16             // SDP 中声明的声道数
+	PayloadType webrtc.PayloadType // offer 中使用的负载类型
+	SDPFmtpLine string             // SDP 中的 fmtp 参数
+	SampleRate  int                // 编码器输入、解码器输出的 PCM 采样率
+	newEncoder  func() (audioEncoder, error)
+	newDecoder  func() (audioDecoder, error)
+}
+
+// audioCodecs 支持的编解码器，opus 需要使用 -tags "opus nolibopusfile" 编译
+var audioCodecs = map[string]*audioCodec{
+	"g722": {
+		Name:        "g722",
+		MimeType:    webrtc.MimeTypeG722,
+		ClockRate:   8000, // RFC 3551：G.722 的 RTP 时钟为 8000，实际采样率为 16000
+		PayloadType: 9,
+		SampleRate:  16000,
+		newEncoder: func() (audioEncoder, error) {
+			return &g722Encoder{go722.NewG722Encoder(go722.Rate64000, 0)}, nil
+		},
+		newDecoder: func() (audioDecoder, error) {
+			return &g722Decoder{go722.NewG722Decoder(go722.Rate64000, 0)}, nil
+		},
+	},
+	"pcmu": {
+		Name:        "pcmu",
+		MimeType:    webrtc.MimeTypePCMU,
+		ClockRate:   8000,
+		PayloadType: 0,
+		SampleRate:  8000,
+		newEncoder:  func() (audioEncoder, error) { return g711Codec{go711.EncodePCMU}, nil },
+		newDecoder:  func() (audioDecoder, error) { return g711Codec{go711.DecodePCMU}, nil },
+	},
+	"pcma": {
+		Name:        "pcma",
+		MimeType:    webrtc.MimeTypePCMA,
+		ClockRate:   8000,
+		PayloadType: 8,
+		SampleRate:  8000,
+		newEncoder:  func() (audioEncoder, error) { return g711Codec{go711.EncodePCMA}, nil },
+		newDecoder:  func() (audioDecoder, error) { return g711Codec{go711.DecodePCMA}, nil },
+	},
+}
+
+// parseCodecList 解析按优先级排列的编解码器列表，例如 "opus,g722,pcmu"
+func parseCodecList(spec string) ([]*audioCodec, error) {
+	var codecs []*audioCodec
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		codec, ok := audioCodecs[name]
+		if !ok {
+			if name == "opus" {
+				return nil, fmt.Errorf("opus support is not compiled in, build with -tags \"opus nolibopusfile\"")
+			}
+			return nil, fmt.Errorf("unsupported codec: %s", name)
+		}
+		for _, existing := range codecs {
+			if existing == codec {
+				return nil, fmt.Errorf("duplicate codec: %s", name)
+			}
+		}
+		codecs = append(codecs, codec)
+	}
+	if len(codecs) == 0 {
+		return nil, fmt.Errorf("no codec given")
+	}
+	return codecs, nil
+}
+
+// codecByMimeType 根据 MIME 类型查找编解码器
+func codecByMimeType(mimeType string) *audioCodec {
+	for _, codec := range audioCodecs {
+		if strings.EqualFold(codec.MimeType, mimeType) {
+			return codec
+		}
+	}
+	return nil
+}
+
+// parameters 返回注册到 MediaEngine 的编解码器参数
+func (c *audioCodec) parameters() webrtc.RTPCodecParameters {
+	return webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    c.MimeType,
+			ClockRate:   c.ClockRate,
+			Channels:    c.Channels,
+			SDPFmtpLine: c.SDPFmtpLine,
+		},
+		PayloadType: c.PayloadType,
+	}
+}
+
+// negotiatedCodec 从 answer SDP 中音频媒体的第一个负载类型确定实际使用的编解码器
+func negotiatedCodec(answer string, offered []*audioCodec) (*audioCodec, error) {
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal([]byte(answer)); err != nil {
+		return nil, fmt.Errorf("failed to parse answer sdp: %w", err)
+	}
+	for _, media := range desc.MediaDescriptions {
+		if media.MediaName.Media != "audio" {
+			continue
+		}
+		for _, format := range media.MediaName.Formats {
+			payloadType, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+			name := ""
+			if codec, err := desc.GetCodecForPayloadType(uint8(payloadType)); err == nil {
+				name = codec.Name
+			} else if payloadType == 9 {
+				// G.722 是静态负载类型，answer 中可能没有 rtpmap
+				name = "G722"
+			}
+			for _, codec := range offered {
+				if strings.EqualFold("audio/"+name, codec.MimeType) {
+					return codec, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no offered codec in answer sdp")
+}
+
+// g711Codec 使用 go711 的编码/解码函数
+type g711Codec struct {
+	fn func([]byte) ([]byte, error)
+}
+
+func (c g711Codec) Encode(pcm []byte) ([]byte, error) {
+	return c.fn(pcm)
+}
+
+func (c g711Codec) Decode(payload []byte) ([]byte, error) {
+	return c.fn(payload)
+}
+
+type g722Encoder struct {
+	encoder *go722.G722Encoder
+}
+
+func (e *g722Encoder) Encode(pcm []byte) ([]byte, error) {
+	return e.encoder.Encode(pcm), nil
+}
+
+type g722Decoder struct {
+	decoder *go722.G722Decoder
+}
+
+func (d *g722Decoder) Decode(payload []byte) ([]byte, error) {
+	return d.decoder.Decode(payload), nil
+}
+
+// pcmToSamples 把 S16LE 字节转换为采样值
+func pcmToSamples(pcm []byte) []int16 {
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[i*2:]))
+	}
+	return samples
+}
+
+// samplesToPCM 把采样值转换为 S16LE 字节
+func samplesToPCM(samples []int16) []byte {
+	pcm := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(sample))
+	}
+	return pcm
+}
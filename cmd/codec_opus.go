@@ -0,0 +1,94 @@
+//go:build opus
+
+package main
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"gopkg.in/hraban/opus.v2"
+)
+
+// opus 依赖 libopus，使用 -tags "opus nolibopusfile" 编译时注册
+func init() {
+	audioCodecs["opus"] = &audioCodec{
+		Name:        "opus",
+		MimeType:    webrtc.MimeTypeOpus,
+		ClockRate:   48000,
+		Channels:    2, // RFC 7587：SDP 中 opus 总是声明为 2 声道，实际收发单声道
+		PayloadType: 111,
+		SDPFmtpLine: "minptime=10;useinbandfec=1",
+		SampleRate:  48000,
+		newEncoder:  newOpusEncoder,
+		newDecoder:  newOpusDecoder,
+	}
+}
+
+// opusMaxPacketSize 单个 opus 包的最大长度
+const opusMaxPacketSize = 1500
+
+type opusEncoder struct {
+	encoder *opus.Encoder
+	packet  []byte
+}
+
+// newOpusEncoder 创建开启带内 FEC 的语音编码器
+func newOpusEncoder() (audioEncoder, error) {
+	encoder, err := opus.NewEncoder(48000, 1, opus.AppVoIP)
+	if err != nil {
+		return nil, err
+	}
+	if err := encoder.SetInBandFEC(true); err != nil {
+		return nil, err
+	}
+	// 带内 FEC 只有在编码器预期有丢包时才生效
+	if err := encoder.SetPacketLossPerc(10); err != nil {
+		return nil, err
+	}
+	return &opusEncoder{encoder: encoder, packet: make([]byte, opusMaxPacketSize)}, nil
+}
+
+func (e *opusEncoder) Encode(pcm []byte) ([]byte, error) {
+	n, err := e.encoder.Encode(pcmToSamples(pcm), e.packet)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, e.packet[:n]...), nil
+}
+
+type opusDecoder struct {
+	decoder *opus.Decoder
+	samples []int16
+}
+
+func newOpusDecoder() (audioDecoder, error) {
+	decoder, err := opus.NewDecoder(48000, 1)
+	if err != nil {
+		return nil, err
+	}
+	// 最长 120ms
+	return &opusDecoder{decoder: decoder, samples: make([]int16, 48000*120/1000)}, nil
+}
+
+func (d *opusDecoder) Decode(payload []byte) ([]byte, error) {
+	n, err := d.decoder.Decode(payload, d.samples)
+	if err != nil {
+		return nil, err
+	}
+	return samplesToPCM(d.samples[:n]), nil
+}
+
+// DecodeFEC 用下一个包中的带内 FEC 数据恢复丢失的一帧，时长与上一个包相同
+// 下一个包没有 FEC 数据时 libopus 自动退化为丢包补偿
+func (d *opusDecoder) DecodeFEC(next []byte) ([]byte, error) {
+	n, err := d.decoder.LastPacketDuration()
+	if err != nil || n <= 0 || n > len(d.samples) {
+		n = 48000 * int(audioFrameDuration/time.Millisecond) / 1000
+	}
+	// libopus 按缓冲区容量决定恢复的时长
+	samples := d.samples[:n:n]
+	if err := d.decoder.DecodeFEC(next, samples); err != nil {
+		return nil, err
+	}
+	return samplesToPCM(samples), nil
+}
@@ -0,0 +1,67 @@
+//go:build opus
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// 测试 opus 编解码往返，丢失一个包时用下一个包的带内 FEC 恢复出完整一帧
+func TestOpus_FECRoundTrip(t *testing.T) {
+	codec := audioCodecs["opus"]
+	encoder, err := codec.newEncoder()
+	if err != nil {
+		t.Fatalf("failed to create encoder: %v", err)
+	}
+	decoder, err := codec.newDecoder()
+	if err != nil {
+		t.Fatalf("failed to create decoder: %v", err)
+	}
+	fec, ok := decoder.(fecDecoder)
+	if !ok {
+		t.Fatal("opus decoder does not support FEC")
+	}
+
+	// 20ms 一帧的 440Hz 正弦波
+	frameSamples := frameBytes(48000) / 2
+	var packets [][]byte
+	for frame := 0; frame < 20; frame++ {
+		samples := make([]int16, frameSamples)
+		for i := range samples {
+			n := frame*frameSamples + i
+			samples[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(n)/48000))
+		}
+		packet, err := encoder.Encode(samplesToPCM(samples))
+		if err != nil {
+			t.Fatalf("failed to encode frame %d: %v", frame, err)
+		}
+		packets = append(packets, packet)
+	}
+
+	const lost = 10
+	for i, packet := range packets {
+		if i == lost {
+			continue
+		}
+		if i == lost+1 {
+			recovered, err := fec.DecodeFEC(packet)
+			if err != nil {
+				t.Fatalf("DecodeFEC returned an error: %v", err)
+			}
+			if len(recovered) != frameBytes(48000) {
+				t.Errorf("expected a recovered frame of %d bytes, got %d", frameBytes(48000), len(recovered))
+			}
+			if rms(recovered, 0) < 1000 {
+				t.Errorf("recovered frame is too quiet: rms %.0f", rms(recovered, 0))
+			}
+		}
+		pcm, err := decoder.Decode(packet)
+		if err != nil {
+			t.Fatalf("failed to decode packet %d: %v", i, err)
+		}
+		if len(pcm) != frameBytes(48000) {
+			t.Errorf("expected %d bytes from packet %d, got %d", frameBytes(48000), i, len(pcm))
+		}
+	}
+}
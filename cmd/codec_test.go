@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// 测试编解码器列表解析
+func TestParseCodecList(t *testing.T) {
+	codecs, err := parseCodecList("PCMA, g722")
+	if err != nil {
+		t.Fatalf("parseCodecList returned an error: %v", err)
+	}
+	if len(codecs) != 2 || codecs[0].Name != "pcma" || codecs[1].Name != "g722" {
+		t.Errorf("unexpected codecs: %v", codecs)
+	}
+
+	for _, spec := range []string{"", "g729", "pcmu,pcmu"} {
+		if _, err := parseCodecList(spec); err == nil {
+			t.Errorf("parseCodecList(%q) should return an error", spec)
+		}
+	}
+}
+
+// 测试 PCMA 编码后解码长度不变，幅度接近原始数据
+func TestPCMA_RoundTrip(t *testing.T) {
+	codec := audioCodecs["pcma"]
+	encoder, _ := codec.newEncoder()
+	decoder, _ := codec.newDecoder()
+
+	samples := make([]int16, 160)
+	for i := range samples {
+		samples[i] = int16(i * 100)
+	}
+	payload, err := encoder.Encode(samplesToPCM(samples))
+	if err != nil {
+		t.Fatalf("Encode returned an error: %v", err)
+	}
+	if len(payload) != len(samples) {
+		t.Fatalf("unexpected payload length: %d", len(payload))
+	}
+	pcm, err := decoder.Decode(payload)
+	if err != nil {
+		t.Fatalf("Decode returned an error: %v", err)
+	}
+	decoded := pcmToSamples(pcm)
+	if len(decoded) != len(samples) {
+		t.Fatalf("unexpected decoded length: %d", len(decoded))
+	}
+	for i, sample := range decoded {
+		diff := int(sample) - int(samples[i])
+		if diff < -512 || diff > 512 {
+			t.Errorf("sample %d decoded as %d, expected about %d", i, sample, samples[i])
+			break
+		}
+	}
+}
+
+// 测试对方只支持 PCMA 时，answer 协商后切换到 PCMA
+func TestMediaHandler_NegotiateCodec(t *testing.T) {
+	handler, err := NewMediaHandler(context.Background(), logrus.New())
+	if err != nil {
+		t.Fatalf("NewMediaHandler returned an error: %v", err)
+	}
+	defer handler.Stop()
+
+	offerSdp, err := handler.Setup("g722,pcma", nil)
+	if err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+	if handler.codec.Name != "g722" {
+		t.Fatalf("expected g722 before negotiation, got %s", handler.codec.Name)
+	}
+
+	// 远端只注册 PCMA
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterCodec(audioCodecs["pcma"].parameters(), webrtc.RTPCodecTypeAudio)
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(&mediaEngine))
+	remote, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	defer remote.Close()
+
+	if err := remote.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSdp}); err != nil {
+		t.Fatalf("Failed to set remote offer: %v", err)
+	}
+	answer, err := remote.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create answer: %v", err)
+	}
+	if err := remote.SetLocalDescription(answer); err != nil {
+		t.Fatalf("Failed to set local answer: %v", err)
+	}
+	<-webrtc.GatheringCompletePromise(remote)
+
+	if err := handler.SetupAnswer(remote.LocalDescription().SDP); err != nil {
+		t.Fatalf("SetupAnswer returned an error: %v", err)
+	}
+	if handler.codec.Name != "pcma" {
+		t.Errorf("expected pcma after negotiation, got %s", handler.codec.Name)
+	}
+	if handler.audioTrack.Codec().MimeType != webrtc.MimeTypePCMA {
+		t.Errorf("audio track not replaced, got %s", handler.audioTrack.Codec().MimeType)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
@@ -16,6 +17,18 @@ import (
 type Config struct {
 	Endpoint         string
 	Codec            string
+	CallType         string
+	Callee           string
+	Caller           string
+	SipUsername      string
+	SipPassword      string
+	SipRealm         string
+	SipHeaders       map[string]string
+	AudioIn          string
+	AudioOut         string
+	DeviceRate       int
+	InputDevice      string
+	OutputDevice     string
 	BreakOnVad       bool
 	Speaker          string
 	Record           bool
@@ -35,11 +48,65 @@ type Config struct {
 	VADSecretKey     string
 	EndpointHost     string
 	EndpointSecurity bool
+	OpenaiKey        string
+	OpenaiEndpoint   string
+	OpenaiModel      string
+	SystemPrompt     string
+	StagesFile       string
+	FormFile         string
+	FormOutput       string
+	GuardrailsFile   string
+	CallRecordDir    string
+	Summary          bool
+	RecordLocal      string
+	RecordSplit      bool
+	Trickle          bool
+	QualityInterval  time.Duration
+	LocalVAD         bool
+	VADThreshold     float64
+	VADMinSpeech     time.Duration
+	VADHangover      time.Duration
+	AEC              bool
+	AECTail          time.Duration
+	AGC              bool
+	AGCTarget        float64
+	AGCMaxGain       float64
+	AGCAttack        time.Duration
+	AGCRelease       time.Duration
+	NoiseGate        float64
+	InjectFile       string
+	InjectMode       string
+	InjectRate       int
+	SupervisorListen string
+	SupervisorDevice string
+	SupervisorMode   string
+	SupervisorToken  string
 	Logger           *logrus.Logger
 	Ctx              context.Context
 	Cancel           context.CancelFunc
 }
 
+// headerFlag 可重复的 Name=Value 参数，例如 --sip-header X-Tenant=demo
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	var pairs []string
+	for name, value := range h {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h headerFlag) Set(value string) error {
+	name, headerValue, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid header %q, expected Name=Value", value)
+	}
+	h[name] = strings.TrimSpace(headerValue)
+	return nil
+}
+
 func LoadConfig() (*Config, error) {
 	// 加载 .env 环境变量文件
 	godotenv.Load()
@@ -47,7 +114,19 @@ func LoadConfig() (*Config, error) {
 	// 命令行设置
 	// ws://175.27.250.177:8080
 	var endpoint string = "ws://175.27.250.177:8080"
-	var codec string = "g722"
+	var codec string = "g722,pcmu,pcma"
+	var callType string = "webrtc"
+	var callee string = ""
+	var caller string = ""
+	var sipUsername string = ""
+	var sipPassword string = os.Getenv("SIP_PASSWORD")
+	var sipRealm string = ""
+	var sipHeaders headerFlag = headerFlag{}
+	var audioIn string = "device"
+	var audioOut string = "device"
+	var deviceRate int = 0
+	var inputDevice string = ""
+	var outputDevice string = ""
 	var breakOnVad bool = false
 	var speaker string = "601003"
 	var record bool = false
@@ -65,11 +144,59 @@ func LoadConfig() (*Config, error) {
 	var vadModel string = "silero"
 	var vadEndpoint string = ""
 	var vadSecretKey string = ""
+	var openaiKey string = os.Getenv("OPENAI_API_KEY")
+	var openaiEndpoint string = os.Getenv("OPENAI_ENDPOINT")
+	if openaiEndpoint == "" {
+		openaiEndpoint = "https://api.openai.com/v1"
+	}
+	var openaiModel string = os.Getenv("OPENAI_MODEL")
+	var systemPrompt string = "You are a helpful assistant. Keep your answers short and conversational."
+	var stagesFile string = ""
+	var formFile string = ""
+	var formOutput string = "forms"
+	var guardrailsFile string = ""
+	var callRecordDir string = "records"
+	var summary bool = true
+	var recordLocal string = ""
+	var recordSplit bool = false
+	var trickle bool = false
+	var qualityInterval time.Duration = 5 * time.Second
+	var localVAD bool = false
+	var vadThreshold float64 = DefaultVADConfig().ThresholdDB
+	var vadMinSpeech time.Duration = DefaultVADConfig().MinSpeech
+	var vadHangover time.Duration = DefaultVADConfig().Hangover
+	var aec bool = false
+	var aecTail time.Duration = DefaultEchoTail
+	var agc bool = false
+	var agcTarget float64 = DefaultAGCConfig().TargetDB
+	var agcMaxGain float64 = DefaultAGCConfig().MaxGainDB
+	var agcAttack time.Duration = DefaultAGCConfig().Attack
+	var agcRelease time.Duration = DefaultAGCConfig().Release
+	var noiseGate float64 = 0
+	var injectFile string = ""
+	var injectMode string = "mix"
+	var injectRate int = 16000
+	var supervisorListen string = ""
+	var supervisorDevice string = ""
+	var supervisorMode string = "listen"
+	var supervisorToken string = os.Getenv("SUPERVISOR_TOKEN")
 
 	// 解析命令行参数，初始化各类变量
 	// 作用：加载.env文件中的环境变量，并通过命令行参数或默认值初始化所有配置项
 	flag.StringVar(&endpoint, "endpoint", endpoint, "Endpoint to connect to")
-	flag.StringVar(&codec, "codec", codec, "Codec to use: g722, pcmu")
+	flag.StringVar(&codec, "codec", codec, "Codecs to offer in priority order: opus, g722, pcmu, pcma (opus requires -tags \"opus nolibopusfile\")")
+	flag.StringVar(&callType, "call-type", callType, "Call type: webrtc, websocket to carry the audio over the signaling connection when UDP/ICE is blocked, or sip to let the server call --callee")
+	flag.StringVar(&callee, "callee", callee, "SIP URI the server calls with --call-type sip, e.g. sip:1001@pbx.example.com")
+	flag.StringVar(&caller, "caller", caller, "Caller ID presented to the callee with --call-type sip")
+	flag.StringVar(&sipUsername, "sip-username", sipUsername, "Username for SIP digest authentication")
+	flag.StringVar(&sipPassword, "sip-password", sipPassword, "Password for SIP digest authentication, defaults to $SIP_PASSWORD")
+	flag.StringVar(&sipRealm, "sip-realm", sipRealm, "Realm for SIP digest authentication")
+	flag.Var(sipHeaders, "sip-header", "Custom header added to the SIP INVITE as Name=Value, can be repeated")
+	flag.StringVar(&audioIn, "audio-in", audioIn, "Audio input: device, silence, file:<path.wav>")
+	flag.StringVar(&audioOut, "audio-out", audioOut, "Audio output: device, null, file:<path.wav>")
+	flag.StringVar(&inputDevice, "input-device", inputDevice, "Capture device ID or name to use with --audio-in device, see the devices subcommand")
+	flag.StringVar(&outputDevice, "output-device", outputDevice, "Playback device ID or name to use with --audio-out device, see the devices subcommand")
+	flag.IntVar(&deviceRate, "device-rate", deviceRate, "Sample rate to open the sound card with, audio is resampled to the codec rate (0 uses the device native rate)")
 	flag.BoolVar(&breakOnVad, "break-on-vad", breakOnVad, "Break on VAD")
 	flag.BoolVar(&record, "record", record, "Record the call")
 	flag.StringVar(&ttsProvider, "tts", ttsProvider, "TTS provider to use: tencent, voiceapi")
@@ -87,6 +214,39 @@ func LoadConfig() (*Config, error) {
 	flag.StringVar(&vadModel, "vad-model", vadModel, "VAD model to use")
 	flag.StringVar(&vadEndpoint, "vad-endpoint", vadEndpoint, "VAD endpoint to use")
 	flag.StringVar(&vadSecretKey, "vad-secret-key", vadSecretKey, "VAD secret key to use")
+	flag.StringVar(&openaiKey, "openai-key", openaiKey, "OpenAI API key, the LLM agent is disabled when empty")
+	flag.StringVar(&openaiEndpoint, "openai-endpoint", openaiEndpoint, "OpenAI endpoint to use")
+	flag.StringVar(&openaiModel, "openai-model", openaiModel, "OpenAI model to use")
+	flag.StringVar(&systemPrompt, "system-prompt", systemPrompt, "System prompt of the LLM agent")
+	flag.StringVar(&stagesFile, "stages", stagesFile, "JSON file describing the stages (personas) of the LLM agent")
+	flag.StringVar(&formFile, "form", formFile, "JSON schema of the slots to collect in form-filling mode")
+	flag.StringVar(&formOutput, "form-output", formOutput, "Directory the collected forms are written to at hangup")
+	flag.StringVar(&guardrailsFile, "guardrails", guardrailsFile, "JSON file with the output guardrails applied before TTS")
+	flag.StringVar(&callRecordDir, "call-record-dir", callRecordDir, "Directory the call records are written to when the call ends")
+	flag.BoolVar(&summary, "summary", summary, "Generate a post-call summary with the LLM when the call ends")
+	flag.StringVar(&recordLocal, "record-local", recordLocal, "Directory to record the call locally as a stereo WAV (left: caller, right: agent)")
+	flag.BoolVar(&recordSplit, "record-split", recordSplit, "Also write a mono WAV per direction when recording locally")
+	flag.BoolVar(&trickle, "trickle", trickle, "Send the offer without waiting for ICE gathering and trickle candidates to the server")
+	flag.DurationVar(&qualityInterval, "quality-interval", qualityInterval, "Interval to log call quality statistics and MOS, 0 to disable")
+	flag.BoolVar(&localVAD, "local-vad", localVAD, "Detect speech on the microphone locally to stop playback and interrupt TTS without waiting for the server")
+	flag.Float64Var(&vadThreshold, "local-vad-threshold", vadThreshold, "Minimum microphone level in dBFS treated as speech by the local VAD")
+	flag.DurationVar(&vadMinSpeech, "local-vad-min-speech", vadMinSpeech, "Continuous speech required before the local VAD fires")
+	flag.DurationVar(&vadHangover, "local-vad-hangover", vadHangover, "Silence required before the local VAD considers speech ended")
+	flag.BoolVar(&aec, "aec", aec, "Cancel the speaker echo picked up by the microphone, for laptop speakers and speakerphones")
+	flag.DurationVar(&aecTail, "aec-tail", aecTail, "Echo path length covered by the echo canceller, including sound card latency")
+	flag.BoolVar(&agc, "agc", agc, "Automatically adjust the microphone gain before encoding")
+	flag.Float64Var(&agcTarget, "agc-target", agcTarget, "Target speech level of the AGC in dBFS")
+	flag.Float64Var(&agcMaxGain, "agc-max-gain", agcMaxGain, "Maximum gain of the AGC in dB")
+	flag.DurationVar(&agcAttack, "agc-attack", agcAttack, "Time constant of the AGC when lowering the gain")
+	flag.DurationVar(&agcRelease, "agc-release", agcRelease, "Time constant of the AGC when raising the gain")
+	flag.StringVar(&injectFile, "inject-file", injectFile, "Local WAV or raw S16LE mono file to stream into the call once it is answered")
+	flag.StringVar(&injectMode, "inject-mode", injectMode, "How the injected audio is combined with the microphone: mix, replace")
+	flag.IntVar(&injectRate, "inject-rate", injectRate, "Sample rate of a raw PCM --inject-file")
+	flag.StringVar(&supervisorListen, "supervisor-listen", supervisorListen, "Address to accept supervisor websocket audio streams on, e.g. 127.0.0.1:8090, served at /supervisor?mode=listen|whisper|barge&token=...; a bare :port binds to loopback only")
+	flag.StringVar(&supervisorDevice, "supervisor-device", supervisorDevice, "ID or name of a local audio device used by a supervisor, empty to disable")
+	flag.StringVar(&supervisorToken, "supervisor-token", supervisorToken, "Token supervisors must send as ?token= or Authorization: Bearer, defaults to $SUPERVISOR_TOKEN")
+	flag.StringVar(&supervisorMode, "supervisor-mode", supervisorMode, "Initial mode of the --supervisor-device: listen, whisper, barge")
+	flag.Float64Var(&noiseGate, "noise-gate", noiseGate, "Mute the microphone below this level in dBFS, e.g. -55, 0 disables the gate")
 
 	flag.Parse()                  // 解析命令行参数
 	u, err := url.Parse(endpoint) // 解析URL字符串
@@ -108,6 +268,18 @@ func LoadConfig() (*Config, error) {
 	config := &Config{
 		Endpoint:         endpoint,
 		Codec:            codec,
+		CallType:         callType,
+		Callee:           callee,
+		Caller:           caller,
+		SipUsername:      sipUsername,
+		SipPassword:      sipPassword,
+		SipRealm:         sipRealm,
+		SipHeaders:       sipHeaders,
+		AudioIn:          audioIn,
+		AudioOut:         audioOut,
+		DeviceRate:       deviceRate,
+		InputDevice:      inputDevice,
+		OutputDevice:     outputDevice,
 		BreakOnVad:       breakOnVad,
 		Speaker:          speaker,
 		Record:           record,
@@ -127,6 +299,39 @@ func LoadConfig() (*Config, error) {
 		VADSecretKey:     vadSecretKey,
 		EndpointHost:     endpointHost,
 		EndpointSecurity: endpointSecurity,
+		OpenaiKey:        openaiKey,
+		OpenaiEndpoint:   openaiEndpoint,
+		OpenaiModel:      openaiModel,
+		SystemPrompt:     systemPrompt,
+		StagesFile:       stagesFile,
+		FormFile:         formFile,
+		FormOutput:       formOutput,
+		GuardrailsFile:   guardrailsFile,
+		CallRecordDir:    callRecordDir,
+		Summary:          summary,
+		RecordLocal:      recordLocal,
+		RecordSplit:      recordSplit,
+		Trickle:          trickle,
+		LocalVAD:         localVAD,
+		VADThreshold:     vadThreshold,
+		VADMinSpeech:     vadMinSpeech,
+		VADHangover:      vadHangover,
+		AEC:              aec,
+		AECTail:          aecTail,
+		AGC:              agc,
+		AGCTarget:        agcTarget,
+		AGCMaxGain:       agcMaxGain,
+		AGCAttack:        agcAttack,
+		AGCRelease:       agcRelease,
+		NoiseGate:        noiseGate,
+		InjectFile:       injectFile,
+		InjectMode:       injectMode,
+		InjectRate:       injectRate,
+		SupervisorListen: supervisorListen,
+		SupervisorDevice: supervisorDevice,
+		SupervisorMode:   supervisorMode,
+		SupervisorToken:  supervisorToken,
+		QualityInterval:  qualityInterval,
 		Logger:           logger,
 		Ctx:              ctx,
 		Cancel:           cancel,
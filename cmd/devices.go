@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gen2brain/malgo"
+)
+
+// deviceEntry 声卡设备的 ID 和名称，用于按 --input-device/--output-device 选择设备
+type deviceEntry struct {
+	ID   string
+	Name string
+}
+
+// matchDevice 在设备列表中查找 spec 指定的设备，依次按 ID、完整名称、名称片段匹配，忽略大小写
+// 名称片段匹配到多个设备时返回错误，避免选错设备
+func matchDevice(entries []deviceEntry, spec string) (int, error) {
+	for i, entry := range entries {
+		if strings.EqualFold(entry.ID, spec) {
+			return i, nil
+		}
+	}
+	for i, entry := range entries {
+		if strings.EqualFold(entry.Name, spec) {
+			return i, nil
+		}
+	}
+	found := -1
+	for i, entry := range entries {
+		if strings.Contains(strings.ToLower(entry.Name), strings.ToLower(spec)) {
+			if found >= 0 {
+				return -1, fmt.Errorf("audio device %q is ambiguous: %q and %q", spec, entries[found].Name, entry.Name)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, fmt.Errorf("audio device %q not found, run the devices subcommand to list devices", spec)
+	}
+	return found, nil
+}
+
+// findDevice 返回 spec 指定的设备 ID，spec 为空或 default 时返回 nil 表示使用默认设备
+func findDevice(audioCtx *malgo.AllocatedContext, kind malgo.DeviceType, spec string) (*malgo.DeviceID, error) {
+	if spec == "" || spec == "default" {
+		return nil, nil
+	}
+	devices, err := audioCtx.Devices(kind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audio devices: %w", err)
+	}
+	entries := make([]deviceEntry, len(devices))
+	for i, device := range devices {
+		entries[i] = deviceEntry{ID: device.ID.String(), Name: device.Name()}
+	}
+	index, err := matchDevice(entries, spec)
+	if err != nil {
+		return nil, err
+	}
+	return &devices[index].ID, nil
+}
+
+// formatName 采样格式的名称
+func formatName(format malgo.FormatType) string {
+	switch format {
+	case malgo.FormatU8:
+		return "u8"
+	case malgo.FormatS16:
+		return "s16"
+	case malgo.FormatS24:
+		return "s24"
+	case malgo.FormatS32:
+		return "s32"
+	case malgo.FormatF32:
+		return "f32"
+	}
+	return "any format"
+}
+
+// describeFormats 把设备支持的原生格式转换为可读的字符串，0 表示设备支持任意值
+func describeFormats(formats []malgo.DataFormat) string {
+	if len(formats) == 0 {
+		return "unknown"
+	}
+	var parts []string
+	for _, format := range formats {
+		channels, rate := "any channels", "any rate"
+		if format.Channels > 0 {
+			channels = fmt.Sprintf("%dch", format.Channels)
+		}
+		if format.SampleRate > 0 {
+			rate = fmt.Sprintf("%dHz", format.SampleRate)
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %s", formatName(format.Format), channels, rate))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runDevices devices 子命令的入口，列出采集和播放设备，返回进程退出码
+func runDevices(args []string) int {
+	flags := flag.NewFlagSet("devices", flag.ExitOnError)
+	flags.Parse(args)
+
+	audioCtx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize audio context: %v\n", err)
+		return 1
+	}
+	defer func() {
+		audioCtx.Uninit()
+		audioCtx.Free()
+	}()
+
+	for _, kind := range []struct {
+		title string
+		kind  malgo.DeviceType
+		flag  string
+	}{
+		{"Capture devices", malgo.Capture, "--input-device"},
+		{"Playback devices", malgo.Playback, "--output-device"},
+	} {
+		devices, err := audioCtx.Devices(kind.kind)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list %s: %v\n", strings.ToLower(kind.title), err)
+			return 1
+		}
+		fmt.Printf("%s (select with %s <id or name>):\n", kind.title, kind.flag)
+		if len(devices) == 0 {
+			fmt.Println("  none")
+		}
+		for _, device := range devices {
+			// 列表中只有基本信息，支持的格式需要单独查询
+			formats := device.Formats
+			if info, err := audioCtx.DeviceInfo(kind.kind, device.ID, malgo.Shared); err == nil {
+				formats = info.Formats
+			}
+			marker := " "
+			if device.IsDefault != 0 {
+				marker = "*"
+			}
+			fmt.Printf(" %s %s  %s\n      formats: %s\n", marker, device.ID.String(), device.Name(), describeFormats(formats))
+		}
+	}
+	fmt.Println("* default device")
+	return 0
+}
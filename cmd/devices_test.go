@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+// 测试按 ID、名称和名称片段选择声卡设备，片段匹配到多个设备时报错
+func TestMatchDevice(t *testing.T) {
+	entries := []deviceEntry{
+		{ID: "0a01", Name: "Built-in Microphone"},
+		{ID: "0b02", Name: "USB Headset Microphone"},
+		{ID: "0c03", Name: "USB Headset"},
+	}
+	cases := []struct {
+		spec  string
+		index int
+	}{
+		{"0B02", 1},
+		{"usb headset", 2},
+		{"built-in", 0},
+	}
+	for _, c := range cases {
+		index, err := matchDevice(entries, c.spec)
+		if err != nil || index != c.index {
+			t.Errorf("matchDevice(%q) = %d, %v, want %d", c.spec, index, err, c.index)
+		}
+	}
+	if _, err := matchDevice(entries, "microphone"); err == nil {
+		t.Errorf("expected an error for an ambiguous device name")
+	}
+	if _, err := matchDevice(entries, "speaker"); err == nil {
+		t.Errorf("expected an error for an unknown device")
+	}
+}
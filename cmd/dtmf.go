@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// mimeTypeTelephoneEvent RFC 4733 DTMF 事件的 MIME 类型
+const mimeTypeTelephoneEvent = "audio/telephone-event"
+
+const (
+	// dtmfDigits 事件编号 0-15 对应的按键
+	dtmfDigits = "0123456789*#ABCD"
+	// dtmfVolume 发送事件的音量，单位为 -dBm0
+	dtmfVolume = 10
+	// dtmfEndPackets 结束包重复发送的次数，降低丢包的影响
+	dtmfEndPackets = 3
+	// dtmfGap 两个按键之间的间隔
+	dtmfGap = 50 * time.Millisecond
+	// DefaultDTMFDuration 每个按键的默认时长
+	DefaultDTMFDuration = 100 * time.Millisecond
+	// dtmfMaxSegment 一个事件段能表示的最长时长，单位为时钟周期
+	dtmfMaxSegment = 0xffff
+)
+
+// telephoneEventCodecs 为每种音频时钟频率生成一个 telephone-event 编解码器，事件与音频必须使用相同的时钟
+func telephoneEventCodecs(codecs []*audioCodec) []webrtc.RTPCodecParameters {
+	var events []webrtc.RTPCodecParameters
+	seen := map[uint32]bool{}
+	for _, codec := range codecs {
+		if seen[codec.ClockRate] {
+			continue
+		}
+		seen[codec.ClockRate] = true
+		// 8000 时钟使用常见的 101，opus 的 48000 时钟使用 110
+		payloadType := webrtc.PayloadType(101)
+		if codec.ClockRate != 8000 {
+			payloadType = 110
+		}
+		events = append(events, webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    mimeTypeTelephoneEvent,
+				ClockRate:   codec.ClockRate,
+				SDPFmtpLine: "0-16",
+			},
+			PayloadType: payloadType,
+		})
+	}
+	return events
+}
+
+// dtmfEvent RFC 4733 2.3 中的事件负载
+type dtmfEvent struct {
+	Event    byte
+	End      bool
+	Volume   byte
+	Duration uint16
+}
+
+func (e dtmfEvent) marshal() []byte {
+	payload := make([]byte, 4)
+	payload[0] = e.Event
+	payload[1] = e.Volume & 0x3f
+	if e.End {
+		payload[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(payload[2:], e.Duration)
+	return payload
+}
+
+func parseDTMFEvent(payload []byte) (dtmfEvent, error) {
+	if len(payload) < 4 {
+		return dtmfEvent{}, fmt.Errorf("telephone-event payload too short: %d bytes", len(payload))
+	}
+	return dtmfEvent{
+		Event:    payload[0],
+		End:      payload[1]&0x80 != 0,
+		Volume:   payload[1] & 0x3f,
+		Duration: binary.BigEndian.Uint16(payload[2:]),
+	}, nil
+}
+
+// SendDTMF 把按键作为 RFC 4733 telephone-event 在媒体流中发送，发送完成后返回
+// 对方没有协商 telephone-event 时返回错误
+func (mh *MediaHandler) SendDTMF(digits string, duration time.Duration) error {
+	digits = strings.ToUpper(digits)
+	for _, digit := range digits {
+		if !strings.ContainsRune(dtmfDigits, digit) {
+			return fmt.Errorf("invalid DTMF digit: %q", digit)
+		}
+	}
+	if duration <= 0 {
+		duration = DefaultDTMFDuration
+	}
+	track := mh.audioTrack
+	if track == nil || !track.canSendEvents() {
+		return fmt.Errorf("telephone-event is not negotiated")
+	}
+
+	// 同一时间只发送一个按键序列
+	mh.dtmfMutex.Lock()
+	defer mh.dtmfMutex.Unlock()
+	for i, digit := range digits {
+		if i > 0 {
+			time.Sleep(dtmfGap)
+		}
+		if err := sendDTMFEvent(track, byte(strings.IndexRune(dtmfDigits, digit)), duration); err != nil {
+			return err
+		}
+		mh.logger.Infof("Sent DTMF digit %c", digit)
+	}
+	return nil
+}
+
+// sendDTMFEvent 每 20 毫秒发送一个事件包，时长逐包增加，最后重复发送结束包
+// 时长超过 16 位能表示的范围时按 RFC 4733 2.5.1.3 拆成多个段
+func sendDTMFEvent(track *rtpAudioTrack, event byte, duration time.Duration) error {
+	timestamp := track.beginEvent()
+	defer track.endEvent()
+	step := uint32(audioFrameDuration.Seconds() * float64(track.codec.ClockRate))
+	total := uint32(duration.Seconds() * float64(track.codec.ClockRate))
+	ticker := time.NewTicker(audioFrameDuration)
+	defer ticker.Stop()
+	var segment uint32
+	for elapsed := step; ; elapsed += step {
+		end := elapsed >= total
+		if end {
+			elapsed = total
+		}
+		offset, length := dtmfSegment(elapsed)
+		// 进入新的段之前，用满长度且不带结束标志的包结束上一段
+		if offset != segment {
+			payload := dtmfEvent{Event: event, Volume: dtmfVolume, Duration: dtmfMaxSegment}.marshal()
+			if err := track.writeEvent(false, timestamp+segment, payload); err != nil {
+				return err
+			}
+			segment = offset
+		}
+		payload := dtmfEvent{Event: event, End: end, Volume: dtmfVolume, Duration: length}.marshal()
+		packets := 1
+		if end {
+			packets = dtmfEndPackets
+		}
+		for i := 0; i < packets; i++ {
+			if err := track.writeEvent(elapsed == step, timestamp+segment, payload); err != nil {
+				return err
+			}
+		}
+		if end {
+			return nil
+		}
+		<-ticker.C
+	}
+}
+
+// dtmfSegment 返回事件已持续 elapsed 个时钟周期时所在段的时间戳偏移和段内时长
+// 每段的时间戳比上一段增加 0xffff，段内时长从新的时间戳开始计算
+func dtmfSegment(elapsed uint32) (uint32, uint16) {
+	if elapsed == 0 {
+		return 0, 0
+	}
+	offset := (elapsed - 1) / dtmfMaxSegment * dtmfMaxSegment
+	return offset, uint16(elapsed - offset)
+}
+
+// dtmfDetector 从入站 telephone-event 包中识别按键，同一事件的多个包和重复的结束包只报告一次
+type dtmfDetector struct {
+	payloadTypes map[uint8]bool
+	seen         bool
+	timestamp    uint32
+	event        byte
+	ended        bool
+}
+
+// newDTMFDetector 根据协商的编解码器创建检测器
+func newDTMFDetector(codecs []webrtc.RTPCodecParameters) *dtmfDetector {
+	d := &dtmfDetector{payloadTypes: map[uint8]bool{}}
+	for _, codec := range codecs {
+		if strings.EqualFold(codec.MimeType, mimeTypeTelephoneEvent) {
+			d.payloadTypes[uint8(codec.PayloadType)] = true
+		}
+	}
+	return d
+}
+
+// isEvent 判断包是否为 telephone-event
+func (d *dtmfDetector) isEvent(packet *rtp.Packet) bool {
+	return d.payloadTypes[packet.PayloadType]
+}
+
+// detect 处理一个 telephone-event 包，新事件开始时返回按键
+func (d *dtmfDetector) detect(packet *rtp.Packet) (string, bool) {
+	event, err := parseDTMFEvent(packet.Payload)
+	if err != nil || int(event.Event) >= len(dtmfDigits) {
+		return "", false
+	}
+	if d.seen && packet.Timestamp == d.timestamp {
+		d.ended = d.ended || event.End
+		return "", false
+	}
+	// 长按拆成的后续段时间戳增加 0xffff，属于同一个按键
+	continued := d.seen && !d.ended && event.Event == d.event && packet.Timestamp == d.timestamp+dtmfMaxSegment
+	d.seen = true
+	d.timestamp = packet.Timestamp
+	d.event = event.Event
+	d.ended = event.End
+	if continued {
+		return "", false
+	}
+	return string(dtmfDigits[event.Event]), true
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/sirupsen/logrus"
+)
+
+// 测试事件负载编解码，以及同一事件的多个包只识别一次
+func TestDTMFDetector(t *testing.T) {
+	payload := dtmfEvent{Event: 11, End: true, Volume: 10, Duration: 800}.marshal()
+	event, err := parseDTMFEvent(payload)
+	if err != nil || event.Event != 11 || !event.End || event.Volume != 10 || event.Duration != 800 {
+		t.Fatalf("unexpected event: %+v, err %v", event, err)
+	}
+
+	detector := newDTMFDetector(telephoneEventCodecs([]*audioCodec{audioCodecs["pcmu"]}))
+	var digits string
+	for i, ts := range []uint32{160, 160, 160, 960, 960} {
+		packet := &rtp.Packet{
+			Header:  rtp.Header{PayloadType: 101, SequenceNumber: uint16(i), Timestamp: ts},
+			Payload: dtmfEvent{Event: byte(i / 3 * 11), Duration: 160}.marshal(),
+		}
+		if !detector.isEvent(packet) {
+			t.Fatalf("packet with payload type 101 not detected as telephone-event")
+		}
+		if digit, ok := detector.detect(packet); ok {
+			digits += digit
+		}
+	}
+	if digits != "0#" {
+		t.Errorf("expected digits 0#, got %q", digits)
+	}
+}
+
+// 测试超过 16 位时长的长按拆成多个段，接收端只识别一次
+func TestDTMFSegment(t *testing.T) {
+	for _, c := range []struct {
+		elapsed uint32
+		offset  uint32
+		length  uint16
+	}{
+		{960, 0, 960},
+		{0xffff, 0, 0xffff},
+		{0x10000, 0xffff, 1},
+		{0xffff*2 + 960, 0xffff * 2, 960},
+	} {
+		offset, length := dtmfSegment(c.elapsed)
+		if offset != c.offset || length != c.length {
+			t.Errorf("dtmfSegment(%d) = %d, %d, expected %d, %d", c.elapsed, offset, length, c.offset, c.length)
+		}
+	}
+
+	detector := newDTMFDetector(telephoneEventCodecs([]*audioCodec{audioCodecs["pcmu"]}))
+	var digits string
+	for i, p := range []struct {
+		ts    uint32
+		event byte
+		end   bool
+	}{
+		{160, 5, false},
+		{160 + 0xffff, 5, false},
+		{160 + 0xffff, 5, true},
+		{160 + 0xffff*2, 5, false},
+	} {
+		packet := &rtp.Packet{
+			Header:  rtp.Header{PayloadType: 101, SequenceNumber: uint16(i), Timestamp: p.ts},
+			Payload: dtmfEvent{Event: p.event, End: p.end, Duration: 160}.marshal(),
+		}
+		if digit, ok := detector.detect(packet); ok {
+			digits += digit
+		}
+	}
+	if digits != "55" {
+		t.Errorf("expected digits 55, got %q", digits)
+	}
+}
+
+// 测试与对端之间通过 telephone-event 收发 DTMF
+func TestMediaHandler_DTMF(t *testing.T) {
+	handler, err := NewMediaHandler(context.Background(), logrus.New(),
+		WithAudioSource(&silenceSource{}), WithAudioSink(&nullSink{}))
+	if err != nil {
+		t.Fatalf("NewMediaHandler returned an error: %v", err)
+	}
+	defer handler.Stop()
+	received := make(chan string, 4)
+	handler.OnDTMF = func(digit string) { received <- digit }
+
+	offerSdp, err := handler.Setup("pcmu", nil)
+	if err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+
+	// 对端同样支持 PCMU 和 telephone-event
+	mediaEngine := webrtc.MediaEngine{}
+	pcmu := audioCodecs["pcmu"]
+	mediaEngine.RegisterCodec(pcmu.parameters(), webrtc.RTPCodecTypeAudio)
+	for _, event := range telephoneEventCodecs([]*audioCodec{pcmu}) {
+		mediaEngine.RegisterCodec(event, webrtc.RTPCodecTypeAudio)
+	}
+	remote, err := webrtc.NewAPI(webrtc.WithMediaEngine(&mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	defer remote.Close()
+	remoteTrack := newRTPAudioTrack(pcmu.parameters().RTPCodecCapability)
+	if _, err := remote.AddTrack(remoteTrack); err != nil {
+		t.Fatalf("Failed to add remote track: %v", err)
+	}
+	remoteEvents := make(chan dtmfEvent, 64)
+	remote.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			packet, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			if packet.PayloadType == 101 {
+				event, _ := parseDTMFEvent(packet.Payload)
+				remoteEvents <- event
+			}
+		}
+	})
+	connected := make(chan struct{})
+	remote.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			close(connected)
+		}
+	})
+
+	if err := remote.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSdp}); err != nil {
+		t.Fatalf("Failed to set remote offer: %v", err)
+	}
+	answer, _ := remote.CreateAnswer(nil)
+	remote.SetLocalDescription(answer)
+	<-webrtc.GatheringCompletePromise(remote)
+	if err := handler.SetupAnswer(remote.LocalDescription().SDP); err != nil {
+		t.Fatalf("SetupAnswer returned an error: %v", err)
+	}
+	select {
+	case <-connected:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("peer connection not connected")
+	}
+
+	// 对端先发几帧音频，再发送按键 7
+	for i := 0; i < 5; i++ {
+		remoteTrack.WriteSample(media.Sample{Data: make([]byte, 160), Duration: audioFrameDuration})
+		time.Sleep(audioFrameDuration)
+	}
+	if err := sendDTMFEvent(remoteTrack, 7, 60*time.Millisecond); err != nil {
+		t.Fatalf("sendDTMFEvent returned an error: %v", err)
+	}
+	select {
+	case digit := <-received:
+		if digit != "7" {
+			t.Errorf("expected digit 7, got %s", digit)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("in-band DTMF not received")
+	}
+
+	// 本端发送按键 #
+	if err := handler.SendDTMF("#", 60*time.Millisecond); err != nil {
+		t.Fatalf("SendDTMF returned an error: %v", err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-remoteEvents:
+			if event.Event != 11 {
+				t.Fatalf("expected event 11, got %d", event.Event)
+			}
+			if event.End {
+				if event.Duration != 480 {
+					t.Errorf("expected end duration 480, got %d", event.Duration)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatalf("remote did not receive the DTMF end packet")
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/cmplx"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEchoTail 回声消除默认覆盖的回声路径长度，包含声卡缓冲和扬声器到麦克风的声学时延
+	DefaultEchoTail = 128 * time.Millisecond
+	// echoStep NLMS 的归一化步长，取值 (0, 2)，越大收敛越快但稳态误差越大
+	echoStep = 0.5
+	// echoGeigel Geigel 双讲检测阈值：近端幅度超过参考信号最大幅度的该比例时认为近端在说话
+	echoGeigel = 0.5
+	// echoHold 检测到双讲后暂停滤波器更新的时长
+	echoHold = 30 * time.Millisecond
+	// echoMaxFar 等待与麦克风对齐的参考信号的最大时长，超过后丢弃最旧的数据
+	echoMaxFar = 500 * time.Millisecond
+)
+
+// EchoStats 回声消除的统计信息
+type EchoStats struct {
+	ERLEdB     float64 `json:"erleDb"`     // 回声返回损耗增强，只统计远端说话、近端未说话的时段
+	DoubleTalk uint64  `json:"doubleTalk"` // 检测到双讲的采样数
+	FarDropped uint64  `json:"farDropped"` // 参考信号积压被丢弃的采样数
+}
+
+// echoCanceller 以播放到扬声器的音频为参考，用分块频域 NLMS 自适应滤波器估计并减去麦克风中的回声
+// 滤波器按块长分成若干段：第一段在时域逐采样滤波，不引入额外延迟；其余各段只用到一块之前的参考信号，
+// 在每块开始前用 FFT 一次算出回声估计。权重在频域按频点归一化更新，48kHz 下也能覆盖上百毫秒的回声路径
+// 参考信号在播放回调中写入，麦克风数据在采集回调中处理，两者都由声卡按实时节奏驱动
+type echoCanceller struct {
+	mu          sync.Mutex
+	block       int // 块长，FFT 长度为两倍块长
+	parts       int // 滤波器分段数
+	fft         *fftPlan
+	weights     [][]complex128 // 各段的频域权重
+	head        []float64      // 第一段的时域权重，倒序存放，每块更新后由 weights[0] 得到
+	spectra     [][]complex128 // 最近各块参考信号窗口（上一块和当前块）的频谱，最新的在前
+	window      []float64      // 上一块和当前块的参考信号
+	tail        []float64      // 当前块中第二段及以后各段的回声估计
+	errors      []float64      // 当前块用于更新滤波器的误差，暂停更新的采样为 0
+	adapt       bool           // 当前块是否有采样参与更新
+	peaks       []float64      // 之前各块参考信号的最大幅度，最新的在前
+	peak        float64        // 当前块参考信号的最大幅度
+	pastPeak    float64        // peaks 中的最大值
+	pos         int            // 当前块已处理的采样数
+	next        int            // 下一块做梯度约束的段，第一段每块都做
+	power       []float64      // 各频点的参考信号功率
+	spectrum    []complex128   // 计算用的临时缓冲区
+	far         []int16        // 已播放、还没有与麦克风数据对齐的参考信号
+	maxFar      int
+	hold        int // 剩余的暂停更新采样数
+	holdSamples int
+	echoEnergy  float64
+	outEnergy   float64
+	stats       EchoStats
+}
+
+// newEchoCanceller 创建回声消除器，tail 为滤波器覆盖的回声路径长度
+func newEchoCanceller(sampleRate int, tail time.Duration) *echoCanceller {
+	taps := int(tail.Seconds() * float64(sampleRate))
+	if taps < 1 {
+		taps = 1
+	}
+	// 块长约 5ms，取 2 的幂
+	block := 1
+	for block < sampleRate/200 {
+		block *= 2
+	}
+	parts := (taps + block - 1) / block
+	ec := &echoCanceller{
+		block:       block,
+		parts:       parts,
+		fft:         newFFTPlan(2 * block),
+		head:        make([]float64, block),
+		window:      make([]float64, 2*block),
+		tail:        make([]float64, block),
+		errors:      make([]float64, block),
+		peaks:       make([]float64, parts),
+		power:       make([]float64, 2*block),
+		spectrum:    make([]complex128, 2*block),
+		maxFar:      int(echoMaxFar.Seconds() * float64(sampleRate)),
+		holdSamples: int(echoHold.Seconds() * float64(sampleRate)),
+	}
+	for p := 0; p < parts; p++ {
+		ec.weights = append(ec.weights, make([]complex128, 2*block))
+		ec.spectra = append(ec.spectra, make([]complex128, 2*block))
+	}
+	return ec
+}
+
+// Far 写入播放到扬声器的 S16LE 音频作为参考信号
+func (ec *echoCanceller) Far(pcm []byte) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	for i := 0; i+1 < len(pcm); i += 2 {
+		ec.far = append(ec.far, int16(binary.LittleEndian.Uint16(pcm[i:])))
+	}
+	if over := len(ec.far) - ec.maxFar; over > 0 {
+		ec.far = ec.far[over:]
+		ec.stats.FarDropped += uint64(over)
+	}
+}
+
+// Process 对麦克风采集的 S16LE 音频做回声消除，返回新的缓冲区
+func (ec *echoCanceller) Process(near []byte) []byte {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	out := make([]byte, len(near))
+	for i := 0; i+1 < len(near); i += 2 {
+		// 每个麦克风采样对应一个参考采样，播放停止时参考为 0
+		x := 0.0
+		if len(ec.far) > 0 {
+			x = float64(ec.far[0])
+			ec.far = ec.far[1:]
+		}
+		d := float64(int16(binary.LittleEndian.Uint16(near[i:])))
+		e := ec.processSample(x, d)
+		binary.LittleEndian.PutUint16(out[i:], uint16(clampSample(e)))
+	}
+	return out
+}
+
+// processSample 处理一个采样，x 为参考信号，d 为麦克风信号，返回消除回声后的信号
+func (ec *echoCanceller) processSample(x, d float64) float64 {
+	ec.window[ec.block+ec.pos] = x
+	if v := math.Abs(x); v > ec.peak {
+		ec.peak = v
+	}
+
+	// 第一段在时域滤波，其余各段的回声估计在块开始前已经算好
+	y := ec.tail[ec.pos]
+	recent := ec.window[ec.pos+1 : ec.pos+1+ec.block]
+	for k, w := range ec.head {
+		y += w * recent[k]
+	}
+	e := d - y
+
+	// Geigel 双讲检测：近端在说话时暂停更新，避免滤波器发散
+	peak := math.Max(ec.peak, ec.pastPeak)
+	if math.Abs(d) > echoGeigel*peak {
+		if peak > 0 {
+			ec.stats.DoubleTalk++
+		}
+		ec.hold = ec.holdSamples
+	}
+	if ec.hold > 0 {
+		ec.hold--
+		ec.errors[ec.pos] = 0
+	} else {
+		ec.echoEnergy += d * d
+		ec.outEnergy += e * e
+		ec.errors[ec.pos] = e
+		ec.adapt = true
+	}
+
+	ec.pos++
+	if ec.pos == ec.block {
+		ec.endBlock()
+	}
+	return e
+}
+
+// endBlock 在一块结束时更新滤波器权重，并算出下一块中第二段及以后各段的回声估计
+func (ec *echoCanceller) endBlock() {
+	block, size := ec.block, 2*ec.block
+
+	// 当前窗口的频谱放到最前
+	latest := ec.spectra[ec.parts-1]
+	copy(ec.spectra[1:], ec.spectra[:ec.parts-1])
+	ec.spectra[0] = latest
+	for i, v := range ec.window {
+		latest[i] = complex(v, 0)
+	}
+	ec.fft.transform(latest, false)
+
+	if ec.adapt {
+		// 误差放在窗口后半部分，前半部分补零
+		errSpectrum := ec.spectrum
+		for i := 0; i < block; i++ {
+			errSpectrum[i] = 0
+			errSpectrum[block+i] = complex(ec.errors[i], 0)
+		}
+		ec.fft.transform(errSpectrum, false)
+
+		// 按频点归一化的步长，功率很小的频点加上平均功率的一小部分，避免噪声被放大
+		total := 0.0
+		for k := range ec.power {
+			power := 0.0
+			for _, X := range ec.spectra {
+				power += real(X[k])*real(X[k]) + imag(X[k])*imag(X[k])
+			}
+			ec.power[k] = power
+			total += power
+		}
+		floor := 0.01*total/float64(size) + float64(size)
+		for k := range ec.power {
+			g := echoStep / (ec.power[k] + floor)
+			E := errSpectrum[k]
+			for p, W := range ec.weights {
+				X := ec.spectra[p][k]
+				// W += g * conj(X) * E
+				W[k] += complex(g*(real(X)*real(E)+imag(X)*imag(E)), g*(real(X)*imag(E)-imag(X)*real(E)))
+			}
+		}
+
+		// 梯度约束：每段的时域权重只保留前半部分。第一段每块都做，其余各段轮流做
+		ec.constrain(0)
+		if ec.parts > 1 {
+			ec.next = ec.next%(ec.parts-1) + 1
+			ec.constrain(ec.next)
+		}
+		ec.adapt = false
+	}
+
+	// 下一块中第 p 段用到的是 p 块之前的窗口
+	acc := ec.spectrum
+	for k := range acc {
+		acc[k] = 0
+	}
+	for p := 1; p < ec.parts; p++ {
+		W, X := ec.weights[p], ec.spectra[p-1]
+		for k := range acc {
+			acc[k] += W[k] * X[k]
+		}
+	}
+	if ec.parts > 1 {
+		ec.fft.transform(acc, true)
+		for i := range ec.tail {
+			ec.tail[i] = real(acc[block+i])
+		}
+	}
+
+	copy(ec.window[:block], ec.window[block:])
+	copy(ec.peaks[1:], ec.peaks[:ec.parts-1])
+	ec.peaks[0] = ec.peak
+	ec.peak = 0
+	ec.pastPeak = 0
+	for _, v := range ec.peaks {
+		ec.pastPeak = math.Max(ec.pastPeak, v)
+	}
+	ec.pos = 0
+}
+
+// constrain 把第 p 段权重的时域后半部分清零，第一段同时更新时域权重
+func (ec *echoCanceller) constrain(p int) {
+	W := ec.weights[p]
+	ec.fft.transform(W, true)
+	for i := ec.block; i < len(W); i++ {
+		W[i] = 0
+	}
+	if p == 0 {
+		for i := 0; i < ec.block; i++ {
+			ec.head[ec.block-1-i] = real(W[i])
+		}
+	}
+	ec.fft.transform(W, false)
+}
+
+// Stats 返回统计信息
+func (ec *echoCanceller) Stats() EchoStats {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	stats := ec.stats
+	if ec.echoEnergy > 0 && ec.outEnergy > 0 {
+		stats.ERLEdB = 10 * math.Log10(ec.echoEnergy/ec.outEnergy)
+	}
+	return stats
+}
+
+// fftPlan 长度为 2 的幂的复数 FFT
+type fftPlan struct {
+	twiddles []complex128
+	reverse  []int
+}
+
+func newFFTPlan(n int) *fftPlan {
+	plan := &fftPlan{
+		twiddles: make([]complex128, n/2),
+		reverse:  make([]int, n),
+	}
+	for k := range plan.twiddles {
+		plan.twiddles[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n)))
+	}
+	bits := 0
+	for 1<<bits < n {
+		bits++
+	}
+	for i := range plan.reverse {
+		r := 0
+		for b := 0; b < bits; b++ {
+			r |= (i >> b & 1) << (bits - 1 - b)
+		}
+		plan.reverse[i] = r
+	}
+	return plan
+}
+
+// transform 原地变换，inverse 为 true 时做逆变换并除以长度
+func (plan *fftPlan) transform(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := range plan.reverse {
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size *= 2 {
+		half, step := size/2, n/size
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				w := plan.twiddles[k*step]
+				if inverse {
+					w = complex(real(w), -imag(w))
+				}
+				a, b := x[start+k], x[start+k+half]*w
+				x[start+k] = a + b
+				x[start+k+half] = a - b
+			}
+		}
+	}
+	if inverse {
+		scale := complex(1/float64(n), 0)
+		for i := range x {
+			x[i] *= scale
+		}
+	}
+}
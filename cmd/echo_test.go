@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// echoPath 模拟扬声器到麦克风的回声路径：延迟 delay 个采样，经过简单的衰减脉冲响应
+func echoPath(far []float64, delay int) []float64 {
+	echo := make([]float64, len(far))
+	response := []float64{0.3, -0.15, 0.05}
+	for i := range echo {
+		for k, h := range response {
+			if j := i - delay - k; j >= 0 {
+				echo[i] += h * far[j]
+			}
+		}
+	}
+	return echo
+}
+
+// toPCM 把浮点采样转换为 S16LE
+func toPCM(samples []float64) []byte {
+	pcm := make([]byte, len(samples)*2)
+	for i, v := range samples {
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(clampSample(v)))
+	}
+	return pcm
+}
+
+// energy 计算 S16LE 数据从第 from 个采样开始的能量
+func energy(pcm []byte, from int) float64 {
+	sum := 0.0
+	for i := from * 2; i+1 < len(pcm); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(pcm[i:])))
+		sum += v * v
+	}
+	return sum
+}
+
+// 测试远端单讲时滤波器收敛，回声被压低 20dB 以上
+func TestEchoCanceller_ConvergesOnFarEndSpeech(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	far := make([]float64, 16000)
+	for i := range far {
+		far[i] = rng.NormFloat64() * 3000
+	}
+	near := toPCM(echoPath(far, 40))
+	ec := newEchoCanceller(8000, 32*time.Millisecond)
+
+	out := make([]byte, 0, len(near))
+	for i := 0; i < len(far); i += 160 {
+		ec.Far(toPCM(far[i : i+160]))
+		out = append(out, ec.Process(near[i*2:(i+160)*2])...)
+	}
+	// 只比较后一半，跳过收敛过程
+	erle := 10 * math.Log10(energy(near, 8000)/energy(out, 8000))
+	if erle < 20 {
+		t.Fatalf("expected at least 20dB echo reduction after convergence, got %.1fdB", erle)
+	}
+	if stats := ec.Stats(); stats.ERLEdB <= 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// 测试双讲时保留近端语音，远端静音时麦克风数据原样通过
+func TestEchoCanceller_DoubleTalkKeepsNearEnd(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	far := make([]float64, 16000)
+	for i := range far {
+		far[i] = rng.NormFloat64() * 3000
+	}
+	echo := echoPath(far, 40)
+	// 后一半近端有人说话，声音比回声大
+	mixed := make([]float64, len(echo))
+	speech := make([]float64, len(echo))
+	for i := range mixed {
+		if i >= 8000 {
+			speech[i] = 8000 * math.Sin(2*math.Pi*300*float64(i)/8000)
+		}
+		mixed[i] = echo[i] + speech[i]
+	}
+	near := toPCM(mixed)
+	ec := newEchoCanceller(8000, 32*time.Millisecond)
+	out := make([]byte, 0, len(near))
+	for i := 0; i < len(far); i += 160 {
+		ec.Far(toPCM(far[i : i+160]))
+		out = append(out, ec.Process(near[i*2:(i+160)*2])...)
+	}
+	if ec.Stats().DoubleTalk == 0 {
+		t.Fatalf("double talk was not detected")
+	}
+	// 输出中的近端语音与原始语音的误差应远小于语音本身
+	residual := make([]float64, 0, 8000)
+	for i := 8000; i < len(speech); i++ {
+		residual = append(residual, float64(int16(binary.LittleEndian.Uint16(out[i*2:])))-speech[i])
+	}
+	ratio := 10 * math.Log10(energy(toPCM(speech[8000:]), 0)/energy(toPCM(residual), 0))
+	if ratio < 10 {
+		t.Fatalf("near-end speech distorted during double talk, SNR %.1fdB", ratio)
+	}
+
+	// 没有参考信号时不做任何处理
+	ec = newEchoCanceller(8000, 32*time.Millisecond)
+	tone := sinePCM(440, 8000, 160)
+	if got := ec.Process(tone); string(got) != string(tone) {
+		t.Errorf("microphone audio changed without a far-end reference")
+	}
+}
+
+// 48kHz、默认回声路径长度下处理一帧 20ms 音频的耗时，需要远小于 20ms 才能实时运行
+func BenchmarkEchoCanceller_48kHz(b *testing.B) {
+	rng := rand.New(rand.NewSource(3))
+	samples := make([]float64, 960)
+	for i := range samples {
+		samples[i] = rng.NormFloat64() * 3000
+	}
+	far := toPCM(samples)
+	near := toPCM(echoPath(samples, 40))
+	ec := newEchoCanceller(48000, DefaultEchoTail)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ec.Far(far)
+		ec.Process(near)
+	}
+}
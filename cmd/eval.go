@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// EvalCase is a scripted conversation loaded from a YAML file
+type EvalCase struct {
+	Name         string     `yaml:"name"`
+	SystemPrompt string     `yaml:"systemPrompt"`
+	Stages       string     `yaml:"stages"`     // 阶段配置文件，相对于用例文件
+	Form         string     `yaml:"form"`       // 表单 schema 文件，相对于用例文件
+	Guardrails   string     `yaml:"guardrails"` // guardrail 配置文件，相对于用例文件
+	Turns        []EvalTurn `yaml:"turns"`
+	path         string
+}
+
+// EvalTurn is a single user turn and the assertions on the agent's answer
+type EvalTurn struct {
+	User   string      `yaml:"user"`
+	Stub   []StubReply `yaml:"stub"` // stub 模型按顺序返回的回复，使用真实模型时忽略
+	Expect EvalExpect  `yaml:"expect"`
+}
+
+// StubReply is a scripted completion returned by the stub provider
+type StubReply struct {
+	Text      string         `yaml:"text"`
+	ToolCalls []StubToolCall `yaml:"toolCalls"`
+}
+
+// StubToolCall is a scripted function call returned by the stub provider
+type StubToolCall struct {
+	Name      string `yaml:"name"`
+	Arguments string `yaml:"arguments"`
+}
+
+// EvalExpect holds the assertions of a turn, empty fields are not checked
+type EvalExpect struct {
+	Contains    []string `yaml:"contains"`
+	NotContains []string `yaml:"notContains"`
+	Regex       string   `yaml:"regex"`
+	ToolCalled  []string `yaml:"toolCalled"`
+	Stage       string   `yaml:"stage"`
+	Hangup      *bool    `yaml:"hangup"`
+	Judge       string   `yaml:"judge"` // 交给裁判模型判断的标准
+}
+
+// AssertionResult is the outcome of a single assertion
+type AssertionResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// EvalTurnResult is the outcome of a turn
+type EvalTurnResult struct {
+	User       string            `json:"user"`
+	Response   string            `json:"response"`
+	ToolCalls  []string          `json:"toolCalls,omitempty"`
+	Stage      string            `json:"stage,omitempty"`
+	Hangup     bool              `json:"hangup"`
+	Error      string            `json:"error,omitempty"`
+	Assertions []AssertionResult `json:"assertions"`
+	Passed     bool              `json:"passed"`
+}
+
+// EvalCaseResult is the outcome of a case
+type EvalCaseResult struct {
+	Name     string           `json:"name"`
+	File     string           `json:"file"`
+	Turns    []EvalTurnResult `json:"turns"`
+	Passed   bool             `json:"passed"`
+	Duration string           `json:"duration"`
+}
+
+// EvalReport is the report of an evaluation run
+type EvalReport struct {
+	Provider string           `json:"provider"`
+	Model    string           `json:"model"`
+	Cases    []EvalCaseResult `json:"cases"`
+	Passed   int              `json:"passed"`
+	Failed   int              `json:"failed"`
+	Skipped  int              `json:"skipped"` // 跳过的断言数，例如没有裁判模型的密钥
+}
+
+// evalOptions configures an evaluation run
+type evalOptions struct {
+	Provider       string
+	OpenaiKey      string
+	OpenaiEndpoint string
+	Model          string
+	JudgeModel     string
+	Logger         *logrus.Logger
+}
+
+const judgePrompt = `You are evaluating the answer of a voice agent. You receive a criterion, the last message of the caller and the answer of the agent.
+Decide whether the answer satisfies the criterion and explain why in one sentence.`
+
+// runEval is the entry of the eval subcommand, it returns the process exit code
+func runEval(args []string) int {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	cases := flags.String("cases", "evals", "YAML test case file or directory")
+	provider := flags.String("provider", "stub", "LLM provider: stub, openai")
+	openaiKey := flags.String("openai-key", os.Getenv("OPENAI_API_KEY"), "OpenAI API key, used by the openai provider and the judge")
+	openaiEndpoint := flags.String("openai-endpoint", os.Getenv("OPENAI_ENDPOINT"), "OpenAI endpoint to use")
+	model := flags.String("openai-model", os.Getenv("OPENAI_MODEL"), "OpenAI model to use")
+	judgeModel := flags.String("judge-model", "", "Model of the LLM-as-judge, defaults to --openai-model")
+	report := flags.String("report", "", "Write the JSON report to this file")
+	verbose := flags.Bool("verbose", false, "Log the agent at info level")
+	flags.Parse(args)
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+	if *verbose {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+	if *openaiEndpoint == "" {
+		*openaiEndpoint = "https://api.openai.com/v1"
+	}
+	if *judgeModel == "" {
+		*judgeModel = *model
+	}
+
+	if *provider != "stub" && *provider != "openai" {
+		fmt.Fprintf(os.Stderr, "Unknown provider %q, expected stub or openai\n", *provider)
+		return 2
+	}
+	evalCases, err := LoadEvalCases(*cases)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	result := RunEval(context.Background(), evalCases, evalOptions{
+		Provider:       *provider,
+		OpenaiKey:      *openaiKey,
+		OpenaiEndpoint: *openaiEndpoint,
+		Model:          *model,
+		JudgeModel:     *judgeModel,
+		Logger:         logger,
+	})
+	printEvalReport(result)
+	if *report != "" {
+		if _, err := writeJSONFile(filepath.Dir(*report), filepath.Base(*report), result); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+		}
+	}
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// LoadEvalCases loads a single YAML file or all YAML files of a directory
+func LoadEvalCases(path string) ([]EvalCase, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(path, pattern))
+			files = append(files, matches...)
+		}
+	}
+	var cases []EvalCase
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var evalCase EvalCase
+		if err := yaml.Unmarshal(data, &evalCase); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		if evalCase.Name == "" {
+			evalCase.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		evalCase.path = file
+		cases = append(cases, evalCase)
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no test cases found in %s", path)
+	}
+	return cases, nil
+}
+
+// RunEval runs all cases and collects the report
+func RunEval(ctx context.Context, cases []EvalCase, options evalOptions) EvalReport {
+	report := EvalReport{Provider: options.Provider, Model: options.Model}
+	for _, evalCase := range cases {
+		result := runEvalCase(ctx, evalCase, options)
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		for _, turn := range result.Turns {
+			for _, assertion := range turn.Assertions {
+				if assertion.Skipped {
+					report.Skipped++
+				}
+			}
+		}
+		report.Cases = append(report.Cases, result)
+	}
+	return report
+}
+
+// resolvePath resolves a path of a case file relative to the case file
+func (c *EvalCase) resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(c.path), path)
+}
+
+// runEvalCase runs the turns of a case through a fresh LLMHandler
+func runEvalCase(ctx context.Context, evalCase EvalCase, options evalOptions) EvalCaseResult {
+	start := time.Now()
+	result := EvalCaseResult{Name: evalCase.Name, File: evalCase.path, Passed: true}
+	fail := func(err error) EvalCaseResult {
+		result.Passed = false
+		result.Turns = append(result.Turns, EvalTurnResult{Error: err.Error()})
+		result.Duration = time.Since(start).String()
+		return result
+	}
+
+	key, endpoint := options.OpenaiKey, options.OpenaiEndpoint
+	var stub *stubLLMServer
+	switch options.Provider {
+	case "stub":
+		stub = newStubLLMServer()
+		defer stub.Close()
+		key, endpoint = "stub", stub.URL()
+	case "openai":
+	default:
+		return fail(fmt.Errorf("unknown provider %q, expected stub or openai", options.Provider))
+	}
+
+	llm := NewLLMHandler(ctx, key, endpoint, evalCase.SystemPrompt, options.Logger)
+	if evalCase.Stages != "" {
+		stages, err := LoadStageConfig(evalCase.resolvePath(evalCase.Stages))
+		if err != nil {
+			return fail(err)
+		}
+		if err := llm.SetStages(stages); err != nil {
+			return fail(err)
+		}
+	}
+	if evalCase.Form != "" {
+		schema, err := LoadFormSchema(evalCase.resolvePath(evalCase.Form))
+		if err != nil {
+			return fail(err)
+		}
+		llm.SetForm(NewForm(schema))
+	}
+	var guardrail *Guardrail
+	if evalCase.Guardrails != "" {
+		config, err := LoadGuardrailConfig(evalCase.resolvePath(evalCase.Guardrails))
+		if err != nil {
+			return fail(err)
+		}
+		config.AuditFile = ""
+		if guardrail, err = NewGuardrail(*config, options.Logger); err != nil {
+			return fail(err)
+		}
+	}
+
+	var toolCalls []string
+	llm.OnToolCall = func(name, arguments string) {
+		toolCalls = append(toolCalls, name)
+	}
+
+	for _, turn := range evalCase.Turns {
+		if stub != nil {
+			stub.SetReplies(turn.Stub)
+		}
+		toolCalls = nil
+		var spoken strings.Builder
+		hangup := false
+		ttsCallback := func(segment string, playID string, autoHangup bool) error {
+			spoken.WriteString(segment)
+			hangup = hangup || autoHangup
+			return nil
+		}
+		if guardrail != nil {
+			ttsCallback = guardrail.Wrap(ttsCallback)
+		}
+
+		turnResult := EvalTurnResult{User: turn.User}
+		if _, err := llm.QueryStream(options.Model, turn.User, ttsCallback); err != nil {
+			turnResult.Error = err.Error()
+		}
+		turnResult.Response = strings.TrimSpace(spoken.String())
+		turnResult.ToolCalls = toolCalls
+		turnResult.Hangup = hangup
+		if stage := llm.CurrentStage(); stage != nil {
+			turnResult.Stage = stage.Name
+		}
+		turnResult.Assertions = checkTurn(llm, turn, turnResult, options)
+		turnResult.Passed = turnResult.Error == ""
+		for _, assertion := range turnResult.Assertions {
+			if !assertion.Passed && !assertion.Skipped {
+				turnResult.Passed = false
+			}
+		}
+		result.Passed = result.Passed && turnResult.Passed
+		result.Turns = append(result.Turns, turnResult)
+		if hangup {
+			break
+		}
+	}
+	result.Duration = time.Since(start).String()
+	return result
+}
+
+// checkTurn evaluates the assertions of a turn
+func checkTurn(llm *LLMHandler, turn EvalTurn, result EvalTurnResult, options evalOptions) []AssertionResult {
+	var assertions []AssertionResult
+	add := func(name string, passed bool, message string) {
+		assertions = append(assertions, AssertionResult{Name: name, Passed: passed, Message: message})
+	}
+	response := strings.ToLower(result.Response)
+	for _, text := range turn.Expect.Contains {
+		add("contains:"+text, strings.Contains(response, strings.ToLower(text)), "")
+	}
+	for _, text := range turn.Expect.NotContains {
+		add("notContains:"+text, !strings.Contains(response, strings.ToLower(text)), "")
+	}
+	if turn.Expect.Regex != "" {
+		regex, err := regexp.Compile(turn.Expect.Regex)
+		if err != nil {
+			add("regex", false, err.Error())
+		} else {
+			add("regex", regex.MatchString(result.Response), turn.Expect.Regex)
+		}
+	}
+	for _, tool := range turn.Expect.ToolCalled {
+		add("toolCalled:"+tool, containsString(result.ToolCalls, tool), strings.Join(result.ToolCalls, ","))
+	}
+	if turn.Expect.Stage != "" {
+		add("stage", result.Stage == turn.Expect.Stage, "current stage: "+result.Stage)
+	}
+	if turn.Expect.Hangup != nil {
+		add("hangup", result.Hangup == *turn.Expect.Hangup, fmt.Sprintf("hangup: %v", result.Hangup))
+	}
+	if turn.Expect.Judge != "" {
+		assertions = append(assertions, judgeTurn(llm, turn, result, options))
+	}
+	return assertions
+}
+
+// judgeVerdict is the structured answer of the judge model
+type judgeVerdict struct {
+	Pass   bool   `json:"pass"`
+	Reason string `json:"reason"`
+}
+
+// judgeTurn asks a real model whether the response satisfies the criterion
+func judgeTurn(llm *LLMHandler, turn EvalTurn, result EvalTurnResult, options evalOptions) AssertionResult {
+	assertion := AssertionResult{Name: "judge"}
+	if options.OpenaiKey == "" {
+		assertion.Skipped = true
+		assertion.Message = "no OpenAI key for the judge"
+		return assertion
+	}
+	judge := NewLLMHandler(llm.ctx, options.OpenaiKey, options.OpenaiEndpoint, judgePrompt, options.Logger)
+	judge.SetResponseFormat(&openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name: "verdict",
+			Schema: jsonSchema{
+				"type": "object",
+				"properties": map[string]any{
+					"pass":   map[string]any{"type": "boolean"},
+					"reason": map[string]any{"type": "string"},
+				},
+				"required":             []string{"pass", "reason"},
+				"additionalProperties": false,
+			},
+			Strict: true,
+		},
+	})
+	content, _, err := judge.Query(options.JudgeModel, fmt.Sprintf("Criterion: %s\nCaller: %s\nAgent: %s", turn.Expect.Judge, turn.User, result.Response))
+	if err != nil {
+		assertion.Message = err.Error()
+		return assertion
+	}
+	var verdict judgeVerdict
+	if err := json.Unmarshal([]byte(content), &verdict); err != nil {
+		assertion.Message = fmt.Sprintf("invalid verdict: %v", err)
+		return assertion
+	}
+	assertion.Passed = verdict.Pass
+	assertion.Message = verdict.Reason
+	return assertion
+}
+
+// printEvalReport prints a human readable pass/fail report
+func printEvalReport(report EvalReport) {
+	for _, result := range report.Cases {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		fmt.Printf("%s %s (%s)\n", status, result.Name, result.Duration)
+		for i, turn := range result.Turns {
+			if !turn.Passed {
+				fmt.Printf("  turn %d: %q\n", i+1, turn.User)
+				fmt.Printf("    response: %q\n", turn.Response)
+				if turn.Error != "" {
+					fmt.Printf("    error: %s\n", turn.Error)
+				}
+			}
+			// 跳过的断言不影响结果，但要让人看到哪些标准没有检查
+			for _, assertion := range turn.Assertions {
+				if assertion.Skipped {
+					fmt.Printf("  turn %d: skipped %s %s\n", i+1, assertion.Name, assertion.Message)
+				} else if !assertion.Passed {
+					fmt.Printf("    failed %s %s\n", assertion.Name, assertion.Message)
+				}
+			}
+		}
+	}
+	fmt.Printf("%d passed, %d failed, %d assertions skipped\n", report.Passed, report.Failed, report.Skipped)
+}
+
+// stubLLMServer is an OpenAI compatible server returning scripted replies
+type stubLLMServer struct {
+	server  *httptest.Server
+	mutex   sync.Mutex
+	replies []StubReply
+}
+
+func newStubLLMServer() *stubLLMServer {
+	stub := &stubLLMServer{}
+	stub.server = httptest.NewServer(http.HandlerFunc(stub.handle))
+	return stub
+}
+
+// URL returns the base URL to configure the OpenAI client with
+func (s *stubLLMServer) URL() string {
+	return s.server.URL + "/v1"
+}
+
+// Close stops the server
+func (s *stubLLMServer) Close() {
+	s.server.Close()
+}
+
+// SetReplies replaces the queue of replies, each completion request consumes one reply
+func (s *stubLLMServer) SetReplies(replies []StubReply) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.replies = append([]StubReply{}, replies...)
+}
+
+func (s *stubLLMServer) nextReply() StubReply {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.replies) == 0 {
+		return StubReply{Text: "OK."}
+	}
+	reply := s.replies[0]
+	s.replies = s.replies[1:]
+	return reply
+}
+
+func (s *stubLLMServer) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		http.NotFound(w, r)
+		return
+	}
+	var request openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reply := s.nextReply()
+	var toolCalls []openai.ToolCall
+	for i, call := range reply.ToolCalls {
+		toolCalls = append(toolCalls, openai.ToolCall{
+			ID:       fmt.Sprintf("call_%d", i),
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: call.Name, Arguments: call.Arguments},
+		})
+	}
+
+	if !request.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			ID:     "stub",
+			Object: "chat.completion",
+			Model:  request.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message: openai.ChatCompletionMessage{
+					Role:      openai.ChatMessageRoleAssistant,
+					Content:   reply.Text,
+					ToolCalls: toolCalls,
+				},
+				FinishReason: openai.FinishReasonStop,
+			}},
+		})
+		return
+	}
+
+	// 流式回复：按词切分文本，最后发送工具调用
+	w.Header().Set("Content-Type", "text/event-stream")
+	send := func(delta openai.ChatCompletionStreamChoiceDelta) {
+		data, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+			ID:      "stub",
+			Object:  "chat.completion.chunk",
+			Model:   request.Model,
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: delta}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	for _, word := range strings.SplitAfter(reply.Text, " ") {
+		if word != "" {
+			send(openai.ChatCompletionStreamChoiceDelta{Content: word})
+		}
+	}
+	for i := range toolCalls {
+		index := i
+		toolCalls[i].Index = &index
+		send(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{toolCalls[i]}})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
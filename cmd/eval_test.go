@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 测试离线评测：使用 stub 模型运行示例用例
+func TestRunEval_Stub(t *testing.T) {
+	cases, err := LoadEvalCases("evals")
+	if err != nil {
+		t.Fatalf("LoadEvalCases returned an error: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	report := RunEval(context.Background(), cases, evalOptions{Provider: "stub", Logger: logger})
+	if report.Failed != 0 {
+		printEvalReport(report)
+		t.Fatalf("%d cases failed", report.Failed)
+	}
+	if report.Passed != len(cases) {
+		t.Errorf("expected %d passed cases, got %d", len(cases), report.Passed)
+	}
+}
+
+// 测试断言失败时用例失败
+func TestRunEval_Failure(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	report := RunEval(context.Background(), []EvalCase{{
+		Name: "wrong answer",
+		Turns: []EvalTurn{{
+			User:   "hello",
+			Stub:   []StubReply{{Text: "Hi there."}},
+			Expect: EvalExpect{Contains: []string{"goodbye"}},
+		}},
+	}}, evalOptions{Provider: "stub", Logger: logger})
+	if report.Failed != 1 {
+		t.Errorf("expected the case to fail, got %+v", report)
+	}
+}
+
+// 测试未知的 provider 不会落到真实模型上
+func TestRunEval_UnknownProvider(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	report := RunEval(context.Background(), []EvalCase{{
+		Name:  "typo",
+		Turns: []EvalTurn{{User: "hello", Stub: []StubReply{{Text: "Hi there."}}}},
+	}}, evalOptions{Provider: "stbu", Logger: logger})
+	if report.Failed != 1 {
+		t.Errorf("expected the case to fail, got %+v", report)
+	}
+	if code := runEval([]string{"--provider", "stbu", "--cases", "evals"}); code != 2 {
+		t.Errorf("expected exit code 2, got %d", code)
+	}
+}
+
+// 测试没有裁判密钥时跳过的断言被计入报告
+func TestRunEval_SkippedJudge(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	report := RunEval(context.Background(), []EvalCase{{
+		Name: "judged",
+		Turns: []EvalTurn{{
+			User:   "hello",
+			Stub:   []StubReply{{Text: "Hi there."}},
+			Expect: EvalExpect{Judge: "The agent greets the caller."},
+		}},
+	}}, evalOptions{Provider: "stub", Logger: logger})
+	if report.Passed != 1 || report.Skipped != 1 {
+		t.Errorf("expected one passed case with one skipped assertion, got %+v", report)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// FormSchema is the JSON schema of the slots collected in form-filling mode
+type FormSchema struct {
+	Title       string                 `json:"title,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Properties  map[string]*SlotSchema `json:"properties"`
+	Required    []string               `json:"required,omitempty"`
+}
+
+// SlotSchema describes a single slot, only the subset of JSON schema we can validate is supported
+type SlotSchema struct {
+	Type        string   `json:"type,omitempty"` // string|integer|number
+	Description string   `json:"description,omitempty"`
+	Format      string   `json:"format,omitempty"` // date|email|phone
+	Pattern     string   `json:"pattern,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	MinLength   int      `json:"minLength,omitempty"`
+	MaxLength   int      `json:"maxLength,omitempty"`
+}
+
+// SlotTool represents the arguments of the fill_slot and confirm_slot function calls
+type SlotTool struct {
+	Slot      string `json:"slot"`
+	Value     string `json:"value,omitempty"`
+	Confirmed bool   `json:"confirmed,omitempty"`
+}
+
+// FormResult is the outcome of a form, emitted when the form completes and at hangup
+type FormResult struct {
+	Title     string            `json:"title,omitempty"`
+	Complete  bool              `json:"complete"`
+	Values    map[string]string `json:"values"`
+	Missing   []string          `json:"missing,omitempty"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// Form keeps the state of a form-filling dialogue
+type Form struct {
+	schema    *FormSchema
+	order     []string
+	values    map[string]string // 已经由来电者确认的值
+	pending   map[string]string // 已提取、等待确认的值
+	completed bool              // 已经调用过 OnComplete
+	updatedAt time.Time
+	mutex     sync.Mutex
+	// OnComplete is called once all required slots are confirmed
+	OnComplete func(result FormResult)
+}
+
+// slotValidator validates a raw value and returns its normalized form
+type slotValidator func(value string) (string, error)
+
+var slotFormats = map[string]slotValidator{
+	"date":  validateDate,
+	"email": validateEmail,
+	"phone": validatePhone,
+}
+
+// LoadFormSchema reads a form schema from a JSON file
+func LoadFormSchema(path string) (*FormSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read form schema: %w", err)
+	}
+	var schema FormSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse form schema: %w", err)
+	}
+	if len(schema.Properties) == 0 {
+		return nil, fmt.Errorf("form schema has no properties")
+	}
+	for _, name := range schema.Required {
+		if schema.Properties[name] == nil {
+			return nil, fmt.Errorf("required slot %s is not defined", name)
+		}
+	}
+	for name, slot := range schema.Properties {
+		if slot.Pattern != "" {
+			if _, err := regexp.Compile(slot.Pattern); err != nil {
+				return nil, fmt.Errorf("invalid pattern of slot %s: %w", name, err)
+			}
+		}
+		if slot.Format != "" && slotFormats[slot.Format] == nil {
+			return nil, fmt.Errorf("unsupported format of slot %s: %s", name, slot.Format)
+		}
+	}
+	return &schema, nil
+}
+
+// NewForm creates the state of a form, slots are asked in the order they are required
+func NewForm(schema *FormSchema) *Form {
+	order := append([]string{}, schema.Required...)
+	var optional []string
+	for name := range schema.Properties {
+		if !containsString(order, name) {
+			optional = append(optional, name)
+		}
+	}
+	sort.Strings(optional)
+	return &Form{
+		schema:    schema,
+		order:     append(order, optional...),
+		values:    map[string]string{},
+		pending:   map[string]string{},
+		updatedAt: time.Now(),
+	}
+}
+
+// HandleTool executes a fill_slot or confirm_slot call and returns the tool result for the LLM
+func (f *Form) HandleTool(name, arguments string) string {
+	var args SlotTool
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("error: invalid arguments: %v", err)
+	}
+	slot := f.schema.Properties[args.Slot]
+	if slot == nil {
+		return fmt.Sprintf("error: unknown slot %q, valid slots are %s", args.Slot, strings.Join(f.order, ", "))
+	}
+
+	f.mutex.Lock()
+	switch name {
+	case "fill_slot":
+		value, err := slot.Validate(args.Value)
+		if err != nil {
+			f.mutex.Unlock()
+			return fmt.Sprintf("error: %s is invalid: %v. Tell the caller and ask again.", args.Slot, err)
+		}
+		f.pending[args.Slot] = value
+		f.updatedAt = time.Now()
+		f.mutex.Unlock()
+		return fmt.Sprintf("%s recorded as %q. Read it back to the caller and ask them to confirm.", args.Slot, value)
+	case "confirm_slot":
+		value, ok := f.pending[args.Slot]
+		if !ok {
+			f.mutex.Unlock()
+			return fmt.Sprintf("error: no value for %s waiting for confirmation, call fill_slot first.", args.Slot)
+		}
+		delete(f.pending, args.Slot)
+		f.updatedAt = time.Now()
+		if !args.Confirmed {
+			f.mutex.Unlock()
+			return fmt.Sprintf("%s discarded. Ask the caller for it again.", args.Slot)
+		}
+		f.values[args.Slot] = value
+		result := f.result()
+		// 表单完成后来电者可能更正已确认的值，OnComplete 只在第一次完成时调用
+		notify := result.Complete && !f.completed
+		if result.Complete {
+			f.completed = true
+		}
+		f.mutex.Unlock()
+		if !result.Complete {
+			return fmt.Sprintf("%s confirmed. Next, ask for: %s.", args.Slot, strings.Join(result.Missing, ", "))
+		}
+		if notify && f.OnComplete != nil {
+			f.OnComplete(result)
+		}
+		return "All slots are confirmed. Thank the caller."
+	}
+	f.mutex.Unlock()
+	return fmt.Sprintf("error: unknown tool %s", name)
+}
+
+// Result returns a snapshot of the form
+func (f *Form) Result() FormResult {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.result()
+}
+
+func (f *Form) result() FormResult {
+	result := FormResult{
+		Title:     f.schema.Title,
+		Values:    make(map[string]string, len(f.values)),
+		UpdatedAt: f.updatedAt,
+	}
+	for name, value := range f.values {
+		result.Values[name] = value
+	}
+	for _, name := range f.schema.Required {
+		if _, ok := f.values[name]; !ok {
+			result.Missing = append(result.Missing, name)
+		}
+	}
+	result.Complete = len(result.Missing) == 0
+	return result
+}
+
+// Prompt describes the state of the form for the LLM, it is sent with every request
+func (f *Form) Prompt() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var sb strings.Builder
+	sb.WriteString("You are collecting a form")
+	if f.schema.Title != "" {
+		sb.WriteString(": " + f.schema.Title)
+	}
+	sb.WriteString(". Ask for one slot at a time. When the caller gives a value call fill_slot, ")
+	sb.WriteString("read the recorded value back and call confirm_slot with the caller's answer.\nSlots:\n")
+	for _, name := range f.order {
+		slot := f.schema.Properties[name]
+		state := "missing"
+		if value, ok := f.values[name]; ok {
+			state = fmt.Sprintf("confirmed %q", value)
+		} else if value, ok := f.pending[name]; ok {
+			state = fmt.Sprintf("waiting for confirmation of %q", value)
+		} else if !containsString(f.schema.Required, name) {
+			state = "optional"
+		}
+		sb.WriteString(fmt.Sprintf("- %s (%s): %s\n", name, slot.Description, state))
+	}
+	return sb.String()
+}
+
+// tools returns the function definitions of form-filling mode
+func (f *Form) tools() []openai.Tool {
+	slotProperty := map[string]any{
+		"type":        "string",
+		"enum":        f.order,
+		"description": "Name of the slot",
+	}
+	fill := &openai.FunctionDefinition{
+		Name:        "fill_slot",
+		Description: "Record the value the caller gave for a slot of the form",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"slot":  slotProperty,
+				"value": map[string]any{"type": "string", "description": "Value as given by the caller"},
+			},
+			"required": []string{"slot", "value"},
+		},
+	}
+	confirm := &openai.FunctionDefinition{
+		Name:        "confirm_slot",
+		Description: "Record whether the caller confirmed the value read back to them",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"slot":      slotProperty,
+				"confirmed": map[string]any{"type": "boolean", "description": "True if the caller confirmed the value"},
+			},
+			"required": []string{"slot", "confirmed"},
+		},
+	}
+	return []openai.Tool{
+		{Type: openai.ToolTypeFunction, Function: fill},
+		{Type: openai.ToolTypeFunction, Function: confirm},
+	}
+}
+
+// Validate checks a value against the slot schema and returns the normalized value
+func (s *SlotSchema) Validate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("value is empty")
+	}
+	switch s.Type {
+	case "integer":
+		digits := strings.ReplaceAll(value, " ", "")
+		if _, err := strconv.ParseInt(digits, 10, 64); err != nil {
+			return "", fmt.Errorf("not an integer")
+		}
+		value = digits
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("not a number")
+		}
+	}
+	if s.Format != "" {
+		validator := slotFormats[s.Format]
+		if validator == nil {
+			return "", fmt.Errorf("unsupported format %s", s.Format)
+		}
+		normalized, err := validator(value)
+		if err != nil {
+			return "", err
+		}
+		value = normalized
+	}
+	length := len([]rune(value))
+	if s.MinLength > 0 && length < s.MinLength {
+		return "", fmt.Errorf("shorter than %d characters", s.MinLength)
+	}
+	if s.MaxLength > 0 && length > s.MaxLength {
+		return "", fmt.Errorf("longer than %d characters", s.MaxLength)
+	}
+	if s.Pattern != "" {
+		matched, err := regexp.MatchString(s.Pattern, value)
+		if err != nil || !matched {
+			return "", fmt.Errorf("does not match the expected format")
+		}
+	}
+	if len(s.Enum) > 0 {
+		for _, option := range s.Enum {
+			if strings.EqualFold(option, value) {
+				return option, nil
+			}
+		}
+		return "", fmt.Errorf("must be one of %s", strings.Join(s.Enum, ", "))
+	}
+	return value, nil
+}
+
+// validateDate accepts common spoken/written date layouts and normalizes to YYYY-MM-DD.
+// Numeric day-first or month-first layouts such as 05/06/1990 are ambiguous and rejected
+func validateDate(value string) (string, error) {
+	layouts := []string{"2006-01-02", "2006/01/02", "2006.01.02", "2006年1月2日", "January 2, 2006", "2 January 2006", "Jan 2, 2006"}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			if t.After(time.Now()) {
+				return "", fmt.Errorf("date is in the future")
+			}
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("not a valid date")
+}
+
+func validateEmail(value string) (string, error) {
+	address, err := mail.ParseAddress(value)
+	if err != nil {
+		return "", fmt.Errorf("not a valid email address")
+	}
+	return strings.ToLower(address.Address), nil
+}
+
+// validatePhone keeps the digits (and a leading +) of a phone number
+func validatePhone(value string) (string, error) {
+	var sb strings.Builder
+	for i, r := range value {
+		if r >= '0' && r <= '9' || (r == '+' && i == 0) {
+			sb.WriteRune(r)
+		}
+	}
+	digits := strings.TrimPrefix(sb.String(), "+")
+	if len(digits) < 7 || len(digits) > 15 {
+		return "", fmt.Errorf("not a valid phone number")
+	}
+	return sb.String(), nil
+}
+
+// WriteFormResult writes the form to <dir>/<callID>.form.json, next to the call record
+func WriteFormResult(dir, callID string, result FormResult) (string, error) {
+	return writeJSONFile(dir, callID+".form.json", result)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// 测试槽位校验
+func TestSlotSchema_Validate(t *testing.T) {
+	date := SlotSchema{Format: "date"}
+	if value, err := date.Validate("1990/05/17"); err != nil || value != "1990-05-17" {
+		t.Errorf("unexpected date: %s %v", value, err)
+	}
+	if _, err := date.Validate("yesterday"); err == nil {
+		t.Errorf("invalid date accepted")
+	}
+	// 无法区分日/月和月/日的写法
+	if _, err := date.Validate("05/06/1990"); err == nil {
+		t.Errorf("ambiguous date accepted")
+	}
+
+	order := SlotSchema{Type: "integer", Pattern: "^[0-9]{8}$"}
+	if value, err := order.Validate("1234 5678"); err != nil || value != "12345678" {
+		t.Errorf("unexpected order number: %s %v", value, err)
+	}
+	if _, err := order.Validate("1234"); err == nil {
+		t.Errorf("short order number accepted")
+	}
+}
+
+// 测试表单填写流程：提取、确认、完成
+func TestForm_HandleTool(t *testing.T) {
+	form := NewForm(&FormSchema{
+		Properties: map[string]*SlotSchema{
+			"name":        {Type: "string", MinLength: 2},
+			"orderNumber": {Type: "string", Pattern: "^[0-9]{8}$"},
+		},
+		Required: []string{"name", "orderNumber"},
+	})
+	var completed *FormResult
+	calls := 0
+	form.OnComplete = func(result FormResult) {
+		completed = &result
+		calls++
+	}
+
+	form.HandleTool("fill_slot", `{"slot":"name","value":"Alice"}`)
+	form.HandleTool("confirm_slot", `{"slot":"name","confirmed":true}`)
+	form.HandleTool("fill_slot", `{"slot":"orderNumber","value":"1234"}`)
+	if result := form.Result(); result.Complete || result.Values["name"] != "Alice" {
+		t.Fatalf("unexpected form after first slot: %+v", result)
+	}
+
+	form.HandleTool("fill_slot", `{"slot":"orderNumber","value":"12345678"}`)
+	form.HandleTool("confirm_slot", `{"slot":"orderNumber","confirmed":false}`)
+	if result := form.Result(); result.Complete {
+		t.Fatalf("rejected value completed the form")
+	}
+
+	form.HandleTool("fill_slot", `{"slot":"orderNumber","value":"12345678"}`)
+	form.HandleTool("confirm_slot", `{"slot":"orderNumber","confirmed":true}`)
+	if completed == nil || !completed.Complete || completed.Values["orderNumber"] != "12345678" {
+		t.Errorf("form not completed: %+v", completed)
+	}
+
+	// 完成后更正已确认的值不再触发 OnComplete
+	form.HandleTool("fill_slot", `{"slot":"name","value":"Alicia"}`)
+	form.HandleTool("confirm_slot", `{"slot":"name","confirmed":true}`)
+	if calls != 1 {
+		t.Errorf("expected OnComplete to be called once, called %d times", calls)
+	}
+	if result := form.Result(); result.Values["name"] != "Alicia" {
+		t.Errorf("correction not recorded: %+v", result)
+	}
+}
+
+// 测试表单结果与通话记录写在一起，文件名以通话ID开头
+func TestWriteFormResult(t *testing.T) {
+	dir := t.TempDir()
+	path, err := WriteFormResult(dir, "call-1", FormResult{Complete: true, Values: map[string]string{"name": "Alice"}})
+	if err != nil {
+		t.Fatalf("WriteFormResult returned an error: %v", err)
+	}
+	if path != filepath.Join(dir, "call-1.form.json") {
+		t.Errorf("unexpected form path: %s", path)
+	}
+}
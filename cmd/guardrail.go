@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GuardrailConfig configures the checks applied to LLM output before it reaches TTS
+type GuardrailConfig struct {
+	Blocklist   []string      `json:"blocklist,omitempty"`   // 禁用词，命中后整段回复改为兜底话术
+	Policies    []RegexPolicy `json:"policies,omitempty"`    // 正则策略
+	MaxLength   int           `json:"maxLength,omitempty"`   // 单次回复最大字符数，超出部分截断
+	PII         bool          `json:"pii,omitempty"`         // 检查手机号、邮箱、证件号、银行卡号等隐私信息
+	PIIAction   string        `json:"piiAction,omitempty"`   // 隐私信息的处理方式 redact|block，默认 redact
+	Replacement string        `json:"replacement,omitempty"` // redact 时的替换文本
+	Fallback    string        `json:"fallback,omitempty"`    // block 时播报的兜底话术
+	AuditFile   string        `json:"auditFile,omitempty"`   // 审计日志文件，每次干预写入一行 JSON
+}
+
+// RegexPolicy blocks or redacts text matching a pattern
+type RegexPolicy struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action,omitempty"` // block|redact，默认 block
+}
+
+// GuardrailEvent records a single intervention for audit
+type GuardrailEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	PlayID    string    `json:"playId"`
+	Rule      string    `json:"rule"`
+	Action    string    `json:"action"`
+	Segment   string    `json:"segment"`
+	Output    string    `json:"output"`
+}
+
+type guardrailRule struct {
+	name   string
+	regex  *regexp.Regexp
+	action string
+	pii    bool // 审计日志中屏蔽命中的内容
+}
+
+// Guardrail sits between QueryStream and TTS and enforces the configured policies
+type Guardrail struct {
+	config  GuardrailConfig
+	rules   []guardrailRule
+	logger  *logrus.Logger
+	audit   *os.File
+	mutex   sync.Mutex
+	playID  string // 当前回复的 playID
+	length  int    // 当前回复已播报的字符数
+	blocked bool   // 当前回复是否已被拦截
+	// OnIntervention is called for every intervention
+	OnIntervention func(event GuardrailEvent)
+}
+
+const (
+	guardrailBlock    = "block"
+	guardrailRedact   = "redact"
+	guardrailTruncate = "truncate"
+)
+
+// piiPatterns detects personal data that must not be spoken out
+var piiPatterns = []struct {
+	name    string
+	pattern string
+}{
+	{"pii:email", `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`},
+	{"pii:idcard", `\b[1-9]\d{5}(19|20)\d{2}(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`},
+	{"pii:card", `\b(?:\d[ -]?){13,19}\b`},
+	{"pii:phone", `(?:\+?\d{1,3}[ -]?)?\b1[3-9]\d{9}\b|\b\d{3}[ -]\d{3,4}[ -]\d{4}\b`},
+}
+
+// LoadGuardrailConfig reads a guardrail config from a JSON file
+func LoadGuardrailConfig(path string) (*GuardrailConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guardrail config: %w", err)
+	}
+	var config GuardrailConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse guardrail config: %w", err)
+	}
+	return &config, nil
+}
+
+// NewGuardrail compiles the policies of a config
+func NewGuardrail(config GuardrailConfig, logger *logrus.Logger) (*Guardrail, error) {
+	g := &Guardrail{
+		config: config,
+		logger: logger,
+	}
+	for _, term := range config.Blocklist {
+		if term == "" {
+			continue
+		}
+		g.rules = append(g.rules, guardrailRule{
+			name:   "blocklist:" + term,
+			regex:  regexp.MustCompile(`(?i)` + regexp.QuoteMeta(term)),
+			action: guardrailBlock,
+		})
+	}
+	for _, policy := range config.Policies {
+		regex, err := regexp.Compile(policy.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of policy %s: %w", policy.Name, err)
+		}
+		action := policy.Action
+		if action == "" {
+			action = guardrailBlock
+		}
+		if action != guardrailBlock && action != guardrailRedact {
+			return nil, fmt.Errorf("invalid action of policy %s: %s", policy.Name, action)
+		}
+		g.rules = append(g.rules, guardrailRule{
+			name:   "policy:" + policy.Name,
+			regex:  regex,
+			action: action,
+		})
+	}
+	if config.PII {
+		action := config.PIIAction
+		if action == "" {
+			action = guardrailRedact
+		}
+		if action != guardrailBlock && action != guardrailRedact {
+			return nil, fmt.Errorf("invalid PII action: %s", action)
+		}
+		for _, pii := range piiPatterns {
+			g.rules = append(g.rules, guardrailRule{
+				name:   pii.name,
+				regex:  regexp.MustCompile(pii.pattern),
+				action: action,
+				pii:    true,
+			})
+		}
+	}
+	if config.AuditFile != "" {
+		audit, err := os.OpenFile(config.AuditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open guardrail audit file: %w", err)
+		}
+		g.audit = audit
+	}
+	return g, nil
+}
+
+// Close closes the audit file
+func (g *Guardrail) Close() error {
+	if g.audit == nil {
+		return nil
+	}
+	return g.audit.Close()
+}
+
+// Wrap returns a TTS callback that filters every segment before passing it to ttsCallback
+func (g *Guardrail) Wrap(ttsCallback func(segment string, playID string, autoHangup bool) error) func(segment string, playID string, autoHangup bool) error {
+	return func(segment string, playID string, autoHangup bool) error {
+		return ttsCallback(g.Check(segment, playID), playID, autoHangup)
+	}
+}
+
+// Check applies the guardrails to a segment of the response identified by playID
+// and returns the text that may be spoken, empty when the segment is suppressed
+func (g *Guardrail) Check(segment string, playID string) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	// 新的回复，重置状态
+	if playID != g.playID {
+		g.playID = playID
+		g.length = 0
+		g.blocked = false
+	}
+	if g.blocked || segment == "" {
+		return ""
+	}
+
+	output := segment
+	// 审计日志记录屏蔽隐私信息后的片段，避免原文写入日志文件
+	audited := g.maskPII(segment)
+	for _, rule := range g.rules {
+		if !rule.regex.MatchString(output) {
+			continue
+		}
+		if rule.action == guardrailBlock {
+			g.blocked = true
+			g.intervene(rule.name, guardrailBlock, audited, g.config.Fallback)
+			return g.config.Fallback
+		}
+		output = rule.regex.ReplaceAllString(output, g.config.Replacement)
+		g.intervene(rule.name, guardrailRedact, audited, output)
+	}
+
+	if g.config.MaxLength > 0 {
+		runes := []rune(output)
+		if g.length+len(runes) > g.config.MaxLength {
+			remain := g.config.MaxLength - g.length
+			if remain < 0 {
+				remain = 0
+			}
+			truncated := string(runes[:remain])
+			g.blocked = true
+			g.intervene("maxLength", guardrailTruncate, audited, truncated)
+			output = truncated
+		}
+		g.length += len([]rune(output))
+	}
+	return output
+}
+
+// maskPII replaces every character matched by a PII rule with '*'
+func (g *Guardrail) maskPII(text string) string {
+	for _, rule := range g.rules {
+		if rule.pii {
+			text = rule.regex.ReplaceAllStringFunc(text, func(match string) string {
+				return strings.Repeat("*", len([]rune(match)))
+			})
+		}
+	}
+	return text
+}
+
+// intervene logs an intervention and writes it to the audit file
+func (g *Guardrail) intervene(rule, action, segment, output string) {
+	event := GuardrailEvent{
+		Timestamp: time.Now(),
+		PlayID:    g.playID,
+		Rule:      rule,
+		Action:    action,
+		Segment:   segment,
+		Output:    output,
+	}
+	g.logger.WithFields(logrus.Fields{
+		"playID": event.PlayID,
+		"rule":   event.Rule,
+		"action": event.Action,
+	}).Warn("guardrail: Intervention")
+	if g.audit != nil {
+		data, _ := json.Marshal(event)
+		if _, err := g.audit.Write(append(data, '\n')); err != nil {
+			g.logger.WithError(err).Error("guardrail: Failed to write audit event")
+		}
+	}
+	if g.OnIntervention != nil {
+		g.OnIntervention(event)
+	}
+}
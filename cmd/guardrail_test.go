@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 测试禁用词拦截：命中后播报兜底话术，后续片段被丢弃
+func TestGuardrail_Block(t *testing.T) {
+	guardrail, err := NewGuardrail(GuardrailConfig{
+		Blocklist: []string{"guaranteed return"},
+		Fallback:  "Sorry, I can't help with that.",
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewGuardrail returned an error: %v", err)
+	}
+	var events []GuardrailEvent
+	guardrail.OnIntervention = func(event GuardrailEvent) {
+		events = append(events, event)
+	}
+
+	if output := guardrail.Check("Hello,", "play-1"); output != "Hello," {
+		t.Errorf("clean segment changed: %q", output)
+	}
+	if output := guardrail.Check("this has a Guaranteed Return.", "play-1"); output != "Sorry, I can't help with that." {
+		t.Errorf("blocked segment not replaced: %q", output)
+	}
+	if output := guardrail.Check("More text.", "play-1"); output != "" {
+		t.Errorf("segment after block not suppressed: %q", output)
+	}
+	if output := guardrail.Check("Next answer.", "play-2"); output != "Next answer." {
+		t.Errorf("next response still blocked: %q", output)
+	}
+	if len(events) != 1 || events[0].Rule != "blocklist:guaranteed return" {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
+
+// 测试隐私信息脱敏和长度截断
+func TestGuardrail_RedactAndTruncate(t *testing.T) {
+	guardrail, err := NewGuardrail(GuardrailConfig{
+		PII:         true,
+		Replacement: "[redacted]",
+		MaxLength:   40,
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewGuardrail returned an error: %v", err)
+	}
+
+	output := guardrail.Check("Mail alice@example.com,", "play-1")
+	if output != "Mail [redacted]," {
+		t.Errorf("email not redacted: %q", output)
+	}
+	output = guardrail.Check("and this sentence is far too long to be spoken.", "play-1")
+	if len([]rune(output)) != 40-len([]rune("Mail [redacted],")) {
+		t.Errorf("segment not truncated: %q", output)
+	}
+	if output := guardrail.Check("Dropped.", "play-1"); output != "" {
+		t.Errorf("segment after truncation not suppressed: %q", output)
+	}
+}
+
+// 测试隐私信息的处理方式校验，审计日志中不出现隐私信息原文
+func TestGuardrail_PIIAudit(t *testing.T) {
+	if _, err := NewGuardrail(GuardrailConfig{PII: true, PIIAction: "blok"}, logrus.New()); err == nil {
+		t.Error("expected an error for an invalid PII action")
+	}
+
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	guardrail, err := NewGuardrail(GuardrailConfig{
+		PII:       true,
+		PIIAction: "block",
+		Fallback:  "Sorry.",
+		AuditFile: audit,
+		Blocklist: []string{"secret"},
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewGuardrail returned an error: %v", err)
+	}
+	guardrail.Check("The secret is alice@example.com.", "play-1")
+	guardrail.Check("Call 13812345678.", "play-2")
+	guardrail.Close()
+
+	data, err := os.ReadFile(audit)
+	if err != nil {
+		t.Fatalf("failed to read audit file: %v", err)
+	}
+	if strings.Contains(string(data), "alice@example.com") || strings.Contains(string(data), "13812345678") {
+		t.Errorf("audit file contains PII: %s", data)
+	}
+	if !strings.Contains(string(data), `"The secret is *****************."`) || !strings.Contains(string(data), `"rule":"pii:phone"`) {
+		t.Errorf("unexpected audit file: %s", data)
+	}
+}
+
+// 测试流式回复按标点切分时，邮箱不会被拆到两个片段中而漏过脱敏
+func TestGuardrail_StreamSegments(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	stub := newStubLLMServer()
+	defer stub.Close()
+	stub.SetReplies([]StubReply{{Text: "Sure, write to alice@example.com or call 3.5 hours later. Bye."}})
+
+	guardrail, err := NewGuardrail(GuardrailConfig{PII: true, Replacement: "[redacted]"}, logger)
+	if err != nil {
+		t.Fatalf("NewGuardrail returned an error: %v", err)
+	}
+	var segments []string
+	ttsCallback := guardrail.Wrap(func(segment string, playID string, autoHangup bool) error {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+		return nil
+	})
+
+	llm := NewLLMHandler(context.Background(), "test", stub.URL(), "", logger)
+	if _, err := llm.QueryStream("", "How can I reach you?", ttsCallback); err != nil {
+		t.Fatalf("QueryStream returned an error: %v", err)
+	}
+	expected := []string{"Sure, ", "write to [redacted] or call 3.5 hours later. ", "Bye."}
+	if strings.Join(segments, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected segments %q, got %q", expected, segments)
+	}
+}
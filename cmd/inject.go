@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InjectMode 注入音频与麦克风音频的组合方式
+type InjectMode int
+
+const (
+	InjectMix     InjectMode = iota // 与麦克风音频混合
+	InjectReplace                   // 播放期间替换麦克风音频
+)
+
+// ParseInjectMode 解析 --inject-mode 参数：mix 或 replace
+func ParseInjectMode(mode string) (InjectMode, error) {
+	switch strings.ToLower(mode) {
+	case "", "mix":
+		return InjectMix, nil
+	case "replace":
+		return InjectReplace, nil
+	}
+	return InjectMix, fmt.Errorf("invalid inject mode: %s, expected mix or replace", mode)
+}
+
+// Injection 一段注入到出站音频的本地音频，可以暂停、恢复和停止
+type Injection struct {
+	mu         sync.Mutex
+	pcm        []byte
+	offset     int
+	sampleRate int
+	mode       InjectMode
+	paused     bool
+	finished   bool
+	done       chan struct{}
+}
+
+// Pause 暂停播放，暂停期间麦克风音频照常发送
+func (in *Injection) Pause() {
+	in.mu.Lock()
+	in.paused = true
+	in.mu.Unlock()
+}
+
+// Resume 从暂停的位置继续播放
+func (in *Injection) Resume() {
+	in.mu.Lock()
+	in.paused = false
+	in.mu.Unlock()
+}
+
+// Stop 停止播放
+func (in *Injection) Stop() {
+	in.mu.Lock()
+	in.finish()
+	in.mu.Unlock()
+}
+
+// Done 播放完成或停止时关闭
+func (in *Injection) Done() <-chan struct{} {
+	return in.done
+}
+
+// Position 返回已播放的时长
+func (in *Injection) Position() time.Duration {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return time.Duration(in.offset/2) * time.Second / time.Duration(in.sampleRate)
+}
+
+// finish 标记播放结束，调用时需持有 mu
+func (in *Injection) finish() {
+	if !in.finished {
+		in.finished = true
+		close(in.done)
+	}
+}
+
+// next 取出一帧注入音频，暂停或结束时返回 nil
+func (in *Injection) next(size int) []byte {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.paused || in.finished {
+		return nil
+	}
+	frame := make([]byte, size)
+	in.offset += copy(frame, in.pcm[in.offset:])
+	if in.offset >= len(in.pcm) {
+		in.finish()
+	}
+	return frame
+}
+
+// isFinished 是否已播放完成或停止
+func (in *Injection) isFinished() bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.finished
+}
+
+// active 是否还需要参与组合，暂停中的注入不替换麦克风音频
+func (in *Injection) active() (bool, InjectMode) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return !in.finished && !in.paused, in.mode
+}
+
+// readInjectFile 读取注入的音频文件：.wav 按文件头的采样率读取，其他扩展名作为 rawRate 采样率的 S16LE 单声道裸数据
+func readInjectFile(path string, rawRate int) ([]byte, int, error) {
+	if strings.EqualFold(filepath.Ext(path), ".wav") {
+		return readWAV(path)
+	}
+	pcm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if rawRate <= 0 {
+		return nil, 0, fmt.Errorf("sample rate of raw PCM file %s is not set", path)
+	}
+	return pcm[:len(pcm)/2*2], rawRate, nil
+}
+
+// PlayFile 把本地 WAV 或裸 PCM 文件注入到出站音频，rawRate 为裸 PCM 的采样率
+func (mh *MediaHandler) PlayFile(path string, rawRate int, mode InjectMode) (*Injection, error) {
+	pcm, sampleRate, err := readInjectFile(path, rawRate)
+	if err != nil {
+		return nil, err
+	}
+	return mh.PlayPCM(pcm, sampleRate, mode)
+}
+
+// PlayPCM 把 S16LE 单声道音频注入到出站音频，多段注入同时播放时混合在一起
+// 需要在协商确定编解码器之后调用，连接建立前注入的音频在开始发送时播放
+func (mh *MediaHandler) PlayPCM(pcm []byte, sampleRate int, mode InjectMode) (*Injection, error) {
+	if mh.codec == nil {
+		return nil, fmt.Errorf("codec is not negotiated")
+	}
+	if sampleRate != mh.codec.SampleRate {
+		pcm = resamplePCM(pcm, sampleRate, mh.codec.SampleRate)
+	}
+	injection := &Injection{pcm: pcm, sampleRate: mh.codec.SampleRate, mode: mode, done: make(chan struct{})}
+	if len(pcm) == 0 {
+		injection.finish()
+		return injection, nil
+	}
+	mh.injectMutex.Lock()
+	mh.injections = append(mh.injections, injection)
+	mh.injectMutex.Unlock()
+	return injection, nil
+}
+
+// StopInjections 停止所有注入的音频
+func (mh *MediaHandler) StopInjections() {
+	mh.injectMutex.Lock()
+	injections := mh.injections
+	mh.injections = nil
+	mh.injectMutex.Unlock()
+	for _, injection := range injections {
+		injection.Stop()
+	}
+}
+
+// mixInjections 把注入的音频与一帧麦克风音频组合，有替换模式的注入在播放时丢弃麦克风音频
+func (mh *MediaHandler) mixInjections(frame []byte) []byte {
+	mh.injectMutex.Lock()
+	injections := mh.injections[:0:0]
+	for _, injection := range mh.injections {
+		if !injection.isFinished() {
+			injections = append(injections, injection)
+		}
+	}
+	mh.injections = injections
+	mh.injectMutex.Unlock()
+	if len(injections) == 0 {
+		return frame
+	}
+
+	mixed := make([]int32, len(frame)/2)
+	replace := false
+	for _, injection := range injections {
+		if active, mode := injection.active(); active && mode == InjectReplace {
+			replace = true
+		}
+	}
+	if !replace {
+		for i := range mixed {
+			mixed[i] = int32(int16(binary.LittleEndian.Uint16(frame[i*2:])))
+		}
+	}
+	for _, injection := range injections {
+		data := injection.next(len(frame))
+		for i := 0; i < len(mixed) && i*2+1 < len(data); i++ {
+			mixed[i] += int32(int16(binary.LittleEndian.Uint16(data[i*2:])))
+		}
+	}
+	out := make([]byte, len(frame))
+	for i, v := range mixed {
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(clampSample(float64(v))))
+	}
+	return out
+}
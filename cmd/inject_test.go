@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// firstSample 取出第一个采样
+func firstSample(pcm []byte) int16 {
+	return int16(binary.LittleEndian.Uint16(pcm))
+}
+
+// 测试注入音频与麦克风混合或替换，暂停期间发送麦克风音频，播放完成后关闭 Done
+func TestMediaHandler_MixInjections(t *testing.T) {
+	mh := &MediaHandler{codec: audioCodecs["pcmu"]}
+	mic := constantPCM(100, 160)
+
+	mixed, err := mh.PlayPCM(constantPCM(1000, 320), 8000, InjectMix)
+	if err != nil {
+		t.Fatalf("PlayPCM returned an error: %v", err)
+	}
+	if got := firstSample(mh.mixInjections(mic)); got != 1100 {
+		t.Errorf("expected mixed sample 1100, got %d", got)
+	}
+	mixed.Pause()
+	if got := firstSample(mh.mixInjections(mic)); got != 100 {
+		t.Errorf("expected microphone audio while paused, got %d", got)
+	}
+	mixed.Resume()
+	mh.mixInjections(mic)
+	select {
+	case <-mixed.Done():
+	default:
+		t.Fatalf("injection not done after playing all frames")
+	}
+	if mixed.Position() != 40*time.Millisecond {
+		t.Errorf("unexpected position %v", mixed.Position())
+	}
+
+	replaced, _ := mh.PlayPCM(constantPCM(1000, 1600), 8000, InjectReplace)
+	if got := firstSample(mh.mixInjections(mic)); got != 1000 {
+		t.Errorf("expected the microphone to be replaced, got %d", got)
+	}
+	replaced.Stop()
+	if got := firstSample(mh.mixInjections(mic)); got != 100 {
+		t.Errorf("expected microphone audio after stop, got %d", got)
+	}
+}
+
+// 测试裸 PCM 文件按指定采样率读取并重采样到编解码器的采样率
+func TestMediaHandler_PlayRawFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.pcm")
+	if err := os.WriteFile(path, constantPCM(500, 1600), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mh := &MediaHandler{codec: audioCodecs["g722"]}
+	if _, err := mh.PlayFile(path, 0, InjectMix); err == nil {
+		t.Errorf("expected an error without the raw sample rate")
+	}
+	injection, err := mh.PlayFile(path, 8000, InjectMix)
+	if err != nil {
+		t.Fatalf("PlayFile returned an error: %v", err)
+	}
+	if len(injection.pcm) < 6000 || len(injection.pcm) > 6800 {
+		t.Errorf("expected about 200ms at 16kHz, got %d bytes", len(injection.pcm))
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// jitterMinDepth 开始播放前至少缓冲的包数
+	jitterMinDepth = 2
+	// jitterMaxDepth 最多缓冲的包数，超过后丢弃最旧的包，避免延迟无限增长
+	jitterMaxDepth = 25
+	// plcMaxFrames 连续丢包时做补偿的最大帧数，之后输出静音
+	plcMaxFrames = 3
+)
+
+// JitterStats 抖动缓冲的统计信息
+type JitterStats struct {
+	Received    uint64  `json:"received"`    // 收到的包数
+	Lost        uint64  `json:"lost"`        // 播放时缺失并做了补偿的包数
+	Late        uint64  `json:"late"`        // 到达时已错过播放时间被丢弃的包数
+	Duplicate   uint64  `json:"duplicate"`   // 重复的包数
+	Reordered   uint64  `json:"reordered"`   // 乱序到达的包数
+	Overflow    uint64  `json:"overflow"`    // 缓冲溢出丢弃的包数
+	Underruns   uint64  `json:"underruns"`   // 缓冲耗尽后重新缓冲的次数
+	Depth       int     `json:"depth"`       // 当前缓冲的包数
+	TargetDepth int     `json:"targetDepth"` // 当前目标缓冲深度
+	JitterMs    float64 `json:"jitterMs"`    // RFC 3550 到达间隔抖动，单位毫秒
+}
+
+// jitterResult Pop 的结果类型
+type jitterResult int
+
+const (
+	jitterWait   jitterResult = iota // 正在缓冲，本周期不输出
+	jitterPacket                     // 输出一个包
+	jitterLost                       // 该包丢失，需要做丢包补偿
+)
+
+// jitterBuffer 按 RTP 序列号重排入站数据包，由播放协程按固定节奏取出
+// 目标深度根据到达抖动自适应调整
+type jitterBuffer struct {
+	mu         sync.Mutex
+	clockRate  int
+	packets    map[uint16]*rtp.Packet
+	nextSeq    uint16 // 下一个要播放的序列号
+	highestSeq uint16 // 已收到的最大序列号
+	started    bool   // 已收到第一个包
+	playing    bool   // 已缓冲到目标深度
+	frameTicks uint32 // 每个包的时间戳跨度
+	jitter     float64
+	lastTicks  int64 // 上一个按序到达的包的到达时间，单位为时间戳
+	lastTS     uint32
+	base       time.Time
+	stats      JitterStats
+}
+
+// newJitterBuffer 创建抖动缓冲，clockRate 为 RTP 时钟频率
+func newJitterBuffer(clockRate int) *jitterBuffer {
+	return &jitterBuffer{
+		clockRate:  clockRate,
+		packets:    make(map[uint16]*rtp.Packet),
+		frameTicks: uint32(clockRate) * uint32(audioFrameDuration/time.Millisecond) / 1000,
+	}
+}
+
+// seqDiff 计算考虑回绕的序列号差值 a-b
+func seqDiff(a, b uint16) int {
+	return int(int16(a - b))
+}
+
+// Push 放入收到的包，arrival 为到达时间
+func (jb *jitterBuffer) Push(packet *rtp.Packet, arrival time.Time) {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+
+	seq := packet.SequenceNumber
+	jb.stats.Received++
+	if !jb.started {
+		jb.started = true
+		jb.nextSeq = seq
+		jb.highestSeq = seq
+		jb.base = arrival
+		jb.lastTS = packet.Timestamp
+		jb.packets[seq] = packet
+		return
+	}
+
+	if seqDiff(seq, jb.nextSeq) < 0 {
+		if jb.playing {
+			jb.stats.Late++
+			return
+		}
+		// 还没开始播放，乱序到达的更早的包仍然可以播放
+		jb.nextSeq = seq
+	}
+	if _, ok := jb.packets[seq]; ok {
+		jb.stats.Duplicate++
+		return
+	}
+	if seqDiff(seq, jb.highestSeq) < 0 {
+		jb.stats.Reordered++
+	} else {
+		jb.updateJitter(packet, arrival, seqDiff(seq, jb.highestSeq))
+		jb.highestSeq = seq
+	}
+	jb.packets[seq] = packet
+
+	// 缓冲溢出时从最旧的包开始丢弃
+	for len(jb.packets) > jitterMaxDepth {
+		if _, ok := jb.packets[jb.nextSeq]; ok {
+			delete(jb.packets, jb.nextSeq)
+			jb.stats.Overflow++
+		}
+		jb.nextSeq++
+	}
+}
+
+// updateJitter 按 RFC 3550 6.4.1 更新到达间隔抖动，并记录包的时间戳跨度
+func (jb *jitterBuffer) updateJitter(packet *rtp.Packet, arrival time.Time, seqGap int) {
+	// 同一个 DTMF 事件的多个包时间戳相同，不参与抖动计算
+	if packet.Timestamp == jb.lastTS {
+		return
+	}
+	ticks := int64(arrival.Sub(jb.base).Seconds() * float64(jb.clockRate))
+	tsDelta := int64(int32(packet.Timestamp - jb.lastTS))
+	if seqGap == 1 && tsDelta > 0 {
+		jb.frameTicks = uint32(tsDelta)
+	}
+	d := float64((ticks - jb.lastTicks) - tsDelta)
+	jb.jitter += (math.Abs(d) - jb.jitter) / 16
+	jb.lastTicks = ticks
+	jb.lastTS = packet.Timestamp
+}
+
+// targetDepth 根据抖动计算目标缓冲深度，约为三倍抖动
+func (jb *jitterBuffer) targetDepth() int {
+	depth := jitterMinDepth
+	if jb.frameTicks > 0 {
+		depth += int(math.Ceil(3 * jb.jitter / float64(jb.frameTicks)))
+	}
+	if depth > jitterMaxDepth {
+		depth = jitterMaxDepth
+	}
+	return depth
+}
+
+// Pop 每个播放周期调用一次，取出下一个包
+func (jb *jitterBuffer) Pop() (*rtp.Packet, jitterResult) {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+
+	if !jb.playing {
+		if !jb.started || len(jb.packets) < jb.targetDepth() {
+			return nil, jitterWait
+		}
+		jb.playing = true
+	}
+	if packet, ok := jb.packets[jb.nextSeq]; ok {
+		delete(jb.packets, jb.nextSeq)
+		jb.nextSeq++
+		return packet, jitterPacket
+	}
+	if len(jb.packets) == 0 {
+		// 缓冲耗尽，重新缓冲到目标深度，缺失的包等后续包到达后再判断是否丢失
+		jb.playing = false
+		jb.stats.Underruns++
+		return nil, jitterWait
+	}
+	jb.nextSeq++
+	jb.stats.Lost++
+	return nil, jitterLost
+}
+
+// Peek 返回下一个要播放的包但不取出，没有时返回 nil，丢包后用于带内 FEC 恢复
+func (jb *jitterBuffer) Peek() *rtp.Packet {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	return jb.packets[jb.nextSeq]
+}
+
+// Stats 返回统计信息
+func (jb *jitterBuffer) Stats() JitterStats {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	stats := jb.stats
+	stats.Depth = len(jb.packets)
+	stats.TargetDepth = jb.targetDepth()
+	stats.JitterMs = jb.jitter * 1000 / float64(jb.clockRate)
+	return stats
+}
+
+// lossConcealer 对解码后的 PCM 做丢包补偿：重复上一帧并逐帧衰减，连续丢包过多后输出静音
+// 适用于 G.711/G.722 这类没有内置丢包补偿的编解码器
+type lossConcealer struct {
+	last []byte
+	lost int
+}
+
+// good 记录正常解码的一帧
+func (c *lossConcealer) good(pcm []byte) {
+	c.last = pcm
+	c.lost = 0
+}
+
+// conceal 生成一帧补偿数据，还没有收到过正常帧时返回 nil
+func (c *lossConcealer) conceal() []byte {
+	if c.last == nil {
+		return nil
+	}
+	c.lost++
+	frame := make([]byte, len(c.last))
+	if c.lost > plcMaxFrames {
+		return frame
+	}
+	gain := 1 - float64(c.lost)/float64(plcMaxFrames+1)
+	for i := 0; i+1 < len(frame); i += 2 {
+		sample := int16(binary.LittleEndian.Uint16(c.last[i:]))
+		binary.LittleEndian.PutUint16(frame[i:], uint16(int16(float64(sample)*gain)))
+	}
+	return frame
+}
+
+// concealLoss 生成丢失帧的音频：支持带内 FEC 的解码器从下一个包中恢复，否则由 concealer 做丢包补偿
+func concealLoss(jitter *jitterBuffer, decoder audioDecoder, detector *dtmfDetector, concealer *lossConcealer) []byte {
+	if fec, ok := decoder.(fecDecoder); ok {
+		if next := jitter.Peek(); next != nil && !detector.isEvent(next) {
+			audioData, err := fec.DecodeFEC(next.Payload)
+			if err == nil && len(audioData) > 0 {
+				concealer.good(audioData)
+				return audioData
+			}
+		}
+	}
+	return concealer.conceal()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// jitterPacketAt 生成 8kHz、20ms 一包的测试包，时间戳从序列号 65534 开始计算
+func jitterPacketAt(seq uint16) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{SequenceNumber: seq, Timestamp: uint32(seq-65534) * 160},
+		Payload: []byte{byte(seq)},
+	}
+}
+
+// 测试乱序到达的包按序列号输出，缺失的包报告丢失，过期的包被丢弃
+func TestJitterBuffer_ReorderAndLoss(t *testing.T) {
+	jb := newJitterBuffer(8000)
+	start := time.Now()
+	// 序列号 3 丢失，5 先于 4 到达
+	for i, seq := range []uint16{65534, 65535, 0, 1, 2, 5, 4, 6} {
+		jb.Push(jitterPacketAt(seq), start.Add(time.Duration(i)*audioFrameDuration))
+	}
+
+	var got []int
+	for i := 0; i < 8; i++ {
+		packet, result := jb.Pop()
+		switch result {
+		case jitterPacket:
+			got = append(got, int(packet.SequenceNumber))
+		case jitterLost:
+			got = append(got, -1)
+		}
+	}
+	expected := []int{65534, 65535, 0, 1, 2, -1, 4, 5}
+	if len(got) != len(expected) {
+		t.Fatalf("unexpected output: %v", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("unexpected output: %v, expected %v", got, expected)
+		}
+	}
+
+	// 序列号 3 迟到，已经错过播放时间
+	jb.Push(jitterPacketAt(3), start.Add(time.Second))
+	jb.Push(jitterPacketAt(6), start.Add(time.Second))
+	stats := jb.Stats()
+	if stats.Lost != 1 || stats.Reordered != 1 || stats.Late != 1 || stats.Duplicate != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// 测试到达抖动增大时目标缓冲深度随之增加
+func TestJitterBuffer_AdaptiveDepth(t *testing.T) {
+	jb := newJitterBuffer(8000)
+	start := time.Now()
+	for seq := uint16(0); seq < 50; seq++ {
+		arrival := start.Add(time.Duration(seq) * audioFrameDuration)
+		if seq%2 == 1 {
+			arrival = arrival.Add(60 * time.Millisecond)
+		}
+		jb.Push(jitterPacketAt(seq), arrival)
+		jb.Pop()
+	}
+	stats := jb.Stats()
+	if stats.TargetDepth <= jitterMinDepth || stats.JitterMs < 20 {
+		t.Errorf("target depth did not adapt: %+v", stats)
+	}
+}
+
+// 测试丢包补偿逐帧衰减后输出静音
+func TestLossConcealer(t *testing.T) {
+	var concealer lossConcealer
+	if concealer.conceal() != nil {
+		t.Fatalf("conceal before any good frame should return nil")
+	}
+	frame := make([]byte, 320)
+	for i := 0; i < len(frame); i += 2 {
+		binary.LittleEndian.PutUint16(frame[i:], uint16(int16(8000)))
+	}
+	concealer.good(frame)
+
+	last := int16(8000)
+	for i := 0; i < plcMaxFrames; i++ {
+		sample := int16(binary.LittleEndian.Uint16(concealer.conceal()))
+		if sample <= 0 || sample >= last {
+			t.Fatalf("frame %d not attenuated: %d", i, sample)
+		}
+		last = sample
+	}
+	if sample := int16(binary.LittleEndian.Uint16(concealer.conceal())); sample != 0 {
+		t.Errorf("expected silence after %d lost frames, got %d", plcMaxFrames, sample)
+	}
+}
+
+// fakeFECDecoder 把下一个包的负载作为恢复的帧返回
+type fakeFECDecoder struct {
+	recovered [][]byte
+}
+
+func (d *fakeFECDecoder) Decode(payload []byte) ([]byte, error) {
+	return payload, nil
+}
+
+func (d *fakeFECDecoder) DecodeFEC(next []byte) ([]byte, error) {
+	d.recovered = append(d.recovered, next)
+	return []byte{next[0], 0}, nil
+}
+
+// 测试丢包时支持 FEC 的解码器从下一个包恢复，下一个包也没到时退化为丢包补偿
+func TestConcealLoss_FEC(t *testing.T) {
+	jb := newJitterBuffer(8000)
+	start := time.Now()
+	// 序列号 1 丢失，2 已到达；4 和 5 都丢失
+	for i, seq := range []uint16{65534, 65535, 0, 2, 3, 6} {
+		jb.Push(jitterPacketAt(seq), start.Add(time.Duration(i)*audioFrameDuration))
+	}
+	decoder := &fakeFECDecoder{}
+	detector := &dtmfDetector{}
+	var concealer lossConcealer
+
+	var got []byte
+	for i := 0; i < 8; i++ {
+		packet, result := jb.Pop()
+		switch result {
+		case jitterPacket:
+			concealer.good([]byte{packet.Payload[0], 0})
+		case jitterLost:
+			got = append(got, concealLoss(jb, decoder, detector, &concealer)[0])
+		}
+	}
+	// 1 由 2 恢复，4 的下一个包 5 也丢失，只能重复 3 做补偿，5 由 6 恢复
+	if len(got) != 3 || got[0] != 2 || got[1] == 0 || got[2] != 6 {
+		t.Errorf("unexpected concealed frames: %v", got)
+	}
+	if len(decoder.recovered) != 2 {
+		t.Errorf("expected 2 FEC recoveries, got %d", len(decoder.recovered))
+	}
+}
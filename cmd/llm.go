@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/sashabaranov/go-openai"
@@ -22,6 +25,16 @@ type LLMHandler struct {
 	messages    []openai.ChatCompletionMessage
 	hangupChan  chan struct{}
 	interruptCh chan struct{}
+	stages      []Stage
+	stage       *Stage
+	activeStage atomic.Pointer[Stage] // copy of stage readable while QueryStream holds the mutex
+	form        *Form
+	transcript  []TranscriptEntry
+	format      *openai.ChatCompletionResponseFormat
+	// OnStageChange is called after the conversation was handed off to another stage
+	OnStageChange func(from, to *Stage, summary string)
+	// OnToolCall is called for every function call requested by the model
+	OnToolCall func(name, arguments string)
 }
 
 // ToolCall represents a function call from the LLM
@@ -29,6 +42,9 @@ type HangupTool struct {
 	Reason string `json:"reason"`
 }
 
+// maxToolRounds limits how many completions (handoffs, form tools) can be chained within a single user turn
+const maxToolRounds = 4
+
 // NewLLMHandler creates a new LLM handler
 func NewLLMHandler(ctx context.Context, apiKey, endpoint, systemPrompt string, logger *logrus.Logger) *LLMHandler {
 	config := openai.DefaultConfig(apiKey)
@@ -64,57 +80,136 @@ func (h *LLMHandler) QueryStream(model, text string, ttsCallback func(segment st
 		Content: text,
 	})
 
-	// Define the function for hanging up
-	functionDefinition := openai.FunctionDefinition{
-		Name:        "hangup",
-		Description: "End the conversation and hang up the call",
-		Parameters: json.RawMessage(`{
-			"type": "object",
-			"properties": {
-				"reason": {
-					"type": "string",
-					"description": "Reason for hanging up the call"
+	h.addTranscript(openai.ChatMessageRoleUser, text)
+
+	// Generate a unique playID for this conversation
+	playID := fmt.Sprintf("llm-%s", uuid.New().String())
+	h.logger.WithField("playID", playID).Info("Starting LLM stream with playID")
+
+	fullResponse := ""
+	var buffer string
+	var shouldHangup bool
+	for round := 0; ; round++ {
+		response, toolCalls, remainder, err := h.streamCompletion(model, playID, ttsCallback)
+		if err != nil {
+			return "", err
+		}
+		buffer = remainder
+		fullResponse += response
+		if response != "" {
+			h.addTranscript(openai.ChatMessageRoleAssistant, response)
+		}
+
+		// Add assistant's complete response to conversation history
+		h.messages = append(h.messages, openai.ChatCompletionMessage{
+			Role:      openai.ChatMessageRoleAssistant,
+			Content:   response,
+			ToolCalls: toolCalls,
+		})
+
+		var handoff *HandoffTool
+		followUp := false
+		for _, toolCall := range toolCalls {
+			if h.OnToolCall != nil {
+				h.OnToolCall(toolCall.Function.Name, toolCall.Function.Arguments)
+			}
+			result := "ok"
+			switch toolCall.Function.Name {
+			case "hangup":
+				h.logger.Info("LLM requested hangup")
+				shouldHangup = true
+			case "handoff":
+				handoff = &HandoffTool{}
+				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), handoff); err != nil {
+					h.logger.WithError(err).Error("Failed to parse handoff arguments")
+					handoff = nil
+					result = "invalid arguments"
 				}
-			},
-			"required": []
-		}`),
+			case "fill_slot", "confirm_slot":
+				// The model has to tell the caller about the outcome
+				result = h.handleFormTool(toolCall)
+				followUp = true
+			}
+			h.messages = append(h.messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    result,
+				ToolCallID: toolCall.ID,
+			})
+		}
+
+		if shouldHangup || (handoff == nil && !followUp) {
+			break
+		}
+		if round >= maxToolRounds {
+			h.logger.Warn("Too many tool rounds in one turn, ignoring")
+			break
+		}
+		// Flush what was said so far before the next completion starts talking,
+		// the handoff changes the voice of what follows
+		if buffer != "" {
+			if err := ttsCallback(buffer, playID, false); err != nil {
+				h.logger.WithError(err).Error("Failed to send TTS segment")
+			}
+			buffer = ""
+		}
+		if handoff != nil {
+			if err := h.switchStage(handoff.Stage, handoff.Summary); err != nil {
+				h.logger.WithError(err).Error("Failed to hand off")
+				break
+			}
+			// Let the new stage answer the same user turn
+			h.messages = append(h.messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: text,
+			})
+		}
 	}
 
+	// Send any remaining text in the buffer
+	if err := ttsCallback(buffer, playID, shouldHangup); err != nil {
+		h.logger.WithError(err).Error("Failed to send final TTS segment")
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"responseLength": len(fullResponse),
+		"hangup":         shouldHangup,
+	}).Info("LLM stream completed")
+
+	return fullResponse, nil
+}
+
+// streamCompletion runs a single streaming completion, sending complete sentences to TTS.
+// It returns the full text, the tool calls requested by the model and the text not yet sent to TTS
+func (h *LLMHandler) streamCompletion(model, playID string, ttsCallback func(segment string, playID string, autoHangup bool) error) (string, []openai.ToolCall, string, error) {
 	// Construct the OpenAI request
 	if model == "" {
 		model = openai.GPT4o
 	}
 	request := openai.ChatCompletionRequest{
 		Model:       model,
-		Messages:    h.messages,
+		Messages:    h.requestMessages(),
 		Temperature: 0.7,
 		Stream:      true,
-		Tools: []openai.Tool{
-			{
-				Type:     openai.ToolTypeFunction,
-				Function: &functionDefinition,
-			},
-		},
+		Tools:       h.tools(),
 	}
 
-	// Generate a unique playID for this conversation
-	playID := fmt.Sprintf("llm-%s", uuid.New().String())
-	h.logger.WithField("playID", playID).Info("Starting LLM stream with playID")
-
 	// Stream for handling responses
 	stream, err := h.client.CreateChatCompletionStream(h.ctx, request)
 	if err != nil {
-		return "", fmt.Errorf("error creating chat completion stream: %w", err)
+		return "", nil, "", fmt.Errorf("error creating chat completion stream: %w", err)
 	}
 	defer stream.Close()
 
 	// Buffer to collect text until punctuation
 	var buffer string
 	fullResponse := ""
-	var shouldHangup bool
+	// Tool calls arrive in fragments, keyed by their index
+	toolCalls := map[int]*openai.ToolCall{}
 
-	// Regular expression to detect punctuation followed by space or end of string
-	punctuationRegex := regexp.MustCompile(`([.,;:!?，。！？；：])\s*`)
+	// Regular expression to detect the end of a segment. ASCII punctuation only ends a segment
+	// when followed by whitespace so emails, decimals and URLs reach the guardrail in one piece,
+	// punctuation at the end of the buffer waits for the next chunk
+	punctuationRegex := regexp.MustCompile(`[，。！？；：]\s*|[.,;:!?]\s+`)
 
 	// Process the stream of responses
 	for {
@@ -124,16 +219,28 @@ func (h *LLMHandler) QueryStream(model, text string, ttsCallback func(segment st
 				// Stream closed normally
 				break
 			}
-			return "", fmt.Errorf("error receiving from stream: %w", err)
+			return "", nil, "", fmt.Errorf("error receiving from stream: %w", err)
 		}
 
-		// Check for function calls (hangup)
+		// Collect function calls (hangup, handoff, form tools)
 		if len(response.Choices) > 0 && len(response.Choices[0].Delta.ToolCalls) > 0 {
-			for _, toolCall := range response.Choices[0].Delta.ToolCalls {
-				if toolCall.Function.Name == "hangup" {
-					h.logger.Info("LLM requested hangup")
-					shouldHangup = true
+			for _, delta := range response.Choices[0].Delta.ToolCalls {
+				index := len(toolCalls)
+				if delta.Index != nil {
+					index = *delta.Index
+				}
+				toolCall, ok := toolCalls[index]
+				if !ok {
+					toolCall = &openai.ToolCall{Type: openai.ToolTypeFunction}
+					toolCalls[index] = toolCall
+				}
+				if delta.ID != "" {
+					toolCall.ID = delta.ID
 				}
+				if delta.Function.Name != "" {
+					toolCall.Function.Name = delta.Function.Name
+				}
+				toolCall.Function.Arguments += delta.Function.Arguments
 			}
 		}
 
@@ -169,23 +276,16 @@ func (h *LLMHandler) QueryStream(model, text string, ttsCallback func(segment st
 		}
 	}
 
-	// Send any remaining text in the buffer
-	if err := ttsCallback(buffer, playID, shouldHangup); err != nil {
-		h.logger.WithError(err).Error("Failed to send final TTS segment")
+	indexes := make([]int, 0, len(toolCalls))
+	for index := range toolCalls {
+		indexes = append(indexes, index)
 	}
-
-	// Add assistant's complete response to conversation history
-	h.messages = append(h.messages, openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleAssistant,
-		Content: fullResponse,
-	})
-
-	h.logger.WithFields(logrus.Fields{
-		"responseLength": len(fullResponse),
-		"hangup":         shouldHangup,
-	}).Info("LLM stream completed")
-
-	return fullResponse, nil
+	sort.Ints(indexes)
+	calls := make([]openai.ToolCall, 0, len(indexes))
+	for _, index := range indexes {
+		calls = append(calls, *toolCalls[index])
+	}
+	return fullResponse, calls, buffer, nil
 }
 
 // Query the LLM with text and get a response (non-streaming version, kept for compatibility)
@@ -198,22 +298,7 @@ func (h *LLMHandler) Query(model, text string) (string, *HangupTool, error) {
 		Role:    openai.ChatMessageRoleUser,
 		Content: text,
 	})
-
-	// Define the function for hanging up
-	functionDefinition := openai.FunctionDefinition{
-		Name:        "hangup",
-		Description: "End the conversation and hang up the call",
-		Parameters: json.RawMessage(`{
-			"type": "object",
-			"properties": {
-				"reason": {
-					"type": "string",
-					"description": "Reason for hanging up the call"
-				}
-			},
-			"required": []
-		}`),
-	}
+	h.addTranscript(openai.ChatMessageRoleUser, text)
 
 	// Construct the OpenAI request
 	if model == "" {
@@ -221,14 +306,14 @@ func (h *LLMHandler) Query(model, text string) (string, *HangupTool, error) {
 	}
 	request := openai.ChatCompletionRequest{
 		Model:       model,
-		Messages:    h.messages,
+		Messages:    h.requestMessages(),
 		Temperature: 0.7,
-		Tools: []openai.Tool{
-			{
-				Type:     openai.ToolTypeFunction,
-				Function: &functionDefinition,
-			},
-		},
+	}
+	// Structured output replaces the tools
+	if h.format != nil {
+		request.ResponseFormat = h.format
+	} else {
+		request.Tools = h.tools()
 	}
 
 	// Send the request to OpenAI
@@ -238,15 +323,26 @@ func (h *LLMHandler) Query(model, text string) (string, *HangupTool, error) {
 	}
 
 	// Process the response
+	if len(response.Choices) == 0 {
+		return "", nil, fmt.Errorf("empty response from OpenAI")
+	}
 	message := response.Choices[0].Message
 	h.messages = append(h.messages, message)
+	if message.Content != "" {
+		h.addTranscript(openai.ChatMessageRoleAssistant, message.Content)
+	}
 
 	// Check if there's a tool call for hangup
 	var hangupTool *HangupTool
+	var handoff *HandoffTool
 	// Check for tool calls
 	if len(message.ToolCalls) > 0 {
 		for _, toolCall := range message.ToolCalls {
-			if toolCall.Function.Name == "hangup" {
+			if h.OnToolCall != nil {
+				h.OnToolCall(toolCall.Function.Name, toolCall.Function.Arguments)
+			}
+			switch toolCall.Function.Name {
+			case "hangup":
 				hangupTool = &HangupTool{}
 				// Parse the arguments
 				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), hangupTool); err != nil {
@@ -254,7 +350,27 @@ func (h *LLMHandler) Query(model, text string) (string, *HangupTool, error) {
 				} else {
 					h.logger.WithField("reason", hangupTool.Reason).Info("llm: Hangup reason")
 				}
+			case "handoff":
+				handoff = &HandoffTool{}
+				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), handoff); err != nil {
+					h.logger.WithError(err).Error("Failed to parse handoff arguments")
+					handoff = nil
+				}
+			}
+			result := "ok"
+			if toolCall.Function.Name == "fill_slot" || toolCall.Function.Name == "confirm_slot" {
+				result = h.handleFormTool(toolCall)
 			}
+			h.messages = append(h.messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    result,
+				ToolCallID: toolCall.ID,
+			})
+		}
+	}
+	if handoff != nil && hangupTool == nil {
+		if err := h.switchStage(handoff.Stage, handoff.Summary); err != nil {
+			h.logger.WithError(err).Error("Failed to hand off")
 		}
 	}
 
@@ -270,7 +386,266 @@ func (h *LLMHandler) Reset() {
 	h.messages = []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: h.systemMsg,
+			Content: h.systemPrompt(),
 		},
 	}
 }
+
+// SetResponseFormat makes Query return structured output following format, tools are disabled
+func (h *LLMHandler) SetResponseFormat(format *openai.ChatCompletionResponseFormat) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.format = format
+}
+
+// Transcript returns the conversation of all stages so far
+func (h *LLMHandler) Transcript() []TranscriptEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]TranscriptEntry{}, h.transcript...)
+}
+
+func (h *LLMHandler) addTranscript(speaker, text string) {
+	entry := TranscriptEntry{
+		Timestamp: time.Now(),
+		Speaker:   speaker,
+		Text:      text,
+	}
+	if h.stage != nil {
+		entry.Stage = h.stage.Name
+	}
+	h.transcript = append(h.transcript, entry)
+}
+
+// fork creates a handler sharing the OpenAI client with a fresh conversation
+func (h *LLMHandler) fork(systemPrompt string) *LLMHandler {
+	return &LLMHandler{
+		client:    h.client,
+		systemMsg: systemPrompt,
+		logger:    h.logger,
+		ctx:       h.ctx,
+		messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: systemPrompt,
+			},
+		},
+		hangupChan:  make(chan struct{}),
+		interruptCh: make(chan struct{}, 1),
+	}
+}
+
+// SetStages configures the stages of a multi-stage conversation and enters the initial stage
+func (h *LLMHandler) SetStages(config *StageConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.stages = config.Stages
+	h.stage = h.findStage(config.Initial)
+	h.activeStage.Store(h.stage)
+	h.messages = []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: h.systemPrompt(),
+		},
+	}
+	return nil
+}
+
+// SetForm enables form-filling mode, the form state is sent to the model with every request
+func (h *LLMHandler) SetForm(form *Form) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.form = form
+}
+
+// Form returns the form of form-filling mode, nil if disabled
+func (h *LLMHandler) Form() *Form {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.form
+}
+
+// handleFormTool executes a form tool call and returns the result for the model
+func (h *LLMHandler) handleFormTool(toolCall openai.ToolCall) string {
+	if h.form == nil {
+		return "error: form-filling mode is disabled"
+	}
+	result := h.form.HandleTool(toolCall.Function.Name, toolCall.Function.Arguments)
+	h.logger.WithFields(logrus.Fields{
+		"tool":      toolCall.Function.Name,
+		"arguments": toolCall.Function.Arguments,
+		"result":    result,
+	}).Info("llm: Form tool")
+	return result
+}
+
+// requestMessages returns the history sent to the model, with the form state appended
+func (h *LLMHandler) requestMessages() []openai.ChatCompletionMessage {
+	if h.form == nil {
+		return h.messages
+	}
+	messages := make([]openai.ChatCompletionMessage, 0, len(h.messages)+1)
+	messages = append(messages, h.messages...)
+	return append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: h.form.Prompt(),
+	})
+}
+
+// CurrentStage returns the active stage, nil if the handler runs without stages.
+// It does not take the mutex so the stream callback of QueryStream can call it
+func (h *LLMHandler) CurrentStage() *Stage {
+	return h.activeStage.Load()
+}
+
+// systemPrompt returns the prompt of the active stage or the plain system prompt
+func (h *LLMHandler) systemPrompt() string {
+	if h.stage != nil {
+		return h.stage.SystemPrompt
+	}
+	return h.systemMsg
+}
+
+func (h *LLMHandler) findStage(name string) *Stage {
+	for i := range h.stages {
+		if h.stages[i].Name == name {
+			return &h.stages[i]
+		}
+	}
+	return nil
+}
+
+// switchStage moves the conversation to another stage, the new stage starts
+// with a fresh history that only carries the summary of the previous stage
+func (h *LLMHandler) switchStage(name, summary string) error {
+	if h.stage == nil {
+		return fmt.Errorf("handoff without stages")
+	}
+	next := h.findStage(name)
+	if next == nil {
+		return fmt.Errorf("unknown stage: %s", name)
+	}
+	allowed := false
+	for _, target := range handoffTargets(h.stage, h.stages) {
+		if target.Name == next.Name {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("stage %s can not hand off to %s", h.stage.Name, name)
+	}
+
+	from := h.stage
+	h.stage = next
+	h.activeStage.Store(next)
+	h.addTranscript(openai.ChatMessageRoleSystem, fmt.Sprintf("handoff from %s: %s", from.Name, summary))
+	h.messages = []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: stagePrompt(next, from.Name, summary),
+		},
+	}
+	h.logger.WithFields(logrus.Fields{
+		"from":    from.Name,
+		"to":      next.Name,
+		"summary": summary,
+	}).Info("llm: Handoff")
+	if h.OnStageChange != nil {
+		h.OnStageChange(from, next, summary)
+	}
+	return nil
+}
+
+// tools returns the tools available in the active stage
+func (h *LLMHandler) tools() []openai.Tool {
+	var names []string
+	if h.stage != nil && len(h.stage.Tools) > 0 {
+		names = h.stage.Tools
+	} else {
+		names = []string{"hangup", "handoff", "form"}
+	}
+
+	var tools []openai.Tool
+	for _, name := range names {
+		var definition *openai.FunctionDefinition
+		switch name {
+		case "hangup":
+			definition = hangupFunction()
+		case "handoff":
+			if h.stage == nil {
+				continue
+			}
+			definition = handoffFunction(handoffTargets(h.stage, h.stages))
+		case "form":
+			if h.form != nil {
+				tools = append(tools, h.form.tools()...)
+			}
+			continue
+		default:
+			h.logger.WithField("tool", name).Warn("llm: Unknown tool")
+		}
+		if definition == nil {
+			continue
+		}
+		tools = append(tools, openai.Tool{
+			Type:     openai.ToolTypeFunction,
+			Function: definition,
+		})
+	}
+	return tools
+}
+
+// hangupFunction defines the function for hanging up
+func hangupFunction() *openai.FunctionDefinition {
+	return &openai.FunctionDefinition{
+		Name:        "hangup",
+		Description: "End the conversation and hang up the call",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"reason": {
+					"type": "string",
+					"description": "Reason for hanging up the call"
+				}
+			},
+			"required": []
+		}`),
+	}
+}
+
+// handoffFunction defines the function for handing the caller to another stage
+func handoffFunction(targets []Stage) *openai.FunctionDefinition {
+	if len(targets) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(targets))
+	description := "Hand the caller over to another agent. Available agents:"
+	for _, target := range targets {
+		names = append(names, target.Name)
+		description += fmt.Sprintf("\n- %s: %s", target.Name, target.Description)
+	}
+	parameters := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"stage": map[string]any{
+				"type":        "string",
+				"enum":        names,
+				"description": "The agent to hand the caller over to",
+			},
+			"summary": map[string]any{
+				"type":        "string",
+				"description": "Summary of the conversation so far for the next agent",
+			},
+		},
+		"required": []string{"stage", "summary"},
+	}
+	return &openai.FunctionDefinition{
+		Name:        "handoff",
+		Description: description,
+		Parameters:  parameters,
+	}
+}
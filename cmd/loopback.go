@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// loopbackMaxLatency 对齐波形时搜索的最大时延
+	loopbackMaxLatency = time.Second
+	// loopbackSearchWindow 搜索整体时延时参与相关计算的参考信号长度
+	loopbackSearchWindow = 2 * time.Second
+	// loopbackSegment 逐段比较波形时每段的长度
+	loopbackSegment = 500 * time.Millisecond
+	// loopbackSlip 逐段对齐时允许偏离整体时延的范围
+	loopbackSlip = 100 * time.Millisecond
+)
+
+// LoopbackReport 本地回环测试的结果
+type LoopbackReport struct {
+	Codec       string        `json:"codec"`       // 协商确定的编解码器
+	SampleRate  int           `json:"sampleRate"`  // 编解码器的 PCM 采样率
+	DurationMs  float64       `json:"durationMs"`  // 参考音频的时长
+	LatencyMs   float64       `json:"latencyMs"`   // 从输入到输出的端到端时延
+	Score       float64       `json:"score"`       // 逐段对齐后输出与参考波形的归一化相关系数，1 表示完全一致
+	Slips       int           `json:"slips"`       // 时延发生变化的次数，即链路中插入或丢弃数据的次数
+	LossPercent float64       `json:"lossPercent"` // 接收端丢包率，包含迟到丢弃的包
+	Jitter      JitterStats   `json:"jitter"`      // 接收端抖动缓冲统计
+	Outbound    OutboundStats `json:"outbound"`    // 发送端采集和发送统计
+	Playback    PlaybackStats `json:"playback"`    // 接收端播放缓冲统计
+}
+
+// loopbackOptions 本地回环测试的参数
+type loopbackOptions struct {
+	OfferCodec  string // 发起方提供的编解码器列表
+	AnswerCodec string // 应答方支持的编解码器列表
+	Reference   []byte // 发送的参考音频，S16LE 单声道
+	SampleRate  int    // 参考音频的采样率
+	Logger      *logrus.Logger
+}
+
+// loopbackTestSignal 生成回环测试的参考信号：三个频率互不成倍数的正弦波，按伪随机的 40ms 片段开关
+// 开关图案保证波形相关只在真实时延处出现唯一的峰值
+func loopbackTestSignal(sampleRate int, duration time.Duration) []byte {
+	samples := int(duration.Seconds() * float64(sampleRate))
+	segment := sampleRate * 40 / 1000
+	rng := rand.New(rand.NewSource(1))
+	pcm := make([]byte, samples*2)
+	on := true
+	for i := 0; i < samples; i++ {
+		if i%segment == 0 {
+			on = rng.Intn(3) > 0
+		}
+		if !on {
+			continue
+		}
+		t := float64(i) / float64(sampleRate)
+		value := 3000*math.Sin(2*math.Pi*311*t) + 2500*math.Sin(2*math.Pi*743*t) + 2000*math.Sin(2*math.Pi*1277*t)
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(clampSample(value)))
+	}
+	return pcm
+}
+
+// pcmToFloat 把 S16LE 数据转换为浮点采样
+func pcmToFloat(pcm []byte) []float64 {
+	samples := make([]float64, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+	}
+	return samples
+}
+
+// alignSignals 在 minLag 到 maxLag 个采样的范围内搜索 out 相对 ref 的时延，返回时延和归一化相关系数
+func alignSignals(ref, out []float64, minLag, maxLag int) (int, float64) {
+	var refEnergy float64
+	for _, v := range ref {
+		refEnergy += v * v
+	}
+	bestLag, best := 0, 0.0
+	if minLag < 0 {
+		minLag = 0
+	}
+	for lag := minLag; lag <= maxLag && lag+len(ref) <= len(out); lag++ {
+		var dot, outEnergy float64
+		for i, v := range out[lag : lag+len(ref)] {
+			dot += ref[i] * v
+			outEnergy += v * v
+		}
+		if refEnergy == 0 || outEnergy == 0 {
+			continue
+		}
+		if corr := dot / math.Sqrt(refEnergy*outEnergy); corr > best {
+			bestLag, best = lag, corr
+		}
+	}
+	return bestLag, best
+}
+
+// waveformComparison 参考信号与收到的信号逐段对齐比较的结果
+type waveformComparison struct {
+	Lag   int     // 各段时延的中位数，单位为采样
+	Score float64 // 各段相关系数按参考信号能量的加权平均
+	Slips int     // 相邻两段时延不同的次数，即播放中插入或丢弃数据的次数
+}
+
+// compareWaveforms 先用开头一段信号找到整体时延，再把参考信号分段在整体时延附近分别对齐
+// 分段对齐使中途的一次丢帧或补帧只影响所在的段，不会拉低整个比较结果
+func compareWaveforms(reference, received []byte, sampleRate int) waveformComparison {
+	ref, out := pcmToFloat(reference), pcmToFloat(received)
+	window := int(loopbackSearchWindow.Seconds() * float64(sampleRate))
+	if window > len(ref) {
+		window = len(ref)
+	}
+	lag, _ := alignSignals(ref[:window], out, 0, int(loopbackMaxLatency.Seconds()*float64(sampleRate)))
+
+	segment := int(loopbackSegment.Seconds() * float64(sampleRate))
+	slack := int(loopbackSlip.Seconds() * float64(sampleRate))
+	var result waveformComparison
+	var lags []int
+	var weighted, weights float64
+	for start := 0; start+segment <= len(ref); start += segment {
+		part := ref[start : start+segment]
+		var energy float64
+		for _, v := range part {
+			energy += v * v
+		}
+		if energy == 0 {
+			continue
+		}
+		// 收到的信号比参考信号短，例如对方的音轨开始得晚，缺少的段按不相关计入
+		if start+segment > len(out) {
+			weights += energy
+			continue
+		}
+		partLag, corr := alignSignals(part, out[start:], lag-slack, lag+slack)
+		if len(lags) > 0 && partLag != lags[len(lags)-1] {
+			result.Slips++
+		}
+		lags = append(lags, partLag)
+		weighted += corr * energy
+		weights += energy
+	}
+	if len(lags) == 0 {
+		return result
+	}
+	sort.Ints(lags)
+	result.Lag = lags[len(lags)/2]
+	result.Score = weighted / weights
+	return result
+}
+
+// waitConnected 等待对等连接建立
+func waitConnected(ctx context.Context, mh *MediaHandler, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for mh.peerConnection.ConnectionState() != webrtc.PeerConnectionStateConnected {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("peer connection not connected after %v", timeout)
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// connectLoopback 在进程内完成 caller 和 callee 的 offer/answer 协商，等待双方连接
+func connectLoopback(ctx context.Context, caller, callee *MediaHandler, offerCodec, answerCodec string) error {
+	offer, err := caller.Setup(offerCodec, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create offer: %w", err)
+	}
+	answer, err := callee.AnswerOffer(offer, answerCodec, nil)
+	if err != nil {
+		return fmt.Errorf("failed to answer: %w", err)
+	}
+	if err := caller.SetupAnswer(answer); err != nil {
+		return fmt.Errorf("failed to setup answer: %w", err)
+	}
+	for _, mh := range []*MediaHandler{caller, callee} {
+		if err := waitConnected(ctx, mh, 10*time.Second); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runLoopbackTest 在进程内创建发起方和应答方两个 MediaHandler，直接交换 SDP 建立连接
+// 发起方发送参考音频，应答方保存收到的音频，结束后对齐波形计算时延和相似度
+// 返回测试结果和应答方收到的音频
+func runLoopbackTest(ctx context.Context, options loopbackOptions) (*LoopbackReport, []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	logger := options.Logger
+
+	caller, err := NewMediaHandler(ctx, logger,
+		WithAudioSource(&pcmSource{pcm: options.Reference, sampleRate: options.SampleRate}),
+		WithAudioSink(&nullSink{}))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer caller.Stop()
+	sink := &memorySink{}
+	callee, err := NewMediaHandler(ctx, logger, WithAudioSource(&silenceSource{}), WithAudioSink(sink))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer callee.Stop()
+
+	if err := connectLoopback(ctx, caller, callee, options.OfferCodec, options.AnswerCodec); err != nil {
+		return nil, nil, err
+	}
+
+	// 参考音频播完后再等待最大时延，保证尾部也被接收
+	duration := time.Duration(len(options.Reference)/2) * time.Second / time.Duration(options.SampleRate)
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-time.After(duration + loopbackMaxLatency):
+	}
+	received := sink.Data()
+	jitter := callee.JitterStats()
+	playback := callee.PlaybackStats()
+	outbound := caller.OutboundStats()
+
+	codec := callee.codec
+	reference := options.Reference
+	if options.SampleRate != codec.SampleRate {
+		reference = resamplePCM(reference, options.SampleRate, codec.SampleRate)
+	}
+	comparison := compareWaveforms(reference, received, codec.SampleRate)
+	report := &LoopbackReport{
+		Codec:      codec.Name,
+		SampleRate: codec.SampleRate,
+		DurationMs: float64(duration) / float64(time.Millisecond),
+		LatencyMs:  float64(comparison.Lag) * 1000 / float64(codec.SampleRate),
+		Score:      math.Round(comparison.Score*1000) / 1000,
+		Slips:      comparison.Slips,
+		Jitter:     jitter,
+		Outbound:   outbound,
+		Playback:   playback,
+	}
+	if expected := jitter.Received + jitter.Lost; expected > 0 {
+		report.LossPercent = float64(jitter.Lost+jitter.Late) * 100 / float64(expected)
+	}
+	return report, received, nil
+}
+
+// runLoopback loopback 子命令的入口，不连接服务器验证编解码器和媒体链路，返回进程退出码
+func runLoopback(args []string) int {
+	flags := flag.NewFlagSet("loopback", flag.ExitOnError)
+	codec := flags.String("codec", "g722,pcmu,pcma", "Codecs offered by the caller in priority order")
+	answerCodec := flags.String("answer-codec", "", "Codecs supported by the callee, defaults to --codec")
+	input := flags.String("input", "", "WAV file to stream through, a generated test signal is used when empty")
+	duration := flags.Duration("duration", 5*time.Second, "Length of the generated test signal")
+	output := flags.String("output", "", "Write the audio received by the callee to this WAV file")
+	report := flags.String("report", "", "Write the JSON report to this file")
+	minScore := flags.Float64("min-score", 0, "Exit with an error when the waveform score is below this value")
+	verbose := flags.Bool("verbose", false, "Log the media handlers at info level")
+	flags.Parse(args)
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+	if *verbose {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+	if *answerCodec == "" {
+		*answerCodec = *codec
+	}
+
+	options := loopbackOptions{OfferCodec: *codec, AnswerCodec: *answerCodec, Logger: logger}
+	if *input != "" {
+		pcm, sampleRate, err := readWAV(*input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", *input, err)
+			return 1
+		}
+		options.Reference, options.SampleRate = pcm, sampleRate
+	} else {
+		options.SampleRate = 16000
+		options.Reference = loopbackTestSignal(options.SampleRate, *duration)
+	}
+
+	result, received, err := runLoopbackTest(context.Background(), options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Loopback test failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Codec: %s\n", result.Codec)
+	fmt.Printf("Duration: %.0fms\n", result.DurationMs)
+	fmt.Printf("Latency: %.1fms\n", result.LatencyMs)
+	fmt.Printf("Loss: %.2f%% (lost %d, late %d of %d received)\n", result.LossPercent, result.Jitter.Lost, result.Jitter.Late, result.Jitter.Received)
+	fmt.Printf("Jitter: %.1fms\n", result.Jitter.JitterMs)
+	fmt.Printf("Waveform score: %.3f (%d slips)\n", result.Score, result.Slips)
+
+	if *output != "" {
+		writer, err := newWAVWriter(*output, result.SampleRate, 1)
+		if err == nil {
+			_, err = writer.Write(received)
+			if closeErr := writer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *output, err)
+			return 1
+		}
+	}
+	if *report != "" {
+		data, _ := json.MarshalIndent(result, "", "  ")
+		if err := os.WriteFile(*report, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			return 1
+		}
+	}
+	if result.Score < *minScore {
+		fmt.Fprintf(os.Stderr, "Waveform score %.3f is below %.3f\n", result.Score, *minScore)
+		return 1
+	}
+	return 0
+}
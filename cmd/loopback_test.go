@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 测试逐段比较波形：找到整体时延，中途插入一帧只记一次时延变化
+func TestCompareWaveforms(t *testing.T) {
+	reference := loopbackTestSignal(8000, 3*time.Second)
+	// 延迟 50ms，2.4 秒处插入 20ms 静音
+	received := make([]byte, 400*2)
+	received = append(received, reference[:19200*2]...)
+	received = append(received, make([]byte, 160*2)...)
+	received = append(received, reference[19200*2:]...)
+
+	result := compareWaveforms(reference, received, 8000)
+	if result.Lag != 400 {
+		t.Errorf("expected lag 400 samples, got %d", result.Lag)
+	}
+	if result.Slips != 1 {
+		t.Errorf("expected 1 slip, got %d", result.Slips)
+	}
+	if result.Score < 0.9 {
+		t.Errorf("expected a high score, got %.3f", result.Score)
+	}
+}
+
+// 测试收到的信号比参考信号短时不会越界，缺少的段拉低分数
+func TestCompareWaveforms_ShortReceived(t *testing.T) {
+	reference := loopbackTestSignal(8000, 3*time.Second)
+	// 只收到前 1.2 秒
+	result := compareWaveforms(reference, reference[:9600*2], 8000)
+	if result.Score < 0.2 || result.Score > 0.6 {
+		t.Errorf("expected the missing segments to lower the score, got %.3f", result.Score)
+	}
+	if result := compareWaveforms(reference, nil, 8000); result.Score != 0 {
+		t.Errorf("expected score 0 without received audio, got %.3f", result.Score)
+	}
+}
+
+// 测试两个 MediaHandler 在进程内直接协商并传输音频
+func TestRunLoopbackTest(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	report, received, err := runLoopbackTest(context.Background(), loopbackOptions{
+		OfferCodec:  "g722,pcmu",
+		AnswerCodec: "pcmu",
+		Reference:   loopbackTestSignal(8000, time.Second),
+		SampleRate:  8000,
+		Logger:      logger,
+	})
+	if err != nil {
+		t.Fatalf("runLoopbackTest returned an error: %v", err)
+	}
+	if report.Codec != "pcmu" || len(received) == 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.Score < 0.5 || report.LatencyMs <= 0 || report.LatencyMs > 500 {
+		t.Errorf("unexpected waveform comparison: score %.3f latency %.1fms", report.Score, report.LatencyMs)
+	}
+	if report.LossPercent > 5 {
+		t.Errorf("unexpected loss %.1f%%", report.LossPercent)
+	}
+}
@@ -6,6 +6,19 @@ import (
 )
 
 func main() {
+	// 子命令：离线评测
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		os.Exit(runEval(os.Args[2:]))
+	}
+	// 子命令：本地回环测试媒体链路
+	if len(os.Args) > 1 && os.Args[1] == "loopback" {
+		os.Exit(runLoopback(os.Args[2:]))
+	}
+	// 子命令：列出声卡设备
+	if len(os.Args) > 1 && os.Args[1] == "devices" {
+		os.Exit(runDevices(os.Args[2:]))
+	}
+
 	// 初始化设置
 	config, err := LoadConfig()
 	if err != nil {
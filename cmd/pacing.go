@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+const (
+	// captureMaxBuffered 捕获缓冲区最多保留的音频时长，超过后丢弃最旧的数据
+	captureMaxBuffered = 200 * time.Millisecond
+	// captureHighWater 积压超过该时长时认为输入设备时钟偏快，开始做漂移校正
+	captureHighWater = 60 * time.Millisecond
+	// captureDriftStep 漂移校正时每帧丢弃的音频时长，足够小以免听出来
+	captureDriftStep = time.Millisecond
+	// captureDriftFade 漂移校正时在丢弃处做交叉淡化的时长，避免波形跳变产生爆音
+	captureDriftFade = 5 * time.Millisecond
+	// sendMaxLag 发送落后计划超过该时长时重新对齐时间，而不是突发补发
+	sendMaxLag = 100 * time.Millisecond
+	// comfortNoiseLevel 捕获不足时填充的舒适噪声幅度，约 -60 dBFS
+	comfortNoiseLevel = 32
+)
+
+// OutboundStats 出站音频的统计信息
+type OutboundStats struct {
+	Frames        uint64 `json:"frames"`        // 发送的帧数
+	Underruns     uint64 `json:"underruns"`     // 捕获数据不足、用舒适噪声填充的帧数
+	OverflowBytes uint64 `json:"overflowBytes"` // 捕获缓冲区溢出丢弃的字节数
+	DriftBytes    uint64 `json:"driftBytes"`    // 漂移校正丢弃的字节数
+	Resyncs       uint64 `json:"resyncs"`       // 发送落后过多后重新对齐时间的次数
+	Buffered      int    `json:"buffered"`      // 当前捕获缓冲区的字节数
+}
+
+// captureQueue 有界的捕获缓冲区，由音频输入写入，由发送协程按帧取出
+type captureQueue struct {
+	mu         sync.Mutex
+	data       []byte
+	sampleRate int
+	frameSize  int
+	maxBytes   int
+	highWater  int
+	driftStep  int
+	driftFade  int
+	noiseSeed  uint32
+	stats      OutboundStats
+}
+
+// durationBytes 计算一段时长的 S16LE 单声道数据字节数
+func durationBytes(sampleRate int, d time.Duration) int {
+	return int(int64(sampleRate)*int64(d)/int64(time.Second)) * 2
+}
+
+// newCaptureQueue 按采样率创建捕获缓冲区
+func newCaptureQueue(sampleRate int) *captureQueue {
+	return &captureQueue{
+		data:       make([]byte, 0, durationBytes(sampleRate, captureMaxBuffered)),
+		sampleRate: sampleRate,
+		frameSize:  frameBytes(sampleRate),
+		maxBytes:   durationBytes(sampleRate, captureMaxBuffered),
+		highWater:  durationBytes(sampleRate, captureHighWater),
+		driftStep:  durationBytes(sampleRate, captureDriftStep),
+		driftFade:  durationBytes(sampleRate, captureDriftFade),
+		noiseSeed:  1,
+	}
+}
+
+// Write 追加捕获的音频数据，缓冲区满时丢弃最旧的数据
+func (q *captureQueue) Write(samples []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.data = append(q.data, samples...)
+	if excess := len(q.data) - q.maxBytes; excess > 0 {
+		excess += excess % 2
+		q.data = append(q.data[:0], q.data[excess:]...)
+		q.stats.OverflowBytes += uint64(excess)
+	}
+}
+
+// Next 取出一帧用于发送，数据不足时返回舒适噪声，保持发送节奏和 RTP 时间戳连续
+func (q *captureQueue) Next() []byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stats.Frames++
+	if len(q.data) < q.frameSize {
+		q.stats.Underruns++
+		return q.comfortNoise()
+	}
+	frame := make([]byte, q.frameSize)
+	copy(frame, q.data)
+	q.data = q.data[q.frameSize:]
+
+	// 输入设备时钟比发送快时积压逐渐增加，每帧丢弃少量采样把积压拉回来
+	if len(q.data) > q.highWater {
+		q.dropDrift()
+		q.stats.DriftBytes += uint64(q.driftStep)
+	}
+	return frame
+}
+
+// dropDrift 丢弃 driftStep 字节：接下来的 driftFade 字节从原数据交叉淡化到跳过 driftStep 之后的数据
+// 与上一帧的结尾和后面的数据都保持连续
+func (q *captureQueue) dropDrift() {
+	fade := q.driftFade
+	if fade > len(q.data)-q.driftStep {
+		fade = len(q.data) - q.driftStep
+	}
+	// 从后往前处理，淡化区间与被丢弃的数据重叠时读到的仍是原数据
+	for i := fade - fade%2 - 2; i >= 0; i -= 2 {
+		t := float64(i/2+1) / float64(fade/2+1)
+		from := float64(int16(binary.LittleEndian.Uint16(q.data[i:])))
+		to := float64(int16(binary.LittleEndian.Uint16(q.data[q.driftStep+i:])))
+		binary.LittleEndian.PutUint16(q.data[q.driftStep+i:], uint16(clampSample(from*(1-t)+to*t)))
+	}
+	q.data = q.data[q.driftStep:]
+}
+
+// comfortNoise 生成一帧低电平白噪声，比纯静音更不容易让对方以为断线
+func (q *captureQueue) comfortNoise() []byte {
+	frame := make([]byte, q.frameSize)
+	for i := 0; i+1 < len(frame); i += 2 {
+		q.noiseSeed = q.noiseSeed*1664525 + 1013904223
+		sample := int16(int32(q.noiseSeed>>16)%(2*comfortNoiseLevel+1) - comfortNoiseLevel)
+		binary.LittleEndian.PutUint16(frame[i:], uint16(sample))
+	}
+	return frame
+}
+
+// resync 记录一次发送时间重新对齐
+func (q *captureQueue) resync() {
+	q.mu.Lock()
+	q.stats.Resyncs++
+	q.mu.Unlock()
+}
+
+// Stats 返回统计信息
+func (q *captureQueue) Stats() OutboundStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Buffered = len(q.data)
+	return stats
+}
+
+// sendClock 按已发送的采样数计算下一帧的发送时间，避免 ticker 抖动累积成漂移
+type sendClock struct {
+	sampleRate int
+	start      time.Time
+	samples    int64
+}
+
+// next 返回下一帧的发送时间，落后计划超过 sendMaxLag 时从当前时间重新开始计算
+func (c *sendClock) next(now time.Time, frameSamples int) (time.Time, bool) {
+	resynced := false
+	if c.start.IsZero() {
+		c.start = now
+	}
+	deadline := c.start.Add(time.Duration(c.samples * int64(time.Second) / int64(c.sampleRate)))
+	if now.Sub(deadline) > sendMaxLag {
+		c.start = now
+		c.samples = 0
+		deadline = now
+		resynced = true
+	}
+	c.samples += int64(frameSamples)
+	return deadline, resynced
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// 测试捕获缓冲区溢出时丢弃最旧数据，不足时返回舒适噪声
+func TestCaptureQueue_OverflowAndUnderrun(t *testing.T) {
+	q := newCaptureQueue(8000)
+	frame := make([]byte, q.frameSize)
+	// 写入 300ms，只保留最新的 200ms
+	for i := 0; i < 15; i++ {
+		frame[0] = byte(i)
+		q.Write(frame)
+	}
+	stats := q.Stats()
+	if stats.Buffered != q.maxBytes || stats.OverflowBytes != uint64(5*q.frameSize) {
+		t.Fatalf("unexpected stats after overflow: %+v", stats)
+	}
+	if first := q.Next(); first[0] != 5 {
+		t.Errorf("expected oldest kept frame 5, got %d", first[0])
+	}
+
+	q = newCaptureQueue(8000)
+	noise := q.Next()
+	if len(noise) != q.frameSize || q.Stats().Underruns != 1 {
+		t.Fatalf("expected a comfort noise frame on underrun")
+	}
+	nonZero := false
+	for i := 0; i+1 < len(noise); i += 2 {
+		sample := int16(uint16(noise[i]) | uint16(noise[i+1])<<8)
+		if sample > comfortNoiseLevel || sample < -comfortNoiseLevel {
+			t.Fatalf("comfort noise sample %d out of range", sample)
+		}
+		nonZero = nonZero || sample != 0
+	}
+	if !nonZero {
+		t.Errorf("comfort noise is pure silence")
+	}
+}
+
+// 测试积压超过高水位时逐帧做漂移校正
+func TestCaptureQueue_DriftCorrection(t *testing.T) {
+	q := newCaptureQueue(8000)
+	q.Write(make([]byte, q.frameSize*5))
+	q.Next()
+	stats := q.Stats()
+	if stats.DriftBytes != uint64(q.driftStep) || stats.Buffered != q.frameSize*4-q.driftStep {
+		t.Errorf("unexpected stats after drift correction: %+v", stats)
+	}
+}
+
+// 测试漂移校正丢弃采样处没有波形跳变
+func TestCaptureQueue_DriftCrossfade(t *testing.T) {
+	q := newCaptureQueue(8000)
+	// 100Hz 正弦波相邻采样最多相差约 785
+	q.Write(sinePCM(100, 8000, 8000*150/1000))
+	var output []byte
+	for q.Stats().Buffered >= q.frameSize {
+		output = append(output, q.Next()...)
+	}
+	if q.Stats().DriftBytes == 0 {
+		t.Fatal("expected drift correction")
+	}
+	for i := 2; i+1 < len(output); i += 2 {
+		prev := int16(binary.LittleEndian.Uint16(output[i-2:]))
+		sample := int16(binary.LittleEndian.Uint16(output[i:]))
+		if diff := int(sample) - int(prev); diff > 1000 || diff < -1000 {
+			t.Fatalf("discontinuity of %d at sample %d", diff, i/2)
+		}
+	}
+}
+
+// 测试发送时间按采样数累加，落后过多时重新对齐
+func TestSendClock(t *testing.T) {
+	clock := sendClock{sampleRate: 8000}
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		deadline, resynced := clock.next(start, 160)
+		if resynced || deadline != start.Add(time.Duration(i)*20*time.Millisecond) {
+			t.Fatalf("frame %d deadline %v, resynced %v", i, deadline.Sub(start), resynced)
+		}
+	}
+	late := start.Add(2 * time.Second)
+	deadline, resynced := clock.next(late, 160)
+	if !resynced || deadline != late {
+		t.Errorf("expected resync at %v, got %v", late.Sub(start), deadline.Sub(start))
+	}
+}
@@ -6,28 +6,34 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gen2brain/malgo"
-	"github.com/gorilla/websocket"
+	"github.com/google/uuid"
+	"github.com/pion/interceptor"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
 	"github.com/restsend/rustpbxgo"
-	"github.com/shenjinti/go711"
-	"github.com/shenjinti/go722"
 	"github.com/sirupsen/logrus"
 )
 
 // 定义客户端创建选项结构体
 type CreateClientOption struct {
-	Endpoint string         // 服务器的连接地址
-	Logger   *logrus.Logger // 日志记录器
-	SigChan  chan bool      // 信号通道
-	// LLMHandler     *LLMHandler          // 大语言模型处理器
+	Endpoint    string         // 服务器的连接地址
+	Logger      *logrus.Logger // 日志记录器
+	SigChan     chan bool      // 信号通道
+	CallID      string         // 通话ID，用于关联服务器会话和本地输出文件
+	LLMHandler  *LLMHandler    // 大语言模型处理器，为空时直接复读识别结果
+	OpenaiModel string         // 大语言模型名称
+	FormOutput  string         // 挂断时表单结果的输出目录
+	Guardrail   *Guardrail     // 大模型输出到 TTS 之前的安全检查，可为空
+	CallRecord  *CallRecord    // 本地通话记录
 	// OpenaiKey      string               // OpenAI的API密钥
 	// OpenaiEndpoint string               // OpenAI服务的接口地址
-	// OpenaiModel    string               // 大语言模型名称
 	// SystemPrompt   string               // 系统提示词
 	BreakOnVad bool                 // 是否在语音活动检测（VAD）时中断 TTS 播报
 	CallOption rustpbxgo.CallOption // 通话相关配置选项
@@ -35,22 +41,101 @@ type CreateClientOption struct {
 
 // MediaHandler handles WebRTC and audio encoding
 type MediaHandler struct {
-	ctx            context.Context                // 管理上下文
-	cancel         context.CancelFunc             // 管理取消操作
-	logger         *logrus.Logger                 // 日志记录器
-	peerConnection *webrtc.PeerConnection         // WebRTC对等连接对象
-	audioTrack     *webrtc.TrackLocalStaticSample // 本地音频轨道对象
-	buffer         []byte                         // 存储捕获的音频数据的缓冲区
-	bufferMutex    sync.Mutex                     // 保护buffer的互斥锁
-	connected      bool                           // 表示WebRTC连接是否已建立
-	mu             sync.Mutex                     // 保护其他操作的互斥锁
-	sequenceNumber uint16                         // RTP数据包的序列号
-	timestamp      uint32                         // RTP数据包的时间戳
-	playbackBuffer []byte                         // 存储播放的音频数据的缓冲区
-	playbackMutex  *sync.Mutex                    // 保护playbackBuffer的互斥锁
-	playbackDevice *malgo.Device                  // 音频播放设备对象
-	playbackCtx    *malgo.AllocatedContext        // 音频播放上下文对象
-	captureDevice  *malgo.Device                  // 音频捕获设备对象
+	ctx            context.Context            // 管理上下文
+	cancel         context.CancelFunc         // 管理取消操作
+	logger         *logrus.Logger             // 日志记录器
+	peerConnection *webrtc.PeerConnection     // WebRTC对等连接对象
+	audioTrack     *rtpAudioTrack             // 本地音频轨道对象
+	sendAudio      func(payload []byte) error // websocket 媒体传输时发送编码后的一帧，WebRTC 时为 nil
+	decoder        audioDecoder               // websocket 媒体传输时收到音频的解码器
+	capture        *captureQueue              // 存储捕获的音频数据的有界缓冲区
+	connected      atomic.Bool                // 表示媒体连接是否已建立，音频回调和发送协程中并发读取
+	mu             sync.Mutex                 // 保护其他操作的互斥锁
+	sequenceNumber uint16                     // RTP数据包的序列号
+	timestamp      uint32                     // RTP数据包的时间戳
+	playback       *playbackQueue             // 存储播放的音频数据的有界缓冲区
+	playbackCtx    *malgo.AllocatedContext    // 音频设备上下文对象，使用声卡时才初始化
+	source         AudioSource                // 音频输入：声卡、WAV 文件或静音
+	sink           AudioSink                  // 音频输出：声卡、WAV 文件或丢弃
+	captureRate    int                        // 音频输入的采样率
+	codecs         []*audioCodec              // offer 中按优先级提供的编解码器
+	codec          *audioCodec                // answer 协商确定的编解码器
+	audioSender    *webrtc.RTPSender          // 本地音频轨道的发送器，协商后用于替换轨道
+	jitter         *jitterBuffer              // 入站 RTP 的抖动缓冲
+	recorder       *localRecorder             // 本地录音，未开启时为 nil
+	trickle        *trickleICE                // trickle ICE 的候选队列，未开启时为 nil
+	quality        *qualityMonitor            // 通话质量统计
+	qualityEvery   time.Duration              // 通话质量的统计周期，0 表示不定期统计
+	OnQuality      func(sample QualitySample) // 每个统计周期的通话质量回调
+	OnDTMF         func(digit string)         // 媒体流中收到 RFC 4733 DTMF 按键的回调
+	vadConfig      *VADConfig                 // 本地 VAD 参数，未开启时为 nil
+	echoTail       time.Duration              // 回声消除的回声路径长度，0 表示不开启
+	echo           *echoCanceller             // 回声消除器，未开启时为 nil
+	agcConfig      *AGCConfig                 // 采集音频的自动增益和噪声门参数，未开启时为 nil
+	OnInputLevel   func(level InputLevel)     // 每 100 毫秒回调一次麦克风输入电平，在发送协程中调用，不能阻塞
+	injections     []*Injection               // 注入到出站音频的本地音频
+	injectMutex    sync.Mutex                 // 保护 injections
+	bridge         *bridgePort                // 与另一路通话的桥接，未桥接时为 nil
+	bridgeMutex    sync.Mutex                 // 保护 bridge
+	supervisor     *Supervisor                // 旁听、耳语或插话的主管，没有时为 nil
+	supervisorMu   sync.Mutex                 // 保护 supervisor
+	mediaCodec     atomic.Pointer[audioCodec] // 媒体开始收发后使用的编解码器，供 HTTP 等其他协程读取
+	mediaStarted   chan struct{}              // 媒体开始收发时关闭
+	OnLocalSpeech  func(speaking bool)        // 本地 VAD 检测到麦克风开始或停止说话的回调，在发送协程中调用，不能阻塞
+	dtmfMutex      sync.Mutex                 // 保证同一时间只发送一个按键序列
+}
+
+// MediaOption 媒体处理器的可选配置
+type MediaOption func(*MediaHandler)
+
+// WithAudioSource 指定音频输入，默认使用声卡采集设备
+func WithAudioSource(source AudioSource) MediaOption {
+	return func(mh *MediaHandler) {
+		mh.source = source
+	}
+}
+
+// WithAudioSink 指定音频输出，默认使用声卡播放设备
+func WithAudioSink(sink AudioSink) MediaOption {
+	return func(mh *MediaHandler) {
+		mh.sink = sink
+	}
+}
+
+// WithRecorder 在本地录制通话
+func WithRecorder(recorder *localRecorder) MediaOption {
+	return func(mh *MediaHandler) {
+		mh.recorder = recorder
+	}
+}
+
+// WithQualityInterval 每隔 interval 统计一次通话质量，记录日志并回调 OnQuality
+func WithQualityInterval(interval time.Duration) MediaOption {
+	return func(mh *MediaHandler) {
+		mh.qualityEvery = interval
+	}
+}
+
+// WithLocalVAD 在采集的麦克风数据上运行本地 VAD，说话状态变化时回调 OnLocalSpeech
+// 不需要等服务器的 speaking/asrDelta 事件往返，可以立即打断本地播放
+func WithLocalVAD(config VADConfig) MediaOption {
+	return func(mh *MediaHandler) {
+		mh.vadConfig = &config
+	}
+}
+
+// WithEchoCanceller 开启回声消除，以播放的音频为参考消除麦克风采集到的扬声器声音，tail 为回声路径长度
+func WithEchoCanceller(tail time.Duration) MediaOption {
+	return func(mh *MediaHandler) {
+		mh.echoTail = tail
+	}
+}
+
+// WithAGC 在编码前对采集的音频做噪声门和自动增益控制
+func WithAGC(config AGCConfig) MediaOption {
+	return func(mh *MediaHandler) {
+		mh.agcConfig = &config
+	}
 }
 
 // 创建客户端
@@ -76,13 +161,18 @@ func createClient(ctx context.Context, option CreateClientOption, id string, cal
 	client.OnError = func(event rustpbxgo.ErrorEvent) {
 		option.Logger.Errorf("Error: %v", event)
 	}
+	// 通话挂断：输出通话结果
+	client.OnHangup = func(event rustpbxgo.HangupEvent) {
+		option.Logger.Infof("Hangup: %s initiator: %s", event.Reason, event.Initiator)
+		handleHangup(option, event)
+	}
 	// 收到DTMF（按键音）：记录按键信息
 	client.OnDTMF = func(event rustpbxgo.DTMFEvent) {
 		option.Logger.Infof("DTMF: %s", event.Digit)
 	}
 	// 收到语音识别最终结果
 	client.OnAsrFinal = func(event rustpbxgo.AsrFinalEvent) {
-		handleAsrFinal(client, option.Logger, event, callOption)
+		handleAsrFinal(client, option, event, callOption)
 	}
 	// 收到语音识别中间结果：根据配置决定是否打断TTS
 	client.OnAsrDelta = func(event rustpbxgo.AsrDeltaEvent) {
@@ -104,7 +194,8 @@ func createClient(ctx context.Context, option CreateClientOption, id string, cal
 }
 
 // 处理语音识别最终结果
-func handleAsrFinal(client *rustpbxgo.Client, logger *logrus.Logger, event rustpbxgo.AsrFinalEvent, callOption rustpbxgo.CallOption) {
+func handleAsrFinal(client *rustpbxgo.Client, option CreateClientOption, event rustpbxgo.AsrFinalEvent, callOption rustpbxgo.CallOption) {
+	logger := option.Logger
 	// 保存对话历史
 	if event.Text != "" {
 		client.History("user", event.Text)
@@ -122,27 +213,123 @@ func handleAsrFinal(client *rustpbxgo.Client, logger *logrus.Logger, event rustp
 	// 显示用户讲话内容
 	logger.Infof("User said: %s", event.Text)
 
-	// 调用TTS讲出内容
-	sendTTS(client, logger, event.Text, callOption.TTS.Speaker)
+	// 未配置大模型时，调用TTS讲出内容
+	if option.LLMHandler == nil {
+		sendTTS(client, logger, event.Text, callOption.TTS.Speaker)
+		return
+	}
+	// 交给大模型处理，避免阻塞事件循环
+	go queryLLM(client, option, event.Text, callOption)
 }
 
-// 发送 TTS 命令
-func sendTTS(client *rustpbxgo.Client, logger *logrus.Logger, text string, speaker string) {
-	// 调用 TTS 讲出内容
-		ttsCmd := rustpbxgo.TtsCommand{
-			Command: "tts",
-			Text:    text,
-			Speaker: speaker,
+// 处理挂断：记录挂断原因并触发通话记录的挂断钩子，通话结果在连接关闭后由 finishCall 输出
+func handleHangup(option CreateClientOption, event rustpbxgo.HangupEvent) {
+	if option.CallRecord != nil {
+		option.CallRecord.SetHangup(event)
+	}
+}
+
+// 通话结束：输出表单和通话记录，通话小结在挂断时已开始生成
+func finishCall(config Config, option CreateClientOption) {
+	record := option.CallRecord
+	if record == nil {
+		return
+	}
+	// 等待挂断事件处理完成，连接异常断开时直接输出
+	record.WaitHangup(time.Second)
+
+	llm := option.LLMHandler
+	if llm != nil {
+		record.Transcript = llm.Transcript()
+		if form := llm.Form(); form != nil {
+			result := form.Result()
+			record.Form = &result
+			path, err := WriteFormResult(option.FormOutput, option.CallID, result)
+			if err != nil {
+				option.Logger.Errorf("Failed to write form: %v", err)
+			} else {
+				option.Logger.WithFields(logrus.Fields{
+					"complete": result.Complete,
+					"missing":  result.Missing,
+					"path":     path,
+				}).Info("Form written")
+			}
 		}
-		ttsData, err := json.Marshal(ttsCmd)
-		if err != nil {
-			logger.Errorf("Failed to marshal TTS command: %v", err)
-			return
+	}
+
+	path, err := record.Write(config.CallRecordDir)
+	if err != nil {
+		option.Logger.Errorf("Failed to write call record: %v", err)
+		return
+	}
+	option.Logger.Infof("Call record written: %s", path)
+}
+
+// 挂断时生成通话小结，写入 <dir>/<callID>.summary.json
+func summarizeCall(llm *LLMHandler, model string, dir string, record *CallRecord, logger *logrus.Logger) {
+	if len(llm.Transcript()) == 0 {
+		return
+	}
+	summary, err := llm.Summarize(model, record.CallID)
+	if err != nil {
+		logger.Errorf("Failed to generate call summary: %v", err)
+		return
+	}
+	path, err := writeJSONFile(dir, record.CallID+".summary.json", summary)
+	if err != nil {
+		logger.Errorf("Failed to write call summary: %v", err)
+		return
+	}
+	record.SetSummaryFile(filepath.Base(path))
+	logger.WithFields(logrus.Fields{
+		"disposition": summary.Disposition,
+		"sentiment":   summary.Sentiment,
+		"path":        path,
+	}).Info("Call summary written")
+}
+
+// 调用大模型并把回复按句子流式发送给 TTS，音色和情感跟随当前阶段
+func queryLLM(client *rustpbxgo.Client, option CreateClientOption, text string, callOption rustpbxgo.CallOption) {
+	llm := option.LLMHandler
+	ttsCallback := func(segment string, playID string, autoHangup bool) error {
+		if segment == "" && !autoHangup {
+			return nil
 		}
-		err = client.GetConn().WriteMessage(websocket.TextMessage, ttsData)
-		if err != nil {
-			logger.Errorf("Failed to send TTS command: %v", err)
+		ttsOption := stageTTSOption(callOption.TTS, llm.CurrentStage())
+		return client.TTS(segment, ttsOption.Speaker, playID, autoHangup, ttsOption)
+	}
+	if option.Guardrail != nil {
+		ttsCallback = option.Guardrail.Wrap(ttsCallback)
+	}
+	_, err := llm.QueryStream(option.OpenaiModel, text, ttsCallback)
+	if err != nil {
+		option.Logger.Errorf("Failed to query LLM: %v", err)
+	}
+}
+
+// 根据当前阶段覆盖 TTS 的音色和情感
+func stageTTSOption(base *rustpbxgo.TTSOption, stage *Stage) *rustpbxgo.TTSOption {
+	ttsOption := rustpbxgo.TTSOption{}
+	if base != nil {
+		ttsOption = *base
+	}
+	if stage != nil {
+		if stage.Speaker != "" {
+			ttsOption.Speaker = stage.Speaker
 		}
+		if stage.Emotion != "" {
+			ttsOption.Emotion = stage.Emotion
+		}
+	}
+	return &ttsOption
+}
+
+// 发送 TTS 命令，经由客户端发送，与其他命令和 websocket 媒体共用写锁
+func sendTTS(client *rustpbxgo.Client, logger *logrus.Logger, text string, speaker string) {
+	// 调用 TTS 讲出内容
+	if err := client.TTS(text, speaker, "", false, nil); err != nil {
+		logger.Errorf("Failed to send TTS command: %v", err)
+	}
 }
 
 // 处理语音识别中间结果
@@ -159,82 +346,195 @@ func handleAsrDelta(client *rustpbxgo.Client, logger *logrus.Logger, event rustp
 }
 
 // NewMediaHandler creates a new media handler
-func NewMediaHandler(ctx context.Context, logger *logrus.Logger) (*MediaHandler, error) {
+func NewMediaHandler(ctx context.Context, logger *logrus.Logger, opts ...MediaOption) (*MediaHandler, error) {
 	// 创建一个可取消的上下文
 	ctx, cancel := context.WithCancel(ctx)
 
-	// 初始化音频播放上下文
-	playbackCtx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to initialize playback context: %w", err)
-	}
-
-	// 返回一个新的MediaHandler实例
-	return &MediaHandler{
+	// 创建一个新的MediaHandler实例
+	mh := &MediaHandler{
 		ctx:            ctx,
 		cancel:         cancel,
 		logger:         logger,
-		buffer:         make([]byte, 0, 16000), // Buffer for 1 second of audio at 16kHz
 		sequenceNumber: 0,
 		timestamp:      0,
-		playbackCtx:    playbackCtx,
-	}, nil
+		quality:        &qualityMonitor{},
+		mediaStarted:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(mh)
+	}
+	// 未指定时使用声卡
+	if mh.source == nil {
+		mh.source = &deviceSource{config: audioDeviceConfig{contextFn: mh.audioContext}}
+	}
+	if mh.sink == nil {
+		mh.sink = &deviceSink{config: audioDeviceConfig{contextFn: mh.audioContext}}
+	}
+	return mh, nil
+}
+
+// audioContext 第一次使用声卡时初始化音频设备上下文，无声卡运行时不会调用
+func (mh *MediaHandler) audioContext() (*malgo.AllocatedContext, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	if mh.playbackCtx != nil {
+		return mh.playbackCtx, nil
+	}
+	playbackCtx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize playback context: %w", err)
+	}
+	mh.playbackCtx = playbackCtx
+	return playbackCtx, nil
 }
 
 // Setup 函数用于设置 WebRTC 连接，创建一个 offer 并设置为本地描述，等待 ICE 收集完成，最后返回 offer 的 SDP 信息
+// codec 为按优先级排列的编解码器列表，例如 "opus,g722,pcmu"，实际使用的编解码器由 answer 决定
 func (mh *MediaHandler) Setup(codec string, iceServers []webrtc.ICEServer) (string, error) {
+	codecs, err := parseCodecList(codec)
+	if err != nil {
+		return "", err
+	}
+	if err := mh.setupPeerConnection(codecs, iceServers); err != nil {
+		return "", err
+	}
+	peerConnection := mh.peerConnection
+
+	// 创建一个 offer 并设置为本地描述
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create offer: %w", err)
+	}
+	peerConnection.SetLocalDescription(offer)
+
+	// trickle ICE 模式下直接返回不含候选的 offer，候选地址收集到后再发送
+	if mh.trickle != nil {
+		return peerConnection.LocalDescription().SDP, nil
+	}
+	if err := mh.waitGathering(); err != nil {
+		return "", err
+	}
+
+	// 返回 offer 的 SDP 信息
+	offerSdp := peerConnection.LocalDescription().SDP
+	return offerSdp, nil
+}
+
+// AnswerOffer 作为应答方处理对方的 offer，返回 answer 的 SDP 信息，用于本地回环测试等不经过服务器的场景
+// codec 为本端支持的编解码器列表，实际使用 offer 中对方优先级最高且本端支持的编解码器
+func (mh *MediaHandler) AnswerOffer(offer string, codec string, iceServers []webrtc.ICEServer) (string, error) {
+	codecs, err := parseCodecList(codec)
+	if err != nil {
+		return "", err
+	}
+	if err := mh.setupPeerConnection(codecs, iceServers); err != nil {
+		return "", err
+	}
+	peerConnection := mh.peerConnection
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", fmt.Errorf("failed to set remote offer: %w", err)
+	}
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create answer: %w", err)
+	}
+	// 本地描述生效前替换音频轨道，绑定时才能使用协商的编解码器
+	negotiated, err := negotiatedCodec(answer.SDP, codecs)
+	if err != nil {
+		return "", err
+	}
+	if err := mh.useCodec(negotiated); err != nil {
+		return "", err
+	}
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		return "", fmt.Errorf("failed to set local answer: %w", err)
+	}
+	if err := mh.waitGathering(); err != nil {
+		return "", err
+	}
+	return peerConnection.LocalDescription().SDP, nil
+}
+
+// setupPeerConnection 创建对等连接和本地音频轨道，并注册远程轨道和连接状态的处理函数
+func (mh *MediaHandler) setupPeerConnection(codecs []*audioCodec, iceServers []webrtc.ICEServer) error {
+	mh.codecs = codecs
+	mh.codec = codecs[0]
+
+	// 按优先级注册音频编解码器到 mediaEngine
 	mediaEngine := webrtc.MediaEngine{}
-	var codecParams webrtc.RTPCodecParameters
-	// 根据传入的 codec 参数选择合适的音频编解码器
-	if codec == "g722" {
-		codecParams = webrtc.RTPCodecParameters{
-			RTPCodecCapability: webrtc.RTPCodecCapability{
-				MimeType:  webrtc.MimeTypeG722,
-				ClockRate: 8000,
-			},
-			PayloadType: 9,
+	for _, c := range codecs {
+		if err := mediaEngine.RegisterCodec(c.parameters(), webrtc.RTPCodecTypeAudio); err != nil {
+			return fmt.Errorf("failed to register codec %s: %w", c.Name, err)
 		}
-	} else {
-		codecParams = webrtc.RTPCodecParameters{
-			RTPCodecCapability: webrtc.RTPCodecCapability{
-				MimeType:  webrtc.MimeTypePCMU,
-				ClockRate: 8000,
-			},
-			PayloadType: 0,
+	}
+	// 注册 RFC 4733 telephone-event，用于在媒体流中收发 DTMF
+	for _, event := range telephoneEventCodecs(codecs) {
+		if err := mediaEngine.RegisterCodec(event, webrtc.RTPCodecTypeAudio); err != nil {
+			return fmt.Errorf("failed to register telephone-event: %w", err)
 		}
 	}
 
-	// 注册音频编解码器到 mediaEngine
-	mediaEngine.RegisterCodec(codecParams, webrtc.RTPCodecTypeAudio)
+	// 注册 RTCP 报告和统计拦截器，用于通话质量统计
+	registry := &interceptor.Registry{}
+	if err := registerQualityInterceptors(registry, mh.quality); err != nil {
+		return fmt.Errorf("failed to register interceptors: %w", err)
+	}
 
 	// 创建一个新的 WebRTC 对等连接
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(&mediaEngine))
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(&mediaEngine), webrtc.WithInterceptorRegistry(registry))
 	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{
 		ICEServers: iceServers,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create peer connection: %w", err)
+		return fmt.Errorf("failed to create peer connection: %w", err)
 	}
 	mh.peerConnection = peerConnection
 
-	// 创建一个本地音频轨道并添加到对等连接中
-	audioTrack, err := webrtc.NewTrackLocalStaticSample(codecParams.RTPCodecCapability, "rustpbxgo-audio", "rustpbxgo-audio")
-	if err != nil {
-		return "", fmt.Errorf("failed to create audio track: %w", err)
-	}
+	// 创建一个本地音频轨道并添加到对等连接中，先使用优先级最高的编解码器
+	audioTrack := newRTPAudioTrack(mh.codec.parameters().RTPCodecCapability)
 	// Add track to peer connection
-	_, err = peerConnection.AddTrack(audioTrack)
+	audioSender, err := peerConnection.AddTrack(audioTrack)
 	if err != nil {
-		return "", fmt.Errorf("failed to add track to peer connection: %w", err)
+		return fmt.Errorf("failed to add track to peer connection: %w", err)
 	}
 	mh.audioTrack = audioTrack
+	mh.audioSender = audioSender
+	// 读取对方发来的 RTCP，拦截器据此统计丢包和往返时延
+	go func() {
+		for {
+			if _, _, err := audioSender.ReadRTCP(); err != nil {
+				return
+			}
+		}
+	}()
 	// 处理远程音频轨道的添加事件，解码接收到的 RTP 数据包并添加到播放缓冲区
 	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		mh.logger.Infof("Track remote added %v %s", track.ID(), track.Codec().MimeType)
-		g722Decoder := go722.NewG722Decoder(go722.Rate64000, 0)
+		mh.quality.setInbound(uint32(track.SSRC()), int(track.Codec().ClockRate))
+		// 根据远程轨道实际的负载类型创建解码器
+		trackCodec := codecByMimeType(track.Codec().MimeType)
+		if trackCodec == nil && strings.EqualFold(track.Codec().MimeType, mimeTypeTelephoneEvent) {
+			// 第一个包是 DTMF 事件时使用协商的音频编解码器
+			trackCodec = mh.codec
+		}
+		if trackCodec == nil {
+			mh.logger.Errorf("Unsupported remote codec: %s", track.Codec().MimeType)
+			return
+		}
+		decoder, err := trackCodec.newDecoder()
+		if err != nil {
+			mh.logger.Errorf("Failed to create %s decoder: %v", trackCodec.Name, err)
+			return
+		}
+		// 收到的包先放入抖动缓冲，由播放协程按固定节奏取出解码
+		jitter := newJitterBuffer(int(track.Codec().ClockRate))
+		mh.jitter = jitter
+		// telephone-event 包在收到时立即识别按键，仍放入抖动缓冲以保持序列号连续
+		detector := newDTMFDetector(receiver.GetParameters().Codecs)
+		done := make(chan struct{})
 		go func() {
-			for mh.connected {
+			defer close(done)
+			for mh.connected.Load() {
 				if mh.ctx.Err() != nil {
 					return
 				}
@@ -243,28 +543,28 @@ func (mh *MediaHandler) Setup(codec string, iceServers []webrtc.ICEServer) (stri
 					mh.logger.Errorf("Failed to read RTP packet: %v", err)
 					break
 				}
-				var audioData []byte
-				if codec == "g722" {
-					audioData = g722Decoder.Decode(rtpPacket.Payload)
-				} else {
-					audioData, _ = go711.DecodePCMU(rtpPacket.Payload)
+				if detector.isEvent(rtpPacket) {
+					if digit, ok := detector.detect(rtpPacket); ok {
+						mh.logger.Infof("Received in-band DTMF digit %s", digit)
+						if mh.OnDTMF != nil {
+							mh.OnDTMF(digit)
+						}
+					}
 				}
-				// Add to playback buffer
-				mh.playbackMutex.Lock()
-				mh.playbackBuffer = append(mh.playbackBuffer, audioData...)
-				mh.playbackMutex.Unlock()
+				jitter.Push(rtpPacket, time.Now())
 			}
 		}()
+		go mh.playoutLoop(jitter, decoder, detector, done)
 	})
 
 	// 处理对等连接状态变化、ICE 收集状态变化、ICE 候选和 ICE 连接状态变化事件
 	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		mh.logger.Infof("Peer connection state: %v", state)
 		if state == webrtc.PeerConnectionStateConnected {
-			mh.connected = true
-			mh.initPlaybackDevice(codec)
-			mh.startAudioCapture(codec)
-			go mh.encodeAndSendAudio(codec)
+			mh.startMedia()
+			if mh.qualityEvery > 0 {
+				go mh.qualityLoop(mh.qualityEvery)
+			}
 		}
 	})
 	peerConnection.OnICEGatheringStateChange(func(state webrtc.ICEGathererState) {
@@ -272,149 +572,338 @@ func (mh *MediaHandler) Setup(codec string, iceServers []webrtc.ICEServer) (stri
 	})
 	peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
 		mh.logger.Infof("ICE candidate: %v", candidate)
+		mh.onLocalCandidate(candidate)
 	})
 	peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
 		mh.logger.Infof("ICE connection state: %v", state)
 	})
 
-	// 创建一个 offer 并设置为本地描述
-	offer, err := peerConnection.CreateOffer(nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create offer: %w", err)
+	return nil
+}
+
+// startMedia 媒体连接建立后启动本地录音、播放、采集和发送
+func (mh *MediaHandler) startMedia() {
+	mh.connected.Store(true)
+	if mh.recorder != nil {
+		if err := mh.recorder.Start(mh.codec.SampleRate); err != nil {
+			mh.logger.Errorf("Failed to start local recording: %v", err)
+		}
 	}
-	peerConnection.SetLocalDescription(offer)
+	if mh.echoTail > 0 {
+		mh.echo = newEchoCanceller(mh.codec.SampleRate, mh.echoTail)
+	}
+	if err := mh.initPlaybackDevice(mh.codec); err != nil {
+		mh.logger.Errorf("Failed to start playback: %v", err)
+	}
+	if err := mh.startAudioCapture(mh.codec); err != nil {
+		mh.logger.Errorf("Failed to start capture: %v", err)
+	}
+	go mh.encodeAndSendAudio(mh.codec)
+	if mh.mediaCodec.Swap(mh.codec) == nil && mh.mediaStarted != nil {
+		close(mh.mediaStarted)
+	}
+}
 
-	// 等待 ICE 收集完成或超时
+// waitGathering 等待 ICE 收集完成或超时
+func (mh *MediaHandler) waitGathering() error {
 	select {
-	case <-webrtc.GatheringCompletePromise(peerConnection):
+	case <-webrtc.GatheringCompletePromise(mh.peerConnection):
 		mh.logger.Info("ICE Gathering complete")
 	case <-time.After(20 * time.Second):
 		mh.logger.Warn("ICE Gathering timeout")
-		return "", fmt.Errorf("gathering timeout")
+		return fmt.Errorf("gathering timeout")
 	}
+	return nil
+}
 
-	// 返回 offer 的 SDP 信息
-	offerSdp := peerConnection.LocalDescription().SDP
-	return offerSdp, nil
+// playoutLoop 每 20 毫秒从抖动缓冲取出一个包解码后放入播放缓冲区，丢失的包用带内 FEC 恢复或做丢包补偿
+func (mh *MediaHandler) playoutLoop(jitter *jitterBuffer, decoder audioDecoder, detector *dtmfDetector, done <-chan struct{}) {
+	ticker := time.NewTicker(audioFrameDuration)
+	defer ticker.Stop()
+	var concealer lossConcealer
+	for {
+		select {
+		case <-mh.ctx.Done():
+			return
+		case <-done:
+			stats := jitter.Stats()
+			mh.logger.Infof("Jitter buffer stats: received %d, lost %d, late %d, reordered %d, duplicate %d, overflow %d, underruns %d, jitter %.1fms",
+				stats.Received, stats.Lost, stats.Late, stats.Reordered, stats.Duplicate, stats.Overflow, stats.Underruns, stats.JitterMs)
+			return
+		case <-ticker.C:
+		}
+
+		packet, result := jitter.Pop()
+		var audioData []byte
+		switch result {
+		case jitterWait:
+			continue
+		case jitterLost:
+			audioData = concealLoss(jitter, decoder, detector, &concealer)
+		case jitterPacket:
+			// DTMF 事件期间对方不发送音频
+			if detector.isEvent(packet) {
+				continue
+			}
+			var err error
+			audioData, err = decoder.Decode(packet.Payload)
+			if err != nil {
+				mh.logger.Warnf("Failed to decode RTP packet: %v", err)
+				audioData = concealer.conceal()
+			} else {
+				concealer.good(audioData)
+			}
+		}
+		if len(audioData) == 0 {
+			continue
+		}
+		mh.deliverInbound(audioData)
+	}
+}
+
+// deliverInbound 处理解码后收到的一帧音频：本地录音、主管旁听、桥接转发，最后放入播放缓冲区
+func (mh *MediaHandler) deliverInbound(audioData []byte) {
+	if mh.recorder != nil {
+		mh.recorder.Inbound(audioData)
+	}
+	if sup := mh.currentSupervisor(); sup != nil {
+		sup.heardRemote(audioData)
+	}
+	// 桥接时转给另一路通话，不旁听时不在本地播放
+	if port := mh.bridgePort(); port != nil {
+		port.forward(audioData)
+		if !port.options.Listen {
+			return
+		}
+	}
+	if mh.playback == nil {
+		return
+	}
+	// Add to playback buffer
+	mh.playback.Write(audioData)
+}
+
+// OutboundStats 返回出站音频的统计信息，还没有开始采集时返回零值
+func (mh *MediaHandler) OutboundStats() OutboundStats {
+	if mh.capture == nil {
+		return OutboundStats{}
+	}
+	return mh.capture.Stats()
+}
+
+// JitterStats 返回入站抖动缓冲的统计信息，还没有收到远程音频时返回零值
+func (mh *MediaHandler) JitterStats() JitterStats {
+	if mh.jitter == nil {
+		return JitterStats{}
+	}
+	return mh.jitter.Stats()
 }
 
 // SetupAnswer 函数用于设置远程描述，接收一个 SDP 答案并将其设置为对等连接的远程描述
+// 编解码器由 answer 中的负载类型决定，与 offer 中优先级最高的不同时替换本地音频轨道
 func (mh *MediaHandler) SetupAnswer(answer string) error {
+	codec, err := negotiatedCodec(answer, mh.codecs)
+	if err != nil {
+		return err
+	}
+	if err := mh.useCodec(codec); err != nil {
+		return err
+	}
+
 	remoteOffer := webrtc.SessionDescription{
 		Type: webrtc.SDPTypeAnswer,
 		SDP:  answer,
 	}
-	return mh.peerConnection.SetRemoteDescription(remoteOffer)
+	if err := mh.peerConnection.SetRemoteDescription(remoteOffer); err != nil {
+		return err
+	}
+	mh.flushRemoteCandidates()
+	return nil
+}
+
+// useCodec 切换到协商确定的编解码器，与当前不同时替换本地音频轨道
+func (mh *MediaHandler) useCodec(codec *audioCodec) error {
+	if codec != mh.codec {
+		audioTrack := newRTPAudioTrack(codec.parameters().RTPCodecCapability)
+		if err := mh.audioSender.ReplaceTrack(audioTrack); err != nil {
+			return fmt.Errorf("failed to replace audio track: %w", err)
+		}
+		mh.audioTrack = audioTrack
+		mh.codec = codec
+	}
+	mh.logger.Infof("Negotiated codec: %s", codec.Name)
+	return nil
 }
 
 // initPlaybackDevice 函数用于初始化音频播放设备
-func (mh *MediaHandler) initPlaybackDevice(codec string) error {
-	// 根据 codec 参数设置设备配置
-	deviceConfig := malgo.DefaultDeviceConfig(malgo.Playback)
-	deviceConfig.Playback.Format = malgo.FormatS16
-	deviceConfig.Playback.Channels = 1
-	deviceConfig.SampleRate = 8000
-	deviceConfig.Alsa.NoMMap = 1
-	if codec == "g722" {
-		deviceConfig.SampleRate = 16000
-	}
-
-	// 创建一个播放缓冲区和互斥锁
-	mh.playbackBuffer = make([]byte, 0, 16000)
-	mh.playbackMutex = &sync.Mutex{}
-
-	// 使用 malgo.InitDevice 
-	// 处理设备的数据回调函数，将播放缓冲区的数据复制到输出样本中v
-	playbackDevice, err := malgo.InitDevice(mh.playbackCtx.Context, deviceConfig, malgo.DeviceCallbacks{
-		Data: func(outputSamples, inputSamples []byte, frameCount uint32) {
-			if !mh.connected {
-				return
-			}
-			mh.playbackMutex.Lock()
-			n := copy(outputSamples, mh.playbackBuffer)
-			mh.playbackBuffer = mh.playbackBuffer[n:]
-			mh.playbackMutex.Unlock()
-		},
+func (mh *MediaHandler) initPlaybackDevice(codec *audioCodec) error {
+	// 根据编解码器设置采样率
+	sampleRate := codec.SampleRate
+
+	// 创建一个播放缓冲区
+	playback := newPlaybackQueue(sampleRate)
+	mh.playback = playback
+
+	// 启动音频输出
+	// 处理输出的数据回调函数，将播放缓冲区的数据复制到输出样本中，数据不足时补零
+	err := mh.sink.Start(sampleRate, func(outputSamples []byte) {
+		if !mh.connected.Load() {
+			clear(outputSamples)
+			return
+		}
+		playback.Read(outputSamples)
+		// 混入主管耳语或插话的声音
+		if sup := mh.currentSupervisor(); sup != nil {
+			sup.mixPlayback(outputSamples)
+		}
+		// 播放的数据作为回声消除的参考信号
+		if mh.echo != nil {
+			mh.echo.Far(outputSamples)
+		}
 	})
 	if err != nil {
-		return fmt.Errorf("failed to initialize playback device: %w", err)
+		return err
 	}
-	mh.playbackDevice = playbackDevice
 	mh.logger.Info("Playback device initialized")
+	return nil
+}
+
+// ClearPlayback 丢弃还没有播放的音频，用于本地打断
+func (mh *MediaHandler) ClearPlayback() {
+	if mh.playback == nil {
+		return
+	}
+	mh.playback.Clear()
+}
 
-	// 启动播放设备
-	return playbackDevice.Start()
+// PlaybackStats 返回播放缓冲区的统计信息，还没有开始播放时返回零值
+func (mh *MediaHandler) PlaybackStats() PlaybackStats {
+	if mh.playback == nil {
+		return PlaybackStats{}
+	}
+	return mh.playback.Stats()
 }
 
 // startAudioCapture 函数用于初始化音频捕获设备
-func (mh *MediaHandler) startAudioCapture(codec string) error {
-	// 根据 codec 参数设置设备配置
-	deviceConfig := malgo.DefaultDeviceConfig(malgo.Capture)
-	deviceConfig.Capture.Format = malgo.FormatS16
-	deviceConfig.Capture.Channels = 1
-	deviceConfig.SampleRate = 8000
-	deviceConfig.Alsa.NoMMap = 1
-	if codec == "g722" {
-		deviceConfig.SampleRate = 16000
-	}
-
-	// 使用 malgo.InitDevice 初始化捕获设备
-	// 处理设备的数据回调函数，将输入样本添加到捕获缓冲区
-	captureDevice, err := malgo.InitDevice(mh.playbackCtx.Context, deviceConfig, malgo.DeviceCallbacks{
-		Data: func(outputSamples, inputSamples []byte, frameCount uint32) {
-			if !mh.connected {
-				return
-			}
-			mh.bufferMutex.Lock()
-			mh.buffer = append(mh.buffer, inputSamples...)
-			mh.bufferMutex.Unlock()
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to initialize capture device: %v", err)
-	}
-	mh.captureDevice = captureDevice
+func (mh *MediaHandler) startAudioCapture(codec *audioCodec) error {
+	// 根据编解码器设置采样率
+	sampleRate := codec.SampleRate
+	mh.captureRate = sampleRate
+	capture := newCaptureQueue(sampleRate)
+	mh.capture = capture
 
-	// 启动捕获设备
-	err = captureDevice.Start()
+	// 启动音频输入
+	// 处理输入的数据回调函数，将输入样本添加到捕获缓冲区
+	err := mh.source.Start(sampleRate, func(inputSamples []byte) {
+		if !mh.connected.Load() {
+			return
+		}
+		// 在采集时做回声消除，使麦克风数据与参考信号按声卡时间对齐
+		if mh.echo != nil {
+			inputSamples = mh.echo.Process(inputSamples)
+		}
+		capture.Write(inputSamples)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to start capture device: %v", err)
+		return err
 	}
 	mh.logger.Info("Capture device initialized")
 	return nil
 }
 
 // encodeAndSendAudio 函数用于编码音频数据并通过 WebRTC 发送
-func (mh *MediaHandler) encodeAndSendAudio(codec string) {
-	// 使用 time.NewTicker 创建一个定时器，每 20 毫秒触发一次
-	ticker := time.NewTicker(20 * time.Millisecond)
-	// 根据采样率计算 20 毫秒的音频数据帧大小
-	framesize := int(20 * int(mh.captureDevice.SampleRate()) / 1000 * 2)
-	// 创建一个 G.722 编码器
-	g722Encoder := go722.NewG722Encoder(go722.Rate64000, 0)
-	// 循环检查是否连接，处理定时器事件或上下文取消事件
-	for mh.connected {
+func (mh *MediaHandler) encodeAndSendAudio(codec *audioCodec) {
+	capture := mh.capture
+	if capture == nil {
+		capture = newCaptureQueue(codec.SampleRate)
+	}
+	// 按已发送的采样数计算每一帧的发送时间
+	clock := sendClock{sampleRate: codec.SampleRate}
+	frameSamples := frameBytes(codec.SampleRate) / 2
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	// 创建协商确定的编码器
+	encoder, err := codec.newEncoder()
+	if err != nil {
+		mh.logger.Errorf("Failed to create %s encoder: %v", codec.Name, err)
+		return
+	}
+	var vad *energyVAD
+	if mh.vadConfig != nil {
+		vad = newEnergyVAD(*mh.vadConfig, codec.SampleRate)
+	}
+	var agc *gainControl
+	if mh.agcConfig != nil {
+		agc = newGainControl(*mh.agcConfig, codec.SampleRate)
+	}
+	meter := newLevelMeter(codec.SampleRate)
+	defer func() {
+		stats := capture.Stats()
+		mh.logger.Infof("Outbound audio stats: frames %d, underruns %d, overflow %d bytes, drift %d bytes, resyncs %d",
+			stats.Frames, stats.Underruns, stats.OverflowBytes, stats.DriftBytes, stats.Resyncs)
+	}()
+	// 循环检查是否连接，等到下一帧的发送时间或上下文取消
+	for mh.connected.Load() {
+		deadline, resynced := clock.next(time.Now(), frameSamples)
+		if resynced {
+			capture.resync()
+		}
+		timer.Reset(time.Until(deadline))
 		select {
-		case <-ticker.C:
+		case <-timer.C:
 		case <-mh.ctx.Done():
 			return
 		}
-		mh.bufferMutex.Lock()
-		if len(mh.buffer) < framesize { // 20ms at 8khz
-			mh.bufferMutex.Unlock()
+
+		// 从捕获缓冲区中取出一帧，不足时为舒适噪声
+		audioData := capture.Next()
+		// 本地 VAD 使用调整增益前的麦克风电平
+		if vad != nil {
+			if changed, speaking := vad.Process(audioData); changed && mh.OnLocalSpeech != nil {
+				mh.OnLocalSpeech(speaking)
+			}
+		}
+		// 输入电平表，同时报告当前的增益和噪声门状态
+		if mh.OnInputLevel != nil {
+			if level, ok := meter.add(audioData); ok {
+				if agc != nil {
+					level.GainDB, level.Gated = agc.GainDB(), agc.Gated()
+				}
+				mh.OnInputLevel(level)
+			}
+		}
+		// 噪声门和自动增益
+		if agc != nil {
+			level, _ := frameLevel(audioData)
+			audioData = agc.Process(audioData, level)
+		}
+		// 桥接时发送另一路通话收到的音频
+		if port := mh.bridgePort(); port != nil {
+			audioData = port.outbound(audioData)
+		}
+		// 混入注入的本地音频
+		audioData = mh.mixInjections(audioData)
+		// 主管旁听坐席发送的音频，插话时混入主管的声音
+		if sup := mh.currentSupervisor(); sup != nil {
+			audioData = sup.outbound(audioData)
+		}
+		if mh.recorder != nil {
+			if err := mh.recorder.Outbound(audioData); err != nil {
+				mh.logger.Errorf("Failed to write local recording: %v", err)
+			}
+		}
+		// 使用协商确定的编码器进行编码
+		payload, err := encoder.Encode(audioData)
+		if err != nil {
+			mh.logger.Errorf("Failed to encode audio: %v", err)
 			continue
 		}
-
-		// 从捕获缓冲区中取出足够的音频数据
-		audioData := mh.buffer[:framesize]
-		mh.buffer = mh.buffer[framesize:]
-		mh.bufferMutex.Unlock()
-		var payload []byte
-		// 根据 codec 参数选择合适的编码器进行编码
-		if codec == "g722" {
-			payload = g722Encoder.Encode(audioData)
-		} else {
-			payload, _ = go711.EncodePCMU(audioData)
+		// websocket 媒体传输时作为二进制消息发送
+		if mh.sendAudio != nil {
+			if err := mh.sendAudio(payload); err != nil {
+				mh.logger.Errorf("Failed to send audio frame: %v", err)
+			}
+			continue
 		}
 		// 创建一个媒体样本
 		sample := media.Sample{
@@ -423,7 +912,7 @@ func (mh *MediaHandler) encodeAndSendAudio(codec string) {
 			Timestamp: time.Now(),
 		}
 		// 通过本地音频轨道发送
-		err := mh.audioTrack.WriteSample(sample)
+		err = mh.audioTrack.WriteSample(sample)
 		if err != nil {
 			mh.logger.Errorf("Failed to send audio sample: %v", err)
 			continue
@@ -437,19 +926,35 @@ func (mh *MediaHandler) Stop() error {
 	mh.mu.Lock()
 	defer mh.mu.Unlock()
 
-	if !mh.connected {
+	if !mh.connected.Load() {
 		return nil
 	}
 	// 取消上下文
 	mh.cancel()
-	// 停止并释放播放设备、捕获设备和播放上下文
-	if mh.playbackDevice != nil {
-		mh.playbackDevice.Stop()
-		mh.playbackDevice.Uninit()
+	if sup := mh.currentSupervisor(); sup != nil {
+		sup.Detach()
+	}
+	// 停止音频输出、音频输入并释放音频设备上下文
+	if err := mh.sink.Stop(); err != nil {
+		mh.logger.Warnf("Failed to stop audio output: %v", err)
 	}
-	if mh.captureDevice != nil {
-		mh.captureDevice.Stop()
-		mh.captureDevice.Uninit()
+	if err := mh.source.Stop(); err != nil {
+		mh.logger.Warnf("Failed to stop audio input: %v", err)
+	}
+	if mh.recorder != nil {
+		if err := mh.recorder.Close(); err != nil {
+			mh.logger.Warnf("Failed to close local recording: %v", err)
+		}
+	}
+	if mh.playback != nil {
+		stats := mh.playback.Stats()
+		mh.logger.Infof("Playback stats: underruns %d (%d bytes), overflow %d bytes, skipped %d bytes, cleared %d bytes",
+			stats.Underruns, stats.UnderrunBytes, stats.OverflowBytes, stats.SkippedBytes, stats.ClearedBytes)
+	}
+	if mh.echo != nil {
+		stats := mh.echo.Stats()
+		mh.logger.Infof("Echo canceller stats: ERLE %.1fdB, double talk %d samples, dropped reference %d samples",
+			stats.ERLEdB, stats.DoubleTalk, stats.FarDropped)
 	}
 	if mh.playbackCtx != nil {
 		mh.playbackCtx.Uninit()
@@ -460,7 +965,7 @@ func (mh *MediaHandler) Stop() error {
 	}
 
 	// 将连接状态设置为 false
-	mh.connected = false
+	mh.connected.Store(false)
 	// 记录停止信息并返回 nil
 	mh.logger.Info("Media handler stopped")
 	return nil
@@ -474,28 +979,161 @@ func SetupAndRunClient(config Config, ctx context.Context) {
 	// 给结构体实例赋值
 	option, callOption := buildClientOptions(config, sigChan)
 
+	// 配置了 OpenAI 密钥时启用大模型对话
+	if config.OpenaiKey != "" {
+		llm, err := createLLMHandler(config)
+		if err != nil {
+			config.Logger.Fatalf("Failed to create LLM handler: %v", err)
+		}
+		option.LLMHandler = llm
+		option.OpenaiModel = config.OpenaiModel
+		// 收到挂断事件后立即生成通话小结，通话记录写入前等待小结完成
+		if config.Summary {
+			option.CallRecord.OnHangup = func(record *CallRecord) {
+				summarizeCall(llm, config.OpenaiModel, config.CallRecordDir, record, config.Logger)
+			}
+		}
+	}
+	// 配置了安全检查时，大模型输出先经过 guardrail 再送往 TTS
+	if config.GuardrailsFile != "" {
+		guardrailConfig, err := LoadGuardrailConfig(config.GuardrailsFile)
+		if err != nil {
+			config.Logger.Fatalf("Failed to load guardrails: %v", err)
+		}
+		guardrail, err := NewGuardrail(*guardrailConfig, config.Logger)
+		if err != nil {
+			config.Logger.Fatalf("Failed to create guardrails: %v", err)
+		}
+		defer guardrail.Close()
+		option.Guardrail = guardrail
+	}
+
+	// SIP 通话由服务器呼叫 --callee 并桥接媒体，本地不需要媒体处理器
+	if config.CallType == "sip" {
+		runSIPCall(config, option, callOption)
+		return
+	}
+
 	// 媒体处理器初始化
 	// 创建媒体处理器，用于管理音频流和 SDP 协议
-	mediaHandler, err := NewMediaHandler(config.Ctx, config.Logger)
+	var mediaOptions []MediaOption
+	var recorder *localRecorder
+	if config.Trickle {
+		mediaOptions = append(mediaOptions, WithTrickleICE())
+	}
+	mediaOptions = append(mediaOptions, WithQualityInterval(config.QualityInterval))
+	if config.AEC {
+		mediaOptions = append(mediaOptions, WithEchoCanceller(config.AECTail))
+	}
+	if config.AGC || config.NoiseGate != 0 {
+		agcConfig := DefaultAGCConfig()
+		agcConfig.AutoGain = config.AGC
+		agcConfig.TargetDB = config.AGCTarget
+		agcConfig.MaxGainDB = config.AGCMaxGain
+		agcConfig.Attack = config.AGCAttack
+		agcConfig.Release = config.AGCRelease
+		agcConfig.GateDB = config.NoiseGate
+		mediaOptions = append(mediaOptions, WithAGC(agcConfig))
+	}
+	if config.LocalVAD {
+		vadConfig := DefaultVADConfig()
+		vadConfig.ThresholdDB = config.VADThreshold
+		vadConfig.MinSpeech = config.VADMinSpeech
+		vadConfig.Hangover = config.VADHangover
+		mediaOptions = append(mediaOptions, WithLocalVAD(vadConfig))
+	}
+	if config.RecordLocal != "" {
+		recorder = newLocalRecorder(filepath.Join(config.RecordLocal, option.CallID), config.RecordSplit)
+		mediaOptions = append(mediaOptions, WithRecorder(recorder))
+	}
+	mediaHandler, err := createMediaHandler(config, mediaOptions...)
 	if err != nil {
 		config.Logger.Fatalf("Failed to create media handler: %v", err)
 	}
 	defer mediaHandler.Stop()
+	// 挂断时对等连接还没有关闭，再采集一次通话质量，之后再生成通话小结
+	summarize := option.CallRecord.OnHangup
+	option.CallRecord.OnHangup = func(record *CallRecord) {
+		record.SetQuality(mediaHandler.FinalQuality())
+		if summarize != nil {
+			summarize(record)
+		}
+	}
 
-	callType := "webrtc"
-	// 获取ICE列表
-	iceServers := getICEServers(config)
+	callType := config.CallType
+	switch callType {
+	case "webrtc":
+		// 获取ICE列表
+		iceServers := getICEServers(config)
 
-	// 解析 SDP
-	localSdp, err := mediaHandler.Setup(config.Codec, iceServers)
-	if err != nil {
-		config.Logger.Fatalf("Failed to get local SDP: %v", err)
+		// 解析 SDP
+		localSdp, err := mediaHandler.Setup(config.Codec, iceServers)
+		if err != nil {
+			config.Logger.Fatalf("Failed to get local SDP: %v", err)
+		}
+		config.Logger.Infof("Offer SDP: %v", localSdp)
+		callOption.Offer = localSdp
+	case "websocket":
+		// 没有 SDP，在 invite 中指定编解码器，音频作为二进制消息在信令连接上传输
+		codec, err := websocketCodec(config.Codec)
+		if err != nil {
+			config.Logger.Fatalf("Failed to select codec: %v", err)
+		}
+		callOption.Codec = codec.Name
+	default:
+		config.Logger.Fatalf("Invalid call type: %s, expected webrtc, websocket or sip", callType)
 	}
-	config.Logger.Infof("Offer SDP: %v", localSdp)
-	callOption.Offer = localSdp
 
 	// 创建 RustpbxGo 客户端连接服务器，通话结束后自动关闭
-	client := createClient(config.Ctx, option, "", callOption)
+	client := createClient(config.Ctx, option, option.CallID, callOption)
+	mediaHandler.OnInputLevel = func(level InputLevel) {
+		config.Logger.WithFields(logrus.Fields{
+			"rms":   fmt.Sprintf("%.1f", level.RMSDB),
+			"peak":  fmt.Sprintf("%.1f", level.PeakDB),
+			"gain":  fmt.Sprintf("%.1f", level.GainDB),
+			"gated": level.Gated,
+		}).Debug("Input level")
+	}
+	// 本地 VAD 检测到用户开始说话时立即停止本地播放，并通知服务器打断 TTS
+	mediaHandler.OnLocalSpeech = func(speaking bool) {
+		if !speaking {
+			config.Logger.Debug("Local VAD: speech ended")
+			return
+		}
+		config.Logger.Info("Local VAD: speech started, interrupting playback")
+		mediaHandler.ClearPlayback()
+		go func() {
+			if err := client.Interrupt(); err != nil {
+				config.Logger.Warnf("Failed to interrupt: %v", err)
+			}
+		}()
+	}
+	// trickle ICE 模式下接收服务器发来的远程候选
+	// 服务器收到 invite 后才能接收本地候选，振铃或应答时开始发送
+	forwardCandidates := func() {
+		if err := mediaHandler.ForwardCandidates(client.SendCandidates); err != nil {
+			config.Logger.Warnf("Failed to send ICE candidates: %v", err)
+		}
+	}
+	if callType == "websocket" {
+		client.OnAudio = mediaHandler.ReceiveWebsocketAudio
+	}
+	if config.Trickle && callType == "webrtc" {
+		onRinging := client.OnRinging
+		client.OnRinging = func(event rustpbxgo.RingingEvent) {
+			forwardCandidates()
+			if onRinging != nil {
+				onRinging(event)
+			}
+		}
+		client.OnCandidate = func(event rustpbxgo.CandidateEvent) {
+			for _, candidate := range event.Candidates {
+				if err := mediaHandler.AddRemoteCandidate(candidate); err != nil {
+					config.Logger.Warnf("Failed to add remote ICE candidate: %v", err)
+				}
+			}
+		}
+	}
 	// 连接服务器
 	err = client.Connect(callType)
 	if err != nil {
@@ -508,26 +1146,169 @@ func SetupAndRunClient(config Config, ctx context.Context) {
 	if err != nil {
 		config.Logger.Fatalf("Failed to invite: %v", err)
 	}
-	config.Logger.Infof("Answer SDP: %v", answer.Sdp)
+	option.CallRecord.SetAnswered()
+	if callType == "websocket" {
+		if err := mediaHandler.StartWebsocket(callOption.Codec, client.SendAudio); err != nil {
+			config.Logger.Fatalf("Failed to start websocket media: %v", err)
+		}
+	} else {
+		config.Logger.Infof("Answer SDP: %v", answer.Sdp)
+		if config.Trickle {
+			forwardCandidates()
+		}
 
-	// ICE服务器获取与SDP协商
-	err = mediaHandler.SetupAnswer(answer.Sdp)
-	if err != nil {
-		config.Logger.Fatalf("Failed to setup answer: %v", err)
+		// ICE服务器获取与SDP协商
+		err = mediaHandler.SetupAnswer(answer.Sdp)
+		if err != nil {
+			config.Logger.Fatalf("Failed to setup answer: %v", err)
+		}
+	}
+
+	// 应答后把本地音频文件注入到通话中
+	if config.InjectFile != "" {
+		mode, err := ParseInjectMode(config.InjectMode)
+		if err != nil {
+			config.Logger.Fatalf("Failed to parse inject mode: %v", err)
+		}
+		injection, err := mediaHandler.PlayFile(config.InjectFile, config.InjectRate, mode)
+		if err != nil {
+			config.Logger.Errorf("Failed to inject %s: %v", config.InjectFile, err)
+		} else {
+			go func() {
+				<-injection.Done()
+				config.Logger.Infof("Injected %s (%v)", config.InjectFile, injection.Position())
+			}()
+		}
+	}
+
+	// 主管通过本地声卡或 websocket 音频流旁听、耳语或插话
+	if config.SupervisorDevice != "" {
+		mode, err := ParseSupervisorMode(config.SupervisorMode)
+		if err != nil {
+			config.Logger.Fatalf("Failed to parse supervisor mode: %v", err)
+		}
+		device := audioDeviceConfig{
+			contextFn:      mediaHandler.audioContext,
+			sampleRate:     config.DeviceRate,
+			captureDevice:  config.SupervisorDevice,
+			playbackDevice: config.SupervisorDevice,
+		}
+		// 媒体连接建立后才知道音频的采样率
+		go func() {
+			select {
+			case <-mediaHandler.mediaStarted:
+			case <-config.Ctx.Done():
+				return
+			}
+			if _, err := mediaHandler.AttachSupervisor(&deviceSource{config: device}, &deviceSink{config: device}, mode); err != nil {
+				config.Logger.Errorf("Failed to attach supervisor device: %v", err)
+			}
+		}()
+	}
+	if config.SupervisorListen != "" {
+		if config.SupervisorToken == "" {
+			config.Logger.Fatal("--supervisor-token or SUPERVISOR_TOKEN is required with --supervisor-listen")
+		}
+		addr := supervisorAddr(config.SupervisorListen)
+		mux := http.NewServeMux()
+		mux.Handle("/supervisor", supervisorHandler(mediaHandler, config.SupervisorToken))
+		server := &http.Server{Addr: addr, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				config.Logger.Errorf("Supervisor server failed: %v", err)
+			}
+		}()
+		defer server.Close()
+		config.Logger.Infof("Supervisor server listening on %s", addr)
 	}
 
 	<-sigChan
+	if recorder != nil {
+		option.CallRecord.Recordings = recorder.Files()
+	}
+	// 没有收到挂断事件时使用周期采集的统计
+	if !option.CallRecord.WaitHangup(time.Second) {
+		option.CallRecord.SetQuality(mediaHandler.QualitySummary())
+	}
+	finishCall(config, option)
 	// fmt.Println("Shutting down...")
 }
 
+// 根据 --audio-in/--audio-out 创建媒体处理器，服务器和 CI 上可以不依赖声卡运行
+func createMediaHandler(config Config, opts ...MediaOption) (*MediaHandler, error) {
+	var mh *MediaHandler
+	device := audioDeviceConfig{
+		contextFn: func() (*malgo.AllocatedContext, error) {
+			return mh.audioContext()
+		},
+		sampleRate:     config.DeviceRate,
+		captureDevice:  config.InputDevice,
+		playbackDevice: config.OutputDevice,
+	}
+	source, err := ParseAudioSource(config.AudioIn, device)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := ParseAudioSink(config.AudioOut, device)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]MediaOption{WithAudioSource(source), WithAudioSink(sink)}, opts...)
+	mh, err = NewMediaHandler(config.Ctx, config.Logger, opts...)
+	return mh, err
+}
+
+// 创建大模型处理器，配置了阶段文件时进入多阶段模式
+func createLLMHandler(config Config) (*LLMHandler, error) {
+	llm := NewLLMHandler(config.Ctx, config.OpenaiKey, config.OpenaiEndpoint, config.SystemPrompt, config.Logger)
+	if err := setupForm(llm, config); err != nil {
+		return nil, err
+	}
+	if config.StagesFile == "" {
+		return llm, nil
+	}
+	stages, err := LoadStageConfig(config.StagesFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := llm.SetStages(stages); err != nil {
+		return nil, err
+	}
+	llm.OnStageChange = func(from, to *Stage, summary string) {
+		config.Logger.Infof("Stage changed: %s -> %s", from.Name, to.Name)
+	}
+	return llm, nil
+}
+
+// 配置了表单时启用表单填写模式，表单完成时输出事件
+func setupForm(llm *LLMHandler, config Config) error {
+	if config.FormFile == "" {
+		return nil
+	}
+	schema, err := LoadFormSchema(config.FormFile)
+	if err != nil {
+		return err
+	}
+	form := NewForm(schema)
+	form.OnComplete = func(result FormResult) {
+		data, _ := json.Marshal(result)
+		config.Logger.WithField("form", string(data)).Info("Form completed")
+	}
+	llm.SetForm(form)
+	return nil
+}
+
 // 构建客户端选项和通话参数
 func buildClientOptions(config Config, sigChan chan bool) (CreateClientOption, rustpbxgo.CallOption) {
-	option := CreateClientOption {
+	option := CreateClientOption{
 		Endpoint:   config.Endpoint,
 		Logger:     config.Logger,
 		SigChan:    sigChan,
 		BreakOnVad: config.BreakOnVad,
+		CallID:     uuid.New().String(),
+		FormOutput: config.FormOutput,
 	}
+	option.CallRecord = NewCallRecord(option.CallID)
 	var recorder *rustpbxgo.RecorderOption
 	if config.Record {
 		recorder = &rustpbxgo.RecorderOption{
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+const (
+	// playbackMaxBuffered 播放缓冲区的容量，写满后丢弃最旧的数据
+	playbackMaxBuffered = 500 * time.Millisecond
+	// playbackTarget 目标播放时延：缓冲耗尽后积累到该时长再开始播放，跳过积压时也保留该时长
+	playbackTarget = 40 * time.Millisecond
+	// playbackHighWater 积压超过该时长时认为播放设备比 RTP 慢，跳过多余的数据回到目标时延
+	playbackHighWater = 150 * time.Millisecond
+	// playbackFade 数据不连续处的淡入淡出时长，避免咔嗒声
+	playbackFade = 5 * time.Millisecond
+)
+
+// PlaybackStats 播放缓冲区的统计信息
+type PlaybackStats struct {
+	Underruns     uint64  `json:"underruns"`     // 播放中数据耗尽的次数
+	UnderrunBytes uint64  `json:"underrunBytes"` // 数据不足时补零的字节数
+	OverflowBytes uint64  `json:"overflowBytes"` // 缓冲区写满丢弃的字节数
+	SkippedBytes  uint64  `json:"skippedBytes"`  // 积压过深为降低时延跳过的字节数
+	ClearedBytes  uint64  `json:"clearedBytes"`  // 打断时清空的字节数
+	Buffered      int     `json:"buffered"`      // 当前缓冲的字节数
+	LatencyMs     float64 `json:"latencyMs"`     // 当前缓冲的时长
+}
+
+// playbackQueue 有界的播放环形缓冲区，由播放协程写入解码后的音频，由音频输出按需读取
+// 读取时补零而不是保留输出缓冲区中的旧数据，并在数据不连续处从上一个采样平滑过渡
+type playbackQueue struct {
+	mu         sync.Mutex
+	ring       []byte
+	read       int // 下一个读取位置
+	size       int // 已缓冲的字节数
+	sampleRate int
+	target     int
+	highWater  int
+	fade       int  // 过渡的采样数
+	playing    bool // 是否已缓冲到目标时延并开始播放
+	last       int16
+	smooth     bool // 下一段输出需要从 last 平滑过渡
+	stats      PlaybackStats
+}
+
+// newPlaybackQueue 按采样率创建播放缓冲区
+func newPlaybackQueue(sampleRate int) *playbackQueue {
+	return &playbackQueue{
+		ring:       make([]byte, durationBytes(sampleRate, playbackMaxBuffered)),
+		sampleRate: sampleRate,
+		target:     durationBytes(sampleRate, playbackTarget),
+		highWater:  durationBytes(sampleRate, playbackHighWater),
+		fade:       durationBytes(sampleRate, playbackFade) / 2,
+	}
+}
+
+// Write 追加解码后的音频，缓冲区满时丢弃最旧的数据
+func (q *playbackQueue) Write(pcm []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(pcm) > len(q.ring) {
+		q.stats.OverflowBytes += uint64(len(pcm) - len(q.ring))
+		pcm = pcm[len(pcm)-len(q.ring):]
+	}
+	if excess := q.size + len(pcm) - len(q.ring); excess > 0 {
+		q.discard(excess)
+		q.stats.OverflowBytes += uint64(excess)
+		q.smooth = true
+	}
+	write := (q.read + q.size) % len(q.ring)
+	n := copy(q.ring[write:], pcm)
+	copy(q.ring, pcm[n:])
+	q.size += len(pcm)
+}
+
+// discard 从读取位置丢弃 n 字节，调用时需持有 mu
+func (q *playbackQueue) discard(n int) {
+	q.read = (q.read + n) % len(q.ring)
+	q.size -= n
+}
+
+// Read 用缓冲的数据填满 output，数据不足时补零
+func (q *playbackQueue) Read(output []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// 缓冲耗尽后先积累到目标时延，避免断断续续地播放
+	if !q.playing && q.size >= q.target {
+		q.playing = true
+	}
+	// 积压过深时跳过多余的数据，保留目标时延
+	if q.playing && q.size > q.highWater {
+		skip := q.size - q.target
+		skip -= skip % 2
+		q.discard(skip)
+		q.stats.SkippedBytes += uint64(skip)
+		q.smooth = true
+	}
+
+	n := 0
+	if q.playing {
+		n = min(len(output), q.size)
+		n -= n % 2
+		first := min(n, len(q.ring)-q.read)
+		copy(output, q.ring[q.read:q.read+first])
+		copy(output[first:n], q.ring)
+		q.discard(n)
+	}
+	clear(output[n:])
+	if n < len(output) && q.playing {
+		q.playing = false
+		q.stats.Underruns++
+	}
+	if n < len(output) {
+		q.stats.UnderrunBytes += uint64(len(output) - n)
+	}
+
+	if q.smooth {
+		q.crossfade(output)
+		q.smooth = false
+	}
+	// 数据不足时输出从最后一个采样衰减到零，下一段数据从零开始淡入
+	if n < len(output) {
+		if n > 0 || q.last != 0 {
+			q.fadeOut(output, n)
+		}
+		q.smooth = true
+	}
+	if len(output) >= 2 {
+		q.last = int16(binary.LittleEndian.Uint16(output[len(output)-2:]))
+	}
+}
+
+// crossfade 在输出开头从上一段最后一个采样过渡到新数据
+func (q *playbackQueue) crossfade(output []byte) {
+	for i := 0; i < q.fade && i*2+1 < len(output); i++ {
+		t := float64(i+1) / float64(q.fade+1)
+		sample := float64(int16(binary.LittleEndian.Uint16(output[i*2:])))
+		binary.LittleEndian.PutUint16(output[i*2:], uint16(clampSample(sample*t+float64(q.last)*(1-t))))
+	}
+}
+
+// fadeOut 在补零的位置从前一个采样衰减到零
+func (q *playbackQueue) fadeOut(output []byte, n int) {
+	from := q.last
+	if n >= 2 {
+		from = int16(binary.LittleEndian.Uint16(output[n-2:]))
+	}
+	for i := 0; i < q.fade && n+i*2+1 < len(output); i++ {
+		t := float64(i+1) / float64(q.fade+1)
+		binary.LittleEndian.PutUint16(output[n+i*2:], uint16(clampSample(float64(from)*(1-t))))
+	}
+}
+
+// Clear 丢弃还没有播放的数据，用于本地打断
+func (q *playbackQueue) Clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stats.ClearedBytes += uint64(q.size)
+	q.read, q.size = 0, 0
+	q.playing = false
+}
+
+// Stats 返回统计信息
+func (q *playbackQueue) Stats() PlaybackStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Buffered = q.size
+	stats.LatencyMs = float64(q.size/2) * 1000 / float64(q.sampleRate)
+	return stats
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// constantPCM 生成采样值都为 value 的数据
+func constantPCM(value int16, samples int) []byte {
+	pcm := make([]byte, samples*2)
+	for i := 0; i < samples; i++ {
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(value))
+	}
+	return pcm
+}
+
+// 测试缓冲到目标时延才开始播放，数据耗尽时补零并从最后一个采样平滑衰减
+func TestPlaybackQueue_UnderrunFade(t *testing.T) {
+	q := newPlaybackQueue(8000)
+	output := make([]byte, frameBytes(8000))
+	for i := range output {
+		output[i] = 0xff
+	}
+	q.Write(constantPCM(1000, 160))
+	q.Read(output)
+	for _, b := range output {
+		if b != 0 {
+			t.Fatalf("expected silence before reaching the target latency, stale bytes left in the output")
+		}
+	}
+
+	q.Write(constantPCM(1000, 160))
+	q.Read(output)
+	if sample := int16(binary.LittleEndian.Uint16(output[len(output)-2:])); sample != 1000 {
+		t.Fatalf("expected buffered audio, got sample %d", sample)
+	}
+	q.Read(output)
+	q.Read(output)
+	if stats := q.Stats(); stats.Underruns != 1 {
+		t.Fatalf("expected 1 underrun, got %+v", stats)
+	}
+	first := int16(binary.LittleEndian.Uint16(output))
+	if first <= 0 || first >= 1000 {
+		t.Errorf("expected a fade out from the last sample, got %d", first)
+	}
+	if last := int16(binary.LittleEndian.Uint16(output[len(output)-2:])); last != 0 {
+		t.Errorf("expected silence after the fade, got %d", last)
+	}
+}
+
+// 测试缓冲区有界，积压过深时跳过多余数据回到目标时延
+func TestPlaybackQueue_BoundedAndSkip(t *testing.T) {
+	q := newPlaybackQueue(8000)
+	q.Write(constantPCM(1000, 8000))
+	stats := q.Stats()
+	if stats.LatencyMs != float64(playbackMaxBuffered/time.Millisecond) || stats.OverflowBytes != uint64(durationBytes(8000, time.Second-playbackMaxBuffered)) {
+		t.Fatalf("unexpected stats after overflow: %+v", stats)
+	}
+
+	output := make([]byte, frameBytes(8000))
+	q.Read(output)
+	stats = q.Stats()
+	want := durationBytes(8000, playbackTarget) - len(output)
+	if stats.Buffered != want || stats.SkippedBytes == 0 {
+		t.Errorf("expected to skip back to the target latency, got %+v", stats)
+	}
+
+	q.Clear()
+	if stats := q.Stats(); stats.Buffered != 0 || stats.ClearedBytes != uint64(want) {
+		t.Errorf("unexpected stats after clear: %+v", stats)
+	}
+}
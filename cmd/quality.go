@@ -0,0 +1,270 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v3"
+)
+
+// QualitySample 一个统计周期内的通话质量
+type QualitySample struct {
+	Timestamp         time.Time `json:"timestamp"`
+	PacketsReceived   uint64    `json:"packetsReceived"`   // 累计收到的包数
+	PacketsLost       int64     `json:"packetsLost"`       // 累计网络丢包数
+	LossPercent       float64   `json:"lossPercent"`       // 本周期入站丢包率，包含抖动缓冲丢弃的迟到包
+	RemoteLossPercent float64   `json:"remoteLossPercent"` // 对方 RTCP 接收报告中的丢包率
+	JitterMs          float64   `json:"jitterMs"`          // 入站到达间隔抖动
+	RTTMs             float64   `json:"rttMs"`             // 往返时延，优先使用 RTCP，其次使用 ICE 连通性检查
+	BufferMs          float64   `json:"bufferMs"`          // 抖动缓冲带来的时延
+	BytesSent         uint64    `json:"bytesSent"`
+	BytesReceived     uint64    `json:"bytesReceived"`
+	MOS               float64   `json:"mos"` // E-model 估算的 MOS
+}
+
+// QualitySummary 通话结束时写入通话记录的质量汇总
+type QualitySummary struct {
+	Samples         int     `json:"samples"`
+	MOSAvg          float64 `json:"mosAvg"`
+	MOSMin          float64 `json:"mosMin"`
+	LossPercentAvg  float64 `json:"lossPercentAvg"`
+	LossPercentMax  float64 `json:"lossPercentMax"`
+	JitterMsAvg     float64 `json:"jitterMsAvg"`
+	JitterMsMax     float64 `json:"jitterMsMax"`
+	RTTMsAvg        float64 `json:"rttMsAvg"`
+	PacketsReceived uint64  `json:"packetsReceived"`
+	PacketsLost     int64   `json:"packetsLost"`
+	BytesSent       uint64  `json:"bytesSent"`
+	BytesReceived   uint64  `json:"bytesReceived"`
+}
+
+// packetizationMs 每个包的打包时延
+const packetizationMs = 20
+
+// estimateMOS 按简化的 ITU-T G.107 E-model 由单向时延和丢包率估算 MOS
+// 设备损伤 Ie 取 0、丢包健壮性 Bpl 取 25.1（带丢包补偿的 G.711，见 G.113 附录 I）
+func estimateMOS(delayMs, lossPercent float64) float64 {
+	// 时延损伤 Id
+	id := 0.024 * delayMs
+	if delayMs > 177.3 {
+		id += 0.11 * (delayMs - 177.3)
+	}
+	// 随机丢包下的有效设备损伤 Ie-eff
+	const ie, bpl = 0.0, 25.1
+	ieEff := ie + (95-ie)*lossPercent/(lossPercent+bpl)
+	r := 93.2 - id - ieEff
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	mos := 1 + 0.035*r + 7e-6*r*(r-60)*(100-r)
+	return math.Min(math.Max(mos, 1), 4.5)
+}
+
+// qualityMonitor 计算每个周期的丢包率并累计通话的质量汇总
+type qualityMonitor struct {
+	mu          sync.Mutex
+	getter      stats.Getter
+	inboundSSRC uint32
+	clockRate   int
+	lastLost    int64
+	lastRecv    uint64
+	lastLate    uint64
+	samples     int
+	mosSum      float64
+	mosMin      float64
+	lossSum     float64
+	lossMax     float64
+	jitterSum   float64
+	jitterMax   float64
+	rttSum      float64
+	rttSamples  int
+	last        QualitySample
+}
+
+// registerQualityInterceptors 注册 RTCP 收发报告和统计拦截器，统计数据通过 monitor 读取
+func registerQualityInterceptors(registry *interceptor.Registry, monitor *qualityMonitor) error {
+	if err := webrtc.ConfigureRTCPReports(registry); err != nil {
+		return err
+	}
+	statsInterceptor, err := stats.NewInterceptor()
+	if err != nil {
+		return err
+	}
+	statsInterceptor.OnNewPeerConnection(func(_ string, getter stats.Getter) {
+		monitor.mu.Lock()
+		monitor.getter = getter
+		monitor.mu.Unlock()
+	})
+	registry.Add(statsInterceptor)
+	return nil
+}
+
+// setInbound 记录远程音频流的 SSRC 和时钟频率
+func (q *qualityMonitor) setInbound(ssrc uint32, clockRate int) {
+	q.mu.Lock()
+	q.inboundSSRC = ssrc
+	q.clockRate = clockRate
+	q.mu.Unlock()
+}
+
+// inboundStats 读取远程音频流的统计和时钟频率
+func (q *qualityMonitor) inboundStats() (*stats.Stats, int) {
+	q.mu.Lock()
+	ssrc, clockRate := q.inboundSSRC, q.clockRate
+	q.mu.Unlock()
+	return q.streamStats(ssrc), clockRate
+}
+
+// streamStats 读取 ssrc 对应的 RTP 流统计
+func (q *qualityMonitor) streamStats(ssrc uint32) *stats.Stats {
+	q.mu.Lock()
+	getter := q.getter
+	q.mu.Unlock()
+	if getter == nil || ssrc == 0 {
+		return nil
+	}
+	return getter.Get(ssrc)
+}
+
+// add 根据累计计数计算本周期的丢包率和 MOS，并加入汇总
+// late 为抖动缓冲累计丢弃的迟到包数，对听者来说与丢包相同
+func (q *qualityMonitor) add(sample QualitySample, late uint64) QualitySample {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	lost := sample.PacketsLost - q.lastLost + int64(late-q.lastLate)
+	expected := int64(sample.PacketsReceived-q.lastRecv) + sample.PacketsLost - q.lastLost
+	if expected > 0 && lost > 0 {
+		sample.LossPercent = math.Min(100, float64(lost)*100/float64(expected))
+	}
+	q.lastLost, q.lastRecv, q.lastLate = sample.PacketsLost, sample.PacketsReceived, late
+
+	// 单向时延：半个往返时延加上打包和抖动缓冲时延
+	delay := sample.RTTMs/2 + packetizationMs + sample.BufferMs
+	sample.MOS = math.Round(estimateMOS(delay, sample.LossPercent)*100) / 100
+
+	q.samples++
+	q.mosSum += sample.MOS
+	if q.samples == 1 || sample.MOS < q.mosMin {
+		q.mosMin = sample.MOS
+	}
+	q.lossSum += sample.LossPercent
+	q.lossMax = math.Max(q.lossMax, sample.LossPercent)
+	q.jitterSum += sample.JitterMs
+	q.jitterMax = math.Max(q.jitterMax, sample.JitterMs)
+	if sample.RTTMs > 0 {
+		q.rttSum += sample.RTTMs
+		q.rttSamples++
+	}
+	q.last = sample
+	return sample
+}
+
+// Summary 返回通话的质量汇总，没有统计过时返回 nil
+func (q *qualityMonitor) Summary() *QualitySummary {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.samples == 0 {
+		return nil
+	}
+	n := float64(q.samples)
+	summary := &QualitySummary{
+		Samples:         q.samples,
+		MOSAvg:          math.Round(q.mosSum/n*100) / 100,
+		MOSMin:          q.mosMin,
+		LossPercentAvg:  q.lossSum / n,
+		LossPercentMax:  q.lossMax,
+		JitterMsAvg:     q.jitterSum / n,
+		JitterMsMax:     q.jitterMax,
+		PacketsReceived: q.last.PacketsReceived,
+		PacketsLost:     q.last.PacketsLost,
+		BytesSent:       q.last.BytesSent,
+		BytesReceived:   q.last.BytesReceived,
+	}
+	if q.rttSamples > 0 {
+		summary.RTTMsAvg = q.rttSum / float64(q.rttSamples)
+	}
+	return summary
+}
+
+// collectQuality 从 RTP 流统计、ICE 候选对统计和抖动缓冲采集一次通话质量
+func (mh *MediaHandler) collectQuality() QualitySample {
+	sample := QualitySample{Timestamp: time.Now()}
+	if inbound, clockRate := mh.quality.inboundStats(); inbound != nil {
+		sample.PacketsReceived = inbound.InboundRTPStreamStats.PacketsReceived
+		sample.PacketsLost = inbound.InboundRTPStreamStats.PacketsLost
+		sample.BytesReceived = inbound.InboundRTPStreamStats.BytesReceived
+		if clockRate > 0 {
+			sample.JitterMs = inbound.InboundRTPStreamStats.Jitter * 1000 / float64(clockRate)
+		}
+	}
+	if outbound := mh.quality.streamStats(mh.outboundSSRC()); outbound != nil {
+		sample.BytesSent = outbound.OutboundRTPStreamStats.BytesSent
+		sample.RemoteLossPercent = outbound.RemoteInboundRTPStreamStats.FractionLost * 100
+		if outbound.RemoteInboundRTPStreamStats.RoundTripTimeMeasurements > 0 {
+			sample.RTTMs = float64(outbound.RemoteInboundRTPStreamStats.RoundTripTime) / float64(time.Millisecond)
+		}
+	}
+	// 没有 RTCP 往返时延时使用 ICE 连通性检查的往返时延
+	if sample.RTTMs == 0 && mh.peerConnection != nil {
+		for _, report := range mh.peerConnection.GetStats() {
+			if pair, ok := report.(webrtc.ICECandidatePairStats); ok && pair.Nominated && pair.CurrentRoundTripTime > 0 {
+				sample.RTTMs = pair.CurrentRoundTripTime * 1000
+			}
+		}
+	}
+	jitter := mh.JitterStats()
+	sample.BufferMs = float64(jitter.Depth * packetizationMs)
+	return mh.quality.add(sample, jitter.Late)
+}
+
+// outboundSSRC 返回本地音频流的 SSRC
+func (mh *MediaHandler) outboundSSRC() uint32 {
+	if mh.audioSender == nil {
+		return 0
+	}
+	encodings := mh.audioSender.GetParameters().Encodings
+	if len(encodings) == 0 {
+		return 0
+	}
+	return uint32(encodings[0].SSRC)
+}
+
+// qualityLoop 按周期采集通话质量，记录日志并回调 OnQuality
+func (mh *MediaHandler) qualityLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mh.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sample := mh.collectQuality()
+		mh.logger.Infof("Call quality: MOS %.2f, loss %.1f%% (remote %.1f%%), jitter %.1fms, rtt %.0fms, buffer %.0fms",
+			sample.MOS, sample.LossPercent, sample.RemoteLossPercent, sample.JitterMs, sample.RTTMs, sample.BufferMs)
+		if mh.OnQuality != nil {
+			mh.OnQuality(sample)
+		}
+	}
+}
+
+// FinalQuality 挂断时再采集一次通话质量并返回汇总，短于采集周期或没有开启周期采集的通话也有统计
+// 需要在对等连接关闭之前调用，websocket 媒体没有 RTP 统计，只返回已有的汇总
+func (mh *MediaHandler) FinalQuality() *QualitySummary {
+	if mh.peerConnection != nil && mh.connected.Load() {
+		mh.collectQuality()
+	}
+	return mh.quality.Summary()
+}
+
+// QualitySummary 返回通话的质量汇总，没有统计过时返回 nil
+func (mh *MediaHandler) QualitySummary() *QualitySummary {
+	return mh.quality.Summary()
+}
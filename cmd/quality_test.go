@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 测试 E-model MOS 随时延和丢包下降
+func TestEstimateMOS(t *testing.T) {
+	good := estimateMOS(40, 0)
+	if good < 4.3 || good > 4.5 {
+		t.Errorf("expected MOS about 4.4 for a clean call, got %.2f", good)
+	}
+	lossy := estimateMOS(40, 5)
+	if lossy >= good || lossy < 3 {
+		t.Errorf("unexpected MOS with 5%% loss: %.2f", lossy)
+	}
+	if slow := estimateMOS(400, 0); slow >= good {
+		t.Errorf("MOS did not drop with 400ms delay: %.2f", slow)
+	}
+	if worst := estimateMOS(1000, 100); worst != 1 {
+		t.Errorf("expected MOS 1 for a broken call, got %.2f", worst)
+	}
+}
+
+// 测试按周期计算丢包率，迟到包计入丢包，并汇总通话质量
+func TestQualityMonitor(t *testing.T) {
+	var monitor qualityMonitor
+	if monitor.Summary() != nil {
+		t.Fatalf("summary without samples should be nil")
+	}
+
+	first := monitor.add(QualitySample{PacketsReceived: 250, RTTMs: 40, JitterMs: 2}, 0)
+	if first.LossPercent != 0 || first.MOS < 4.3 {
+		t.Errorf("unexpected first sample: %+v", first)
+	}
+	// 本周期收到 240 个包，网络丢失 5 个，迟到 5 个
+	second := monitor.add(QualitySample{PacketsReceived: 490, PacketsLost: 5, JitterMs: 30}, 5)
+	if second.LossPercent < 4 || second.LossPercent > 4.2 || second.MOS >= first.MOS {
+		t.Errorf("unexpected second sample: %+v", second)
+	}
+
+	summary := monitor.Summary()
+	if summary.Samples != 2 || summary.MOSMin != second.MOS || summary.JitterMsMax != 30 ||
+		summary.RTTMsAvg != 40 || summary.PacketsLost != 5 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
+
+// 测试挂断时再采集一次，没有周期采集过的通话也有质量汇总
+func TestMediaHandler_FinalQuality(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	mh, err := NewMediaHandler(context.Background(), logger)
+	if err != nil {
+		t.Fatalf("NewMediaHandler returned an error: %v", err)
+	}
+	defer mh.Stop()
+	if mh.FinalQuality() != nil {
+		t.Fatal("expected no summary before the media is connected")
+	}
+	if _, err := mh.Setup("pcmu", nil); err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+	mh.connected.Store(true)
+	if summary := mh.FinalQuality(); summary == nil || summary.Samples != 1 {
+		t.Errorf("expected a summary of one sample, got %+v", summary)
+	}
+}
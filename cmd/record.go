@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/restsend/rustpbxgo"
+)
+
+// TranscriptEntry is a single utterance of the conversation
+type TranscriptEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Speaker   string    `json:"speaker"` // user|assistant|system
+	Stage     string    `json:"stage,omitempty"`
+	Text      string    `json:"text"`
+}
+
+// CallRecord is the local record of a call, written to <dir>/<callID>.json when the call ends
+type CallRecord struct {
+	CallID       string            `json:"callId"`
+	StartTime    time.Time         `json:"startTime"`
+	AnswerTime   *time.Time        `json:"answerTime,omitempty"`
+	EndTime      *time.Time        `json:"endTime,omitempty"`
+	HangupReason string            `json:"hangupReason,omitempty"`
+	Initiator    string            `json:"initiator,omitempty"`
+	Transcript   []TranscriptEntry `json:"transcript,omitempty"`
+	Form         *FormResult       `json:"form,omitempty"`
+	SummaryFile  string            `json:"summaryFile,omitempty"`
+	Recordings   []string          `json:"recordings,omitempty"`
+	Quality      *QualitySummary   `json:"quality,omitempty"`
+
+	// OnHangup is called in the background once the hangup event was stored, Write waits for it to return
+	OnHangup func(r *CallRecord) `json:"-"`
+
+	mutex   sync.Mutex
+	hungup  chan struct{}
+	once    sync.Once
+	pending sync.WaitGroup
+}
+
+// NewCallRecord starts the record of a call
+func NewCallRecord(callID string) *CallRecord {
+	return &CallRecord{
+		CallID:    callID,
+		StartTime: time.Now(),
+		hungup:    make(chan struct{}),
+	}
+}
+
+// SetAnswered marks the time the call was answered
+func (r *CallRecord) SetAnswered() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	r.AnswerTime = &now
+}
+
+// SetHangup stores the hangup event of the call
+func (r *CallRecord) SetHangup(event rustpbxgo.HangupEvent) {
+	r.mutex.Lock()
+	now := time.Now()
+	r.EndTime = &now
+	r.HangupReason = event.Reason
+	r.Initiator = event.Initiator
+	r.mutex.Unlock()
+	r.once.Do(func() {
+		// Register the hook before waking WaitHangup so Write always waits for it
+		if r.OnHangup != nil {
+			r.pending.Add(1)
+			go func() {
+				defer r.pending.Done()
+				r.OnHangup(r)
+			}()
+		}
+		close(r.hungup)
+	})
+}
+
+// SetQuality stores the quality summary of the call
+func (r *CallRecord) SetQuality(quality *QualitySummary) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Quality = quality
+}
+
+// SetSummaryFile stores the name of the post-call summary file
+func (r *CallRecord) SetSummaryFile(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.SummaryFile = name
+}
+
+// WaitHangup waits until the hangup event was stored or the timeout expires
+func (r *CallRecord) WaitHangup(timeout time.Duration) bool {
+	select {
+	case <-r.hungup:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Write waits for the hangup hook and writes the record to <dir>/<callID>.json
+func (r *CallRecord) Write(dir string) (string, error) {
+	r.pending.Wait()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.EndTime == nil {
+		now := time.Now()
+		r.EndTime = &now
+	}
+	return writeJSONFile(dir, r.CallID+".json", r)
+}
+
+// writeJSONFile writes v as indented JSON to dir/name, creating dir when needed
+func writeJSONFile(dir, name string, v any) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	path := filepath.Join(dir, name)
+	return path, os.WriteFile(path, data, 0644)
+}
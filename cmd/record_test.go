@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restsend/rustpbxgo"
+)
+
+// 测试挂断事件：等待挂断、挂断钩子只执行一次，写通话记录前等待钩子完成
+func TestCallRecord_Hangup(t *testing.T) {
+	record := NewCallRecord("call-1")
+	calls := 0
+	record.OnHangup = func(r *CallRecord) {
+		calls++
+		time.Sleep(50 * time.Millisecond)
+		r.SetSummaryFile("call-1.summary.json")
+	}
+	if record.WaitHangup(10 * time.Millisecond) {
+		t.Error("WaitHangup returned true before the hangup")
+	}
+	record.SetAnswered()
+	event := rustpbxgo.HangupEvent{Reason: "by_client", Initiator: "caller"}
+	record.SetHangup(event)
+	record.SetHangup(event)
+	if !record.WaitHangup(time.Second) {
+		t.Fatal("WaitHangup timed out after the hangup")
+	}
+
+	dir := t.TempDir()
+	path, err := record.Write(dir)
+	if err != nil {
+		t.Fatalf("Write returned an error: %v", err)
+	}
+	if path != filepath.Join(dir, "call-1.json") {
+		t.Errorf("unexpected path: %s", path)
+	}
+	if calls != 1 {
+		t.Errorf("expected the hangup hook to run once, ran %d times", calls)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read record: %v", err)
+	}
+	var written CallRecord
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("failed to parse record: %v", err)
+	}
+	if written.CallID != "call-1" || written.HangupReason != "by_client" || written.Initiator != "caller" || written.AnswerTime == nil || written.EndTime == nil {
+		t.Errorf("unexpected record: %+v", &written)
+	}
+	if written.SummaryFile != "call-1.summary.json" {
+		t.Errorf("record written before the hangup hook finished: %q", written.SummaryFile)
+	}
+}
+
+// 测试连接异常断开、没有挂断事件时也能写出通话记录
+func TestCallRecord_WriteWithoutHangup(t *testing.T) {
+	record := NewCallRecord("call-2")
+	path, err := record.Write(t.TempDir())
+	if err != nil {
+		t.Fatalf("Write returned an error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read record: %v", err)
+	}
+	var written CallRecord
+	if err := json.Unmarshal(data, &written); err != nil || written.EndTime == nil {
+		t.Errorf("expected an end time, got %+v: %v", &written, err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// recorderMaxPending 入站音频最多等待的时长，超过后丢弃最旧的数据，保证两个声道对齐
+const recorderMaxPending = 100 * time.Millisecond
+
+// localRecorder 在本地把通话录制为双声道 WAV：左声道为对方（解码后的入站音频），右声道为本地（发送的麦克风音频）
+// 以发送节奏为时间基准，每发送一帧写入一帧双声道数据，对方没有数据时补静音
+// 每次写入后回填文件头，进程崩溃时已写入的部分仍然可以播放
+type localRecorder struct {
+	basePath string // 不含扩展名的文件路径
+	split    bool   // 是否额外写入每个方向的单声道文件
+
+	mu       sync.Mutex
+	stereo   *wavWriter
+	caller   *wavWriter
+	agent    *wavWriter
+	inbound  []byte
+	maxBytes int
+	files    []string
+	closed   bool
+}
+
+// newLocalRecorder 创建本地录音，文件在 Start 时创建
+// 录音文件为 <basePath>.wav，split 时另外写入 <basePath>.caller.wav 和 <basePath>.agent.wav
+func newLocalRecorder(basePath string, split bool) *localRecorder {
+	return &localRecorder{basePath: basePath, split: split}
+}
+
+// Start 按协商后的采样率创建录音文件
+func (r *localRecorder) Start(sampleRate int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stereo != nil || r.closed {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(r.basePath), 0755); err != nil {
+		return fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	stereo, err := r.create(r.basePath+".wav", sampleRate, 2)
+	if err != nil {
+		return err
+	}
+	var caller, agent *wavWriter
+	if r.split {
+		if caller, err = r.create(r.basePath+".caller.wav", sampleRate, 1); err == nil {
+			agent, err = r.create(r.basePath+".agent.wav", sampleRate, 1)
+		}
+		if err != nil {
+			// 关闭并删除已经创建的文件，不留下缺少声道的录音
+			for _, writer := range []*wavWriter{stereo, caller} {
+				if writer != nil {
+					writer.Close()
+				}
+			}
+			for _, path := range r.files {
+				os.Remove(path)
+			}
+			r.files = nil
+			return err
+		}
+	}
+	r.stereo, r.caller, r.agent = stereo, caller, agent
+	r.maxBytes = durationBytes(sampleRate, recorderMaxPending)
+	return nil
+}
+
+func (r *localRecorder) create(path string, sampleRate, channels int) (*wavWriter, error) {
+	writer, err := newWAVWriter(path, sampleRate, channels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	r.files = append(r.files, path)
+	return writer, nil
+}
+
+// Inbound 记录解码后的对方音频
+func (r *localRecorder) Inbound(pcm []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stereo == nil || r.closed {
+		return
+	}
+	r.inbound = append(r.inbound, pcm...)
+	if excess := len(r.inbound) - r.maxBytes; excess > 0 {
+		excess += excess % 2
+		r.inbound = append(r.inbound[:0], r.inbound[excess:]...)
+	}
+}
+
+// Outbound 记录发送的本地音频，同时取出等长的对方音频写入双声道文件
+func (r *localRecorder) Outbound(pcm []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stereo == nil || r.closed {
+		return nil
+	}
+	caller := make([]byte, len(pcm))
+	n := copy(caller, r.inbound)
+	r.inbound = r.inbound[n:]
+
+	stereo := make([]byte, len(pcm)*2)
+	for i := 0; i+1 < len(pcm); i += 2 {
+		binary.LittleEndian.PutUint16(stereo[i*2:], binary.LittleEndian.Uint16(caller[i:]))
+		binary.LittleEndian.PutUint16(stereo[i*2+2:], binary.LittleEndian.Uint16(pcm[i:]))
+	}
+	if err := writeAndFlush(r.stereo, stereo); err != nil {
+		return err
+	}
+	if r.caller != nil {
+		if err := writeAndFlush(r.caller, caller); err != nil {
+			return err
+		}
+	}
+	if r.agent != nil {
+		if err := writeAndFlush(r.agent, pcm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeAndFlush(writer *wavWriter, pcm []byte) error {
+	if _, err := writer.Write(pcm); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// Files 返回已创建的录音文件
+func (r *localRecorder) Files() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.files...)
+}
+
+// Close 关闭录音文件
+func (r *localRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	var firstErr error
+	for _, writer := range []*wavWriter{r.stereo, r.caller, r.agent} {
+		if writer == nil {
+			continue
+		}
+		if err := writer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 测试双声道录音左声道为对方、右声道为本地，写入过程中文件头已是合法长度
+func TestLocalRecorder(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "calls", "test-call")
+	recorder := newLocalRecorder(base, true)
+	if err := recorder.Start(8000); err != nil {
+		t.Fatalf("Start returned an error: %v", err)
+	}
+
+	frame := func(value int16) []byte {
+		pcm := make([]byte, frameBytes(8000))
+		for i := 0; i < len(pcm); i += 2 {
+			binary.LittleEndian.PutUint16(pcm[i:], uint16(value))
+		}
+		return pcm
+	}
+	recorder.Inbound(frame(1000))
+	recorder.Outbound(frame(-2000))
+	// 对方没有数据时左声道为静音
+	recorder.Outbound(frame(-3000))
+
+	// 未关闭时文件头已经回填
+	pcm, rate, err := readWAV(base + ".agent.wav")
+	if err != nil || rate != 8000 || len(pcm) != 2*frameBytes(8000) {
+		t.Fatalf("unexpected agent file before close: %d bytes, rate %d, err %v", len(pcm), rate, err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+	if files := recorder.Files(); len(files) != 3 {
+		t.Errorf("expected 3 recording files, got %v", files)
+	}
+
+	// readWAV 会把双声道平均混合为单声道
+	stereo, _, err := readWAV(base + ".wav")
+	if err != nil {
+		t.Fatalf("readWAV returned an error: %v", err)
+	}
+	first := int16(binary.LittleEndian.Uint16(stereo))
+	last := int16(binary.LittleEndian.Uint16(stereo[len(stereo)-2:]))
+	if first != -500 || last != -1500 {
+		t.Errorf("unexpected mixed samples: first %d, last %d", first, last)
+	}
+	caller, _, _ := readWAV(base + ".caller.wav")
+	if int16(binary.LittleEndian.Uint16(caller)) != 1000 || caller[len(caller)-1] != 0 {
+		t.Errorf("unexpected caller channel")
+	}
+}
+
+// 测试分声道文件创建失败时删除已创建的文件，之后写入不会崩溃
+func TestLocalRecorder_StartFailure(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "test-call")
+	// 同名目录让 .agent.wav 无法创建
+	if err := os.MkdirAll(base+".agent.wav", 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	recorder := newLocalRecorder(base, true)
+	if err := recorder.Start(8000); err == nil {
+		t.Fatal("expected an error when a split file can not be created")
+	}
+	for _, path := range []string{base + ".wav", base + ".caller.wav"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed: %v", path, err)
+		}
+	}
+	if files := recorder.Files(); len(files) != 0 {
+		t.Errorf("unexpected files: %v", files)
+	}
+	recorder.Inbound(make([]byte, frameBytes(8000)))
+	if err := recorder.Outbound(make([]byte, frameBytes(8000))); err != nil {
+		t.Errorf("Outbound returned an error: %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Errorf("Close returned an error: %v", err)
+	}
+}
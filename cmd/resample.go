@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// resamplerTaps 降采样时抗混叠低通滤波器的阶数
+const resamplerTaps = 31
+
+// resampler 流式转换 S16LE 单声道数据的采样率，用于声卡原生采样率与编解码器采样率之间的转换
+// 降采样时先做窗函数 sinc 低通滤波，再做线性插值；分多次调用 Process 与一次处理整段数据的结果一致
+type resampler struct {
+	inRate  int
+	outRate int
+	step    float64   // 每个输出采样在输入中前进的距离
+	pos     float64   // 下一个输出采样相对于 prev 的位置
+	prev    float64   // 上一次调用的最后一个输入采样
+	started bool      // 是否已有 prev
+	taps    []float64 // 低通滤波器系数，升采样时为 nil
+	history []float64 // 滤波器的历史输入
+}
+
+// newResampler 创建 inRate 到 outRate 的重采样器
+func newResampler(inRate, outRate int) *resampler {
+	r := &resampler{
+		inRate:  inRate,
+		outRate: outRate,
+		step:    float64(inRate) / float64(outRate),
+	}
+	if outRate < inRate {
+		// 截止频率略低于输出的奈奎斯特频率
+		r.taps = lowpassTaps(resamplerTaps, 0.45*float64(outRate)/float64(inRate))
+		r.history = make([]float64, resamplerTaps-1)
+	}
+	return r
+}
+
+// lowpassTaps 生成归一化截止频率为 cutoff（相对采样率）的 Blackman 窗 sinc 低通滤波器
+func lowpassTaps(n int, cutoff float64) []float64 {
+	taps := make([]float64, n)
+	mid := float64(n-1) / 2
+	sum := 0.0
+	for i := range taps {
+		x := float64(i) - mid
+		sinc := 2 * cutoff
+		if x != 0 {
+			sinc = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		window := 0.42 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1)) + 0.08*math.Cos(4*math.Pi*float64(i)/float64(n-1))
+		taps[i] = sinc * window
+		sum += taps[i]
+	}
+	for i := range taps {
+		taps[i] /= sum
+	}
+	return taps
+}
+
+// filter 对输入做低通滤波，保留末尾的采样作为下一次的历史
+func (r *resampler) filter(input []float64) []float64 {
+	buf := append(r.history, input...)
+	output := make([]float64, len(input))
+	for i := range output {
+		acc := 0.0
+		for j, tap := range r.taps {
+			acc += buf[i+j] * tap
+		}
+		output[i] = acc
+	}
+	r.history = append(r.history[:0:0], buf[len(buf)-len(r.history):]...)
+	return output
+}
+
+// Process 转换一段数据，返回的长度随内部相位变化，平均为输入长度乘以 outRate/inRate
+func (r *resampler) Process(pcm []byte) []byte {
+	if r.inRate == r.outRate {
+		return pcm
+	}
+	input := make([]float64, len(pcm)/2)
+	for i := range input {
+		input[i] = float64(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+	}
+	if r.taps != nil {
+		input = r.filter(input)
+	}
+	if len(input) == 0 {
+		return nil
+	}
+
+	// buf[0] 为上一次的最后一个采样，第一次调用时从第一个输入采样开始
+	buf := input
+	if r.started {
+		buf = append([]float64{r.prev}, input...)
+	}
+	r.started = true
+	output := make([]byte, 0, int(float64(len(input))/r.step+2)*2)
+	// 位置超过最后一个采样的输出留到下一次调用
+	last := float64(len(buf) - 1)
+	for ; r.pos <= last; r.pos += r.step {
+		i := int(r.pos)
+		frac := r.pos - float64(i)
+		value := buf[i]
+		if frac > 0 {
+			value += (buf[i+1] - buf[i]) * frac
+		}
+		output = binary.LittleEndian.AppendUint16(output, uint16(clampSample(value)))
+	}
+	r.pos -= last
+	r.prev = buf[len(buf)-1]
+	return output
+}
+
+// clampSample 四舍五入并限制在 16 位范围内
+func clampSample(value float64) int16 {
+	value = math.Round(value)
+	if value > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if value < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(value)
+}
+
+// resamplePCM 一次性转换整段数据
+func resamplePCM(pcm []byte, inRate, outRate int) []byte {
+	return newResampler(inRate, outRate).Process(pcm)
+}
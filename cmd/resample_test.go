@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// sinePCM 生成指定频率、采样率和时长的正弦波
+func sinePCM(freq float64, rate int, samples int) []byte {
+	pcm := make([]byte, samples*2)
+	for i := 0; i < samples; i++ {
+		value := int16(10000 * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(value))
+	}
+	return pcm
+}
+
+// rms 计算跳过开头 skip 个采样后的均方根
+func rms(pcm []byte, skip int) float64 {
+	sum, n := 0.0, 0
+	for i := skip * 2; i+1 < len(pcm); i += 2 {
+		value := float64(int16(binary.LittleEndian.Uint16(pcm[i:])))
+		sum += value * value
+		n++
+	}
+	return math.Sqrt(sum / float64(n))
+}
+
+// 测试分块处理与整段处理结果一致，长度按采样率比例变化
+func TestResampler_Streaming(t *testing.T) {
+	for _, rates := range [][2]int{{48000, 16000}, {16000, 48000}, {44100, 8000}, {8000, 16000}} {
+		input := sinePCM(440, rates[0], rates[0]/10)
+		whole := resamplePCM(input, rates[0], rates[1])
+
+		r := newResampler(rates[0], rates[1])
+		var chunked []byte
+		for offset := 0; offset < len(input); offset += 202 {
+			end := min(offset+202, len(input))
+			chunked = append(chunked, r.Process(input[offset:end])...)
+		}
+		if !bytes.Equal(whole, chunked) {
+			t.Errorf("%d->%d: chunked output differs from whole output", rates[0], rates[1])
+		}
+		// 末尾需要下一个输入采样才能插值的输出留到下一次调用
+		expected := len(input) / 2 * rates[1] / rates[0]
+		if got := len(whole) / 2; got < expected-2 || got > expected+1 {
+			t.Errorf("%d->%d: expected about %d samples, got %d", rates[0], rates[1], expected, got)
+		}
+	}
+}
+
+// 测试降采样保留通带信号，并滤除输出奈奎斯特频率以上的信号
+func TestResampler_AntiAliasing(t *testing.T) {
+	pass := resamplePCM(sinePCM(1000, 48000, 4800), 48000, 8000)
+	if level := rms(pass, 50); level < 6000 {
+		t.Errorf("1 kHz tone attenuated to rms %.0f", level)
+	}
+	// 7 kHz 不滤波会混叠到 1 kHz
+	alias := resamplePCM(sinePCM(7000, 48000, 4800), 48000, 8000)
+	if level := rms(alias, 50); level > 1000 {
+		t.Errorf("7 kHz tone not filtered, rms %.0f", level)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/restsend/rustpbxgo"
+)
+
+// applySIPOption 把 --callee、--caller 和 SIP 认证参数写入 invite 的通话选项
+func applySIPOption(config Config, callOption *rustpbxgo.CallOption) error {
+	if config.Callee == "" {
+		return fmt.Errorf("--callee is required with --call-type sip")
+	}
+	callOption.Callee = config.Callee
+	callOption.Caller = config.Caller
+	if config.SipUsername != "" || config.SipPassword != "" || config.SipRealm != "" || len(config.SipHeaders) > 0 {
+		callOption.Sip = &rustpbxgo.SipOption{
+			Username: config.SipUsername,
+			Password: config.SipPassword,
+			Realm:    config.SipRealm,
+			Headers:  config.SipHeaders,
+		}
+	}
+	return nil
+}
+
+// runSIPCall 让服务器呼叫 SIP 目的地并桥接媒体，本地不收发音频，只根据识别结果驱动大模型和 TTS
+func runSIPCall(config Config, option CreateClientOption, callOption rustpbxgo.CallOption) {
+	if err := applySIPOption(config, &callOption); err != nil {
+		config.Logger.Fatalf("Failed to build SIP call: %v", err)
+	}
+	client := createClient(config.Ctx, option, option.CallID, callOption)
+	if err := client.Connect("sip"); err != nil {
+		config.Logger.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer client.Shutdown()
+
+	config.Logger.Infof("Calling %s", callOption.Callee)
+	if _, err := client.Invite(config.Ctx, callOption); err != nil {
+		config.Logger.Fatalf("Failed to invite: %v", err)
+	}
+	option.CallRecord.SetAnswered()
+	config.Logger.Infof("Call to %s answered", callOption.Callee)
+
+	<-option.SigChan
+	finishCall(config, option)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/restsend/rustpbxgo"
+)
+
+// 测试可重复的 --sip-header 参数
+func TestHeaderFlag(t *testing.T) {
+	headers := headerFlag{}
+	for _, value := range []string{"X-Tenant=demo", " X-Trace = a=b "} {
+		if err := headers.Set(value); err != nil {
+			t.Fatalf("Set(%q) returned an error: %v", value, err)
+		}
+	}
+	if headers["X-Tenant"] != "demo" || headers["X-Trace"] != "a=b" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+	for _, value := range []string{"X-Tenant", "=demo"} {
+		if err := headers.Set(value); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+// 测试 SIP 呼叫的目的地、主叫和认证参数写入 invite 选项
+func TestApplySIPOption(t *testing.T) {
+	var callOption rustpbxgo.CallOption
+	if err := applySIPOption(Config{}, &callOption); err == nil {
+		t.Error("expected an error without --callee")
+	}
+
+	config := Config{Callee: "sip:1001@pbx.example.com", Caller: "sip:bot@example.com"}
+	if err := applySIPOption(config, &callOption); err != nil {
+		t.Fatalf("applySIPOption returned an error: %v", err)
+	}
+	if callOption.Callee != config.Callee || callOption.Caller != config.Caller || callOption.Sip != nil {
+		t.Errorf("unexpected call option without credentials: %+v", callOption)
+	}
+
+	config.SipUsername = "bot"
+	config.SipPassword = "secret"
+	config.SipHeaders = map[string]string{"X-Tenant": "demo"}
+	if err := applySIPOption(config, &callOption); err != nil {
+		t.Fatalf("applySIPOption returned an error: %v", err)
+	}
+	if callOption.Sip == nil || callOption.Sip.Username != "bot" || callOption.Sip.Password != "secret" || callOption.Sip.Headers["X-Tenant"] != "demo" {
+		t.Errorf("unexpected SIP option: %+v", callOption.Sip)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Stage describes one persona of a multi-stage conversation (greeter, verification, specialist...)
+type Stage struct {
+	Name         string   `json:"name"`                  // 阶段名称，handoff 工具通过它切换
+	Description  string   `json:"description,omitempty"` // 告诉模型何时应该切换到该阶段
+	SystemPrompt string   `json:"systemPrompt"`          // 该阶段的系统提示词
+	Tools        []string `json:"tools,omitempty"`       // 允许使用的工具 hangup|handoff|form，为空时全部可用
+	Next         []string `json:"next,omitempty"`        // 允许切换到的阶段，为空时可以切换到任意其他阶段
+	Speaker      string   `json:"speaker,omitempty"`     // 该阶段使用的 TTS 音色
+	Emotion      string   `json:"emotion,omitempty"`     // 该阶段使用的 TTS 情感
+}
+
+// StageConfig is the on-disk description of all stages of a flow
+type StageConfig struct {
+	Initial string  `json:"initial"`
+	Stages  []Stage `json:"stages"`
+}
+
+// HandoffTool represents the arguments of a handoff function call
+type HandoffTool struct {
+	Stage   string `json:"stage"`
+	Summary string `json:"summary"`
+}
+
+// LoadStageConfig reads a stage config from a JSON file
+func LoadStageConfig(path string) (*StageConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stage config: %w", err)
+	}
+	var config StageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse stage config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Validate checks that stage names are unique and all references resolve
+func (c *StageConfig) Validate() error {
+	if len(c.Stages) == 0 {
+		return fmt.Errorf("stage config has no stages")
+	}
+	names := make(map[string]bool, len(c.Stages))
+	for _, stage := range c.Stages {
+		if stage.Name == "" {
+			return fmt.Errorf("stage without name")
+		}
+		if names[stage.Name] {
+			return fmt.Errorf("duplicate stage: %s", stage.Name)
+		}
+		names[stage.Name] = true
+	}
+	if c.Initial == "" {
+		c.Initial = c.Stages[0].Name
+	}
+	if !names[c.Initial] {
+		return fmt.Errorf("unknown initial stage: %s", c.Initial)
+	}
+	for _, stage := range c.Stages {
+		for _, next := range stage.Next {
+			if !names[next] {
+				return fmt.Errorf("stage %s hands off to unknown stage: %s", stage.Name, next)
+			}
+		}
+	}
+	return nil
+}
+
+// handoffTargets returns the stages the given stage may hand off to
+func handoffTargets(stage *Stage, stages []Stage) []Stage {
+	var targets []Stage
+	for _, candidate := range stages {
+		if candidate.Name == stage.Name {
+			continue
+		}
+		if len(stage.Next) > 0 && !containsString(stage.Next, candidate.Name) {
+			continue
+		}
+		targets = append(targets, candidate)
+	}
+	return targets
+}
+
+// stagePrompt builds the system prompt of a stage, carrying the summary of the previous stage
+func stagePrompt(stage *Stage, from string, summary string) string {
+	if summary == "" {
+		return stage.SystemPrompt
+	}
+	var sb strings.Builder
+	sb.WriteString(stage.SystemPrompt)
+	sb.WriteString("\n\n")
+	if from != "" {
+		sb.WriteString(fmt.Sprintf("The caller was handed over to you by the %s stage. ", from))
+	}
+	sb.WriteString("Summary of the conversation so far:\n")
+	sb.WriteString(summary)
+	return sb.String()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
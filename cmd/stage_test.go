@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/restsend/rustpbxgo"
+	"github.com/sirupsen/logrus"
+)
+
+// 测试阶段配置校验
+func TestStageConfig_Validate(t *testing.T) {
+	config := StageConfig{
+		Stages: []Stage{
+			{Name: "greeter", Next: []string{"specialist"}},
+			{Name: "specialist"},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %v", err)
+	}
+	if config.Initial != "greeter" {
+		t.Errorf("expected initial stage greeter, got %s", config.Initial)
+	}
+
+	config.Stages[0].Next = []string{"unknown"}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Validate accepted an unknown handoff target")
+	}
+}
+
+// 测试阶段切换
+func TestLLMHandler_SwitchStage(t *testing.T) {
+	handler := NewLLMHandler(context.Background(), "test", "http://127.0.0.1", "", logrus.New())
+	err := handler.SetStages(&StageConfig{
+		Initial: "greeter",
+		Stages: []Stage{
+			{Name: "greeter", SystemPrompt: "greet", Next: []string{"verification"}},
+			{Name: "verification", SystemPrompt: "verify", Next: []string{"specialist"}, Speaker: "601005"},
+			{Name: "specialist", SystemPrompt: "solve"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetStages returned an error: %v", err)
+	}
+
+	var changed string
+	handler.OnStageChange = func(from, to *Stage, summary string) {
+		changed = from.Name + "->" + to.Name
+	}
+	if err := handler.switchStage("specialist", ""); err == nil {
+		t.Errorf("greeter should not hand off to specialist")
+	}
+	if err := handler.switchStage("verification", "caller wants a refund"); err != nil {
+		t.Fatalf("switchStage returned an error: %v", err)
+	}
+	if changed != "greeter->verification" {
+		t.Errorf("unexpected stage change: %s", changed)
+	}
+	if stage := handler.CurrentStage(); stage == nil || stage.Speaker != "601005" {
+		t.Errorf("unexpected current stage: %v", stage)
+	}
+	if len(handler.messages) != 1 || handler.messages[0].Content != "verify\n\nThe caller was handed over to you by the greeter stage. Summary of the conversation so far:\ncaller wants a refund" {
+		t.Errorf("unexpected history after handoff: %v", handler.messages)
+	}
+}
+
+// 测试通过 queryLLM 的 TTS 回调流式查询大模型不会死锁，交接后的句子使用新阶段的音色
+func TestQueryLLM_StageSpeaker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	stub := newStubLLMServer()
+	defer stub.Close()
+	stub.SetReplies([]StubReply{
+		{Text: "Let me transfer you.", ToolCalls: []StubToolCall{{Name: "handoff", Arguments: `{"stage":"verification","summary":"refund"}`}}},
+		{Text: "Please verify your account."},
+	})
+
+	upgrader := websocket.Upgrader{}
+	commands := make(chan rustpbxgo.TtsCommand, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var command rustpbxgo.TtsCommand
+			if err := conn.ReadJSON(&command); err != nil {
+				return
+			}
+			if command.Command == "tts" {
+				commands <- command
+			}
+		}
+	}))
+	defer server.Close()
+	client := rustpbxgo.NewClient("ws"+strings.TrimPrefix(server.URL, "http"), rustpbxgo.WithLogger(logger), rustpbxgo.WithContext(ctx))
+	if err := client.Connect("websocket"); err != nil {
+		t.Fatalf("Connect returned an error: %v", err)
+	}
+	defer client.Shutdown()
+
+	llm := NewLLMHandler(ctx, "test", stub.URL(), "", logger)
+	err := llm.SetStages(&StageConfig{
+		Initial: "greeter",
+		Stages: []Stage{
+			{Name: "greeter", SystemPrompt: "greet", Next: []string{"verification"}},
+			{Name: "verification", SystemPrompt: "verify", Speaker: "601005"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetStages returned an error: %v", err)
+	}
+	option := CreateClientOption{LLMHandler: llm, Logger: logger}
+	callOption := rustpbxgo.CallOption{TTS: &rustpbxgo.TTSOption{Speaker: "101001"}}
+
+	done := make(chan struct{})
+	go func() {
+		queryLLM(client, option, "I want a refund", callOption)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queryLLM deadlocked in the TTS callback")
+	}
+
+	expected := []rustpbxgo.TtsCommand{
+		{Text: "Let me transfer you.", Speaker: "101001"},
+		{Text: "Please verify your account.", Speaker: "601005"},
+	}
+	for _, want := range expected {
+		select {
+		case got := <-commands:
+			if strings.TrimSpace(got.Text) != want.Text || got.Speaker != want.Speaker {
+				t.Errorf("expected %q with speaker %s, got %q with speaker %s", want.Text, want.Speaker, got.Text, got.Speaker)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for TTS %q", want.Text)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// CallSummary is the post-call summary generated from the transcript
+type CallSummary struct {
+	CallID      string    `json:"callId"`
+	Disposition string    `json:"disposition"`
+	Intent      string    `json:"intent"`
+	FollowUps   []string  `json:"followUps"`
+	Sentiment   string    `json:"sentiment"`
+	Summary     string    `json:"summary"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// callDispositions are the disposition codes the model may choose from
+var callDispositions = []string{"resolved", "unresolved", "transferred", "callback_requested", "voicemail", "abandoned", "wrong_number", "other"}
+
+const summaryPrompt = `You are a call center quality analyst. You receive the transcript of a phone call between a caller ("user") and a voice agent ("assistant").
+Produce a post-call summary: choose the disposition code that best describes the outcome, describe the caller's intent in one sentence,
+list the follow-up actions the company has to take (empty if none), rate the caller's overall sentiment and write a short summary.`
+
+// summaryTimeout limits how long the post-call summary request may take
+const summaryTimeout = 30 * time.Second
+
+// summaryFormat is the JSON schema the summary response must follow
+func summaryFormat() *openai.ChatCompletionResponseFormat {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"disposition": map[string]any{"type": "string", "enum": callDispositions},
+			"intent":      map[string]any{"type": "string"},
+			"followUps":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"sentiment":   map[string]any{"type": "string", "enum": []string{"positive", "neutral", "negative"}},
+			"summary":     map[string]any{"type": "string"},
+		},
+		"required":             []string{"disposition", "intent", "followUps", "sentiment", "summary"},
+		"additionalProperties": false,
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   "call_summary",
+			Schema: jsonSchema(schema),
+			Strict: true,
+		},
+	}
+}
+
+// jsonSchema adapts a plain map to the json.Marshaler expected by go-openai
+type jsonSchema map[string]any
+
+func (s jsonSchema) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any(s))
+}
+
+// formatTranscript renders the transcript as plain text for the model
+func formatTranscript(transcript []TranscriptEntry) string {
+	var sb strings.Builder
+	for _, entry := range transcript {
+		if entry.Stage != "" {
+			sb.WriteString(fmt.Sprintf("[%s] %s (%s): %s\n", entry.Timestamp.Format("15:04:05"), entry.Speaker, entry.Stage, entry.Text))
+		} else {
+			sb.WriteString(fmt.Sprintf("[%s] %s: %s\n", entry.Timestamp.Format("15:04:05"), entry.Speaker, entry.Text))
+		}
+	}
+	return sb.String()
+}
+
+// Summarize generates the post-call summary of the conversation held by h
+func (h *LLMHandler) Summarize(model string, callID string) (*CallSummary, error) {
+	transcript := h.Transcript()
+	if len(transcript) == 0 {
+		return nil, fmt.Errorf("empty transcript")
+	}
+
+	summarizer := h.fork(summaryPrompt)
+	// The call record waits for the summary, a stalled endpoint must not block the exit
+	ctx, cancel := context.WithTimeout(h.ctx, summaryTimeout)
+	defer cancel()
+	summarizer.ctx = ctx
+	summarizer.SetResponseFormat(summaryFormat())
+	content, _, err := summarizer.Query(model, formatTranscript(transcript))
+	if err != nil {
+		return nil, err
+	}
+	var summary CallSummary
+	if err := json.Unmarshal([]byte(content), &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse summary: %w", err)
+	}
+	summary.CallID = callID
+	summary.CreatedAt = time.Now()
+	return &summary, nil
+}
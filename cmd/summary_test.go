@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/restsend/rustpbxgo"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+// 测试通话小结的 JSON Schema 要求所有字段并限定处置代码
+func TestSummaryFormat(t *testing.T) {
+	format := summaryFormat()
+	if format.Type != openai.ChatCompletionResponseFormatTypeJSONSchema || !format.JSONSchema.Strict {
+		t.Fatalf("unexpected response format: %+v", format)
+	}
+	data, err := json.Marshal(format.JSONSchema.Schema)
+	if err != nil {
+		t.Fatalf("failed to marshal schema: %v", err)
+	}
+	var schema struct {
+		Required   []string `json:"required"`
+		Properties map[string]struct {
+			Enum []string `json:"enum"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	if len(schema.Required) != len(schema.Properties) {
+		t.Errorf("strict schema must require every property: %v", schema.Required)
+	}
+	if strings.Join(schema.Properties["disposition"].Enum, ",") != strings.Join(callDispositions, ",") {
+		t.Errorf("unexpected dispositions: %v", schema.Properties["disposition"].Enum)
+	}
+}
+
+// 测试根据对话记录生成通话小结，并在挂断钩子中写入小结文件
+func TestSummarizeCall(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	stub := newStubLLMServer()
+	defer stub.Close()
+	llm := NewLLMHandler(context.Background(), "test", stub.URL(), "", logger)
+
+	if _, err := llm.Summarize("", "call-1"); err == nil {
+		t.Error("expected an error for an empty transcript")
+	}
+
+	stub.SetReplies([]StubReply{
+		{Text: "Your refund is on its way."},
+		{Text: `{"disposition":"resolved","intent":"Get a refund","followUps":["Send the refund"],"sentiment":"positive","summary":"The caller asked for a refund."}`},
+	})
+	if _, err := llm.QueryStream("", "I want a refund", func(segment string, playID string, autoHangup bool) error { return nil }); err != nil {
+		t.Fatalf("QueryStream returned an error: %v", err)
+	}
+
+	dir := t.TempDir()
+	record := NewCallRecord("call-1")
+	record.OnHangup = func(r *CallRecord) {
+		summarizeCall(llm, "", dir, r, logger)
+	}
+	record.SetHangup(rustpbxgo.HangupEvent{Reason: "by_client"})
+	if _, err := record.Write(dir); err != nil {
+		t.Fatalf("Write returned an error: %v", err)
+	}
+	if record.SummaryFile != "call-1.summary.json" {
+		t.Fatalf("unexpected summary file: %q", record.SummaryFile)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, record.SummaryFile))
+	if err != nil {
+		t.Fatalf("failed to read summary: %v", err)
+	}
+	var summary CallSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("failed to parse summary: %v", err)
+	}
+	if summary.CallID != "call-1" || summary.Disposition != "resolved" || summary.Sentiment != "positive" || len(summary.FollowUps) != 1 || summary.CreatedAt.IsZero() {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// SupervisorMode 主管参与通话的方式
+type SupervisorMode int
+
+const (
+	SupervisorListen  SupervisorMode = iota // 旁听双方的声音
+	SupervisorWhisper                       // 旁听，并且只对坐席说话，对方听不到
+	SupervisorBarge                         // 旁听，并且加入通话，坐席和对方都能听到
+)
+
+// ParseSupervisorMode 解析主管模式：listen、whisper 或 barge
+func ParseSupervisorMode(mode string) (SupervisorMode, error) {
+	switch strings.ToLower(mode) {
+	case "", "listen":
+		return SupervisorListen, nil
+	case "whisper":
+		return SupervisorWhisper, nil
+	case "barge":
+		return SupervisorBarge, nil
+	}
+	return SupervisorListen, fmt.Errorf("invalid supervisor mode: %s, expected listen, whisper or barge", mode)
+}
+
+func (m SupervisorMode) String() string {
+	switch m {
+	case SupervisorWhisper:
+		return "whisper"
+	case SupervisorBarge:
+		return "barge"
+	}
+	return "listen"
+}
+
+// Supervisor 本地混音的额外参与者，可以是另一个声卡或一路 websocket 音频流
+// 主管的输出是对方和坐席声音的混合；主管的输入按模式混入坐席的本地播放和发给对方的音频
+// 所有音频都使用通话协商的编解码器采样率
+type Supervisor struct {
+	mh       *MediaHandler
+	source   AudioSource
+	sink     AudioSink
+	mu       sync.Mutex
+	mode     SupervisorMode
+	detached bool
+	remote   *playbackQueue // 对方的声音，在主管的输出中播放
+	agent    *playbackQueue // 坐席发给对方的声音，在主管的输出中播放
+	whisper  *playbackQueue // 主管的声音，在坐席本地播放
+	barge    *playbackQueue // 主管的声音，混入发给对方的音频
+}
+
+// AttachSupervisor 让主管加入通话，source 和 sink 为主管的音频输入输出，同一时间只能有一个主管
+// 需要在媒体开始收发之后调用
+func (mh *MediaHandler) AttachSupervisor(source AudioSource, sink AudioSink, mode SupervisorMode) (*Supervisor, error) {
+	codec := mh.mediaCodec.Load()
+	if codec == nil {
+		return nil, fmt.Errorf("media is not started")
+	}
+	sampleRate := codec.SampleRate
+	sup := &Supervisor{
+		mh:      mh,
+		source:  source,
+		sink:    sink,
+		mode:    mode,
+		remote:  newPlaybackQueue(sampleRate),
+		agent:   newPlaybackQueue(sampleRate),
+		whisper: newPlaybackQueue(sampleRate),
+		barge:   newPlaybackQueue(sampleRate),
+	}
+	mh.supervisorMu.Lock()
+	if mh.supervisor != nil {
+		mh.supervisorMu.Unlock()
+		return nil, fmt.Errorf("a supervisor is already attached")
+	}
+	mh.supervisor = sup
+	mh.supervisorMu.Unlock()
+
+	if err := sink.Start(sampleRate, sup.fill); err != nil {
+		sup.Detach()
+		return nil, fmt.Errorf("failed to start supervisor output: %w", err)
+	}
+	if err := source.Start(sampleRate, sup.speak); err != nil {
+		sup.Detach()
+		return nil, fmt.Errorf("failed to start supervisor input: %w", err)
+	}
+	mh.logger.Infof("Supervisor attached in %s mode", mode)
+	return sup, nil
+}
+
+// currentSupervisor 返回当前的主管，没有时返回 nil
+func (mh *MediaHandler) currentSupervisor() *Supervisor {
+	mh.supervisorMu.Lock()
+	defer mh.supervisorMu.Unlock()
+	return mh.supervisor
+}
+
+// Mode 返回当前模式
+func (sup *Supervisor) Mode() SupervisorMode {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	return sup.mode
+}
+
+// SetMode 切换模式，离开的模式中还没有播放的主管声音会被丢弃
+func (sup *Supervisor) SetMode(mode SupervisorMode) {
+	sup.mu.Lock()
+	previous := sup.mode
+	sup.mode = mode
+	sup.mu.Unlock()
+	if mode == previous {
+		return
+	}
+	if mode == SupervisorListen {
+		sup.whisper.Clear()
+	}
+	if mode != SupervisorBarge {
+		sup.barge.Clear()
+	}
+	sup.mh.logger.Infof("Supervisor switched from %s to %s mode", previous, mode)
+}
+
+// Detach 主管离开通话，停止主管的音频输入输出
+func (sup *Supervisor) Detach() {
+	sup.mu.Lock()
+	if sup.detached {
+		sup.mu.Unlock()
+		return
+	}
+	sup.detached = true
+	sup.mu.Unlock()
+
+	sup.mh.supervisorMu.Lock()
+	if sup.mh.supervisor == sup {
+		sup.mh.supervisor = nil
+	}
+	sup.mh.supervisorMu.Unlock()
+	if err := sup.source.Stop(); err != nil {
+		sup.mh.logger.Warnf("Failed to stop supervisor input: %v", err)
+	}
+	if err := sup.sink.Stop(); err != nil {
+		sup.mh.logger.Warnf("Failed to stop supervisor output: %v", err)
+	}
+	sup.mh.logger.Info("Supervisor detached")
+}
+
+// fill 主管的输出：对方和坐席的声音混合在一起
+func (sup *Supervisor) fill(output []byte) {
+	sup.remote.Read(output)
+	agent := make([]byte, len(output))
+	sup.agent.Read(agent)
+	mixInto(output, agent)
+}
+
+// speak 主管的输入：耳语和插话时在坐席本地播放，插话时同时发给对方
+func (sup *Supervisor) speak(pcm []byte) {
+	switch sup.Mode() {
+	case SupervisorWhisper:
+		sup.whisper.Write(pcm)
+	case SupervisorBarge:
+		sup.whisper.Write(pcm)
+		sup.barge.Write(pcm)
+	}
+}
+
+// heardRemote 收到对方的一帧音频
+func (sup *Supervisor) heardRemote(pcm []byte) {
+	sup.remote.Write(pcm)
+}
+
+// outbound 坐席发送一帧音频：先给主管旁听，插话时再混入主管的声音
+func (sup *Supervisor) outbound(frame []byte) []byte {
+	sup.agent.Write(frame)
+	if sup.Mode() != SupervisorBarge {
+		return frame
+	}
+	voice := make([]byte, len(frame))
+	sup.barge.Read(voice)
+	out := append([]byte(nil), frame...)
+	mixInto(out, voice)
+	return out
+}
+
+// mixPlayback 把主管耳语或插话的声音混入坐席本地播放的数据
+func (sup *Supervisor) mixPlayback(output []byte) {
+	if sup.Mode() == SupervisorListen {
+		return
+	}
+	voice := make([]byte, len(output))
+	sup.whisper.Read(voice)
+	mixInto(output, voice)
+}
+
+// mixInto 把 src 叠加到 dst 上，超出范围时限幅
+func mixInto(dst, src []byte) {
+	for i := 0; i+1 < len(dst) && i+1 < len(src); i += 2 {
+		sum := float64(int16(binary.LittleEndian.Uint16(dst[i:]))) + float64(int16(binary.LittleEndian.Uint16(src[i:])))
+		binary.LittleEndian.PutUint16(dst[i:], uint16(clampSample(sum)))
+	}
+}
+
+// supervisorHello 主管 websocket 连接建立后发送的第一条文本消息，说明音频格式
+type supervisorHello struct {
+	Codec      string `json:"codec"`
+	SampleRate int    `json:"sampleRate"`
+	Mode       string `json:"mode"`
+}
+
+// supervisorCommand 主管 websocket 发来的文本消息，用于切换模式
+type supervisorCommand struct {
+	Mode string `json:"mode"`
+}
+
+// websocketSource 从 websocket 二进制消息读取主管的 S16LE 音频，文本消息交给 onText 处理
+type websocketSource struct {
+	conn   *websocket.Conn
+	onText func(data []byte)
+	done   chan struct{}
+}
+
+func (s *websocketSource) Start(sampleRate int, onData func(samples []byte)) error {
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		for {
+			mt, data, err := s.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch mt {
+			case websocket.BinaryMessage:
+				onData(data[:len(data)/2*2])
+			case websocket.TextMessage:
+				if s.onText != nil {
+					s.onText(data)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *websocketSource) Stop() error {
+	return s.conn.Close()
+}
+
+// Done 连接断开时关闭
+func (s *websocketSource) Done() <-chan struct{} {
+	return s.done
+}
+
+// websocketSink 每 20 毫秒把一帧主管的输出作为二进制消息发出
+type websocketSink struct {
+	conn   *websocket.Conn
+	worker pacedWorker
+}
+
+func (s *websocketSink) Start(sampleRate int, fill func(output []byte)) error {
+	frame := make([]byte, frameBytes(sampleRate))
+	s.worker.start(func() bool {
+		fill(frame)
+		return s.conn.WriteMessage(websocket.BinaryMessage, frame) == nil
+	})
+	return nil
+}
+
+func (s *websocketSink) Stop() error {
+	s.worker.stop()
+	return nil
+}
+
+// supervisorAddr 没有指定主机时只监听本机回环地址，需要对外提供时显式指定 0.0.0.0:port
+func supervisorAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// supervisorAuthorized 检查 token 查询参数或 Authorization: Bearer 请求头，token 为空时拒绝所有请求
+func supervisorAuthorized(r *http.Request, token string) bool {
+	given := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		given = bearer
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// supervisorHandler 主管通过 websocket 加入通话，mode 查询参数指定初始模式，文本消息 {"mode":"barge"} 切换模式
+// 需要携带 token，并且只接受同源的浏览器请求，避免网页通过跨站 websocket 旁听通话
+func supervisorHandler(mh *MediaHandler, token string) http.HandlerFunc {
+	upgrader := websocket.Upgrader{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !supervisorAuthorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mode, err := ParseSupervisorMode(r.URL.Query().Get("mode"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		codec := mh.mediaCodec.Load()
+		if codec == nil {
+			http.Error(w, "call is not answered", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			mh.logger.Warnf("Failed to upgrade supervisor connection: %v", err)
+			return
+		}
+		hello := supervisorHello{Codec: codec.Name, SampleRate: codec.SampleRate, Mode: mode.String()}
+		if err := conn.WriteJSON(hello); err != nil {
+			conn.Close()
+			return
+		}
+		source := &websocketSource{conn: conn}
+		source.onText = func(data []byte) {
+			var command supervisorCommand
+			if err := json.Unmarshal(data, &command); err != nil {
+				mh.logger.Warnf("Invalid supervisor command: %s", data)
+				return
+			}
+			mode, err := ParseSupervisorMode(command.Mode)
+			if err != nil {
+				mh.logger.Warnf("Invalid supervisor command: %v", err)
+				return
+			}
+			if sup := mh.currentSupervisor(); sup != nil {
+				sup.SetMode(mode)
+			}
+		}
+		sup, err := mh.AttachSupervisor(source, &websocketSink{conn: conn}, mode)
+		if err != nil {
+			mh.logger.Warnf("Failed to attach supervisor: %v", err)
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()))
+			conn.Close()
+			return
+		}
+		<-source.Done()
+		sup.Detach()
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// 测试三种模式下主管听到的声音，以及主管的声音分别混入坐席播放和发给对方的音频
+func TestSupervisorModes(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	middle := func(pcm []byte) int16 {
+		return int16(binary.LittleEndian.Uint16(pcm[len(pcm)/2:]))
+	}
+	tests := []struct {
+		mode     SupervisorMode
+		outbound int16 // 对方听到的
+		playback int16 // 坐席听到的
+	}{
+		{SupervisorListen, 2000, 500},
+		{SupervisorWhisper, 2000, 800},
+		{SupervisorBarge, 2300, 800},
+	}
+	for _, tt := range tests {
+		sup := &Supervisor{
+			mh:      &MediaHandler{logger: logger},
+			mode:    tt.mode,
+			remote:  newPlaybackQueue(8000),
+			agent:   newPlaybackQueue(8000),
+			whisper: newPlaybackQueue(8000),
+			barge:   newPlaybackQueue(8000),
+		}
+		// 60ms 的数据，超过播放缓冲的目标时延
+		sup.speak(constantPCM(300, 480))
+		sup.heardRemote(constantPCM(1000, 480))
+		if got := middle(sup.outbound(constantPCM(2000, 480))); got != tt.outbound {
+			t.Errorf("%s: expected outbound sample %d, got %d", tt.mode, tt.outbound, got)
+		}
+		playback := constantPCM(500, 480)
+		sup.mixPlayback(playback)
+		if got := middle(playback); got != tt.playback {
+			t.Errorf("%s: expected agent playback sample %d, got %d", tt.mode, tt.playback, got)
+		}
+		// 主管听到对方和坐席，听不到自己
+		heard := make([]byte, 480*2)
+		sup.fill(heard)
+		if got := middle(heard); got != 3000 {
+			t.Errorf("%s: expected supervisor to hear 3000, got %d", tt.mode, got)
+		}
+	}
+}
+
+// 测试主管通过 websocket 加入通话、切换模式，断开后离开通话
+func TestSupervisorHandler(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	mh := &MediaHandler{logger: logger}
+	mh.mediaCodec.Store(audioCodecs["pcmu"])
+	server := httptest.NewServer(supervisorHandler(mh, "secret"))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// 没有 token、token 错误或跨站请求都被拒绝
+	for _, tt := range []struct {
+		query  string
+		origin string
+	}{
+		{"?mode=listen", ""},
+		{"?mode=listen&token=wrong", ""},
+		{"?mode=listen&token=secret", "http://evil.example"},
+	} {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		if conn, _, err := websocket.DefaultDialer.Dial(url+tt.query, header); err == nil {
+			conn.Close()
+			t.Errorf("expected %s with origin %q to be rejected", tt.query, tt.origin)
+		}
+	}
+	if mh.currentSupervisor() != nil {
+		t.Fatal("expected no supervisor after rejected requests")
+	}
+
+	header := http.Header{"Authorization": {"Bearer secret"}}
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?mode=whisper", header)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	var hello supervisorHello
+	if err := conn.ReadJSON(&hello); err != nil {
+		t.Fatalf("failed to read hello: %v", err)
+	}
+	if hello.Codec != "pcmu" || hello.SampleRate != 8000 || hello.Mode != "whisper" {
+		t.Errorf("unexpected hello: %+v", hello)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || len(data) != frameBytes(8000) {
+		t.Errorf("expected a frame of supervisor audio, got %d bytes: %v", len(data), err)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"mode":"barge"}`)); err != nil {
+		t.Fatalf("failed to switch mode: %v", err)
+	}
+	waitFor := func(what string, done func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("barge mode", func() bool {
+		sup := mh.currentSupervisor()
+		return sup != nil && sup.Mode() == SupervisorBarge
+	})
+
+	conn.Close()
+	waitFor("supervisor to detach", func() bool { return mh.currentSupervisor() == nil })
+}
+
+// 测试只指定端口时只监听本机回环地址
+func TestSupervisorAddr(t *testing.T) {
+	tests := map[string]string{
+		":8090":          "127.0.0.1:8090",
+		"127.0.0.1:8090": "127.0.0.1:8090",
+		"0.0.0.0:8090":   "0.0.0.0:8090",
+		"[::1]:8090":     "[::1]:8090",
+	}
+	for addr, expected := range tests {
+		if got := supervisorAddr(addr); got != expected {
+			t.Errorf("supervisorAddr(%q) = %q, expected %q", addr, got, expected)
+		}
+	}
+}
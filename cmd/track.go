@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// rtpAudioTrack 本地音频轨道，自己打包 RTP，音频和 RFC 4733 DTMF 事件共用同一个 SSRC 和序列号
+// pion 的 TrackLocalStaticSample/TrackLocalStaticRTP 会把负载类型改写为绑定的编解码器，无法发送 telephone-event
+type rtpAudioTrack struct {
+	codec webrtc.RTPCodecCapability
+
+	mu             sync.Mutex
+	bindings       []rtpTrackBinding
+	sequenceNumber uint16 // 下一个包的序列号
+	timestamp      uint32 // 下一个音频包的时间戳
+	muted          bool   // 发送 DTMF 事件期间不发送音频，时间戳照常前进
+}
+
+type rtpTrackBinding struct {
+	id          string
+	ssrc        webrtc.SSRC
+	payloadType webrtc.PayloadType
+	eventType   webrtc.PayloadType // telephone-event 的负载类型
+	hasEvent    bool               // 对方是否协商了 telephone-event
+	writeStream webrtc.TrackLocalWriter
+}
+
+// newRTPAudioTrack 创建使用 codec 的本地音频轨道
+func newRTPAudioTrack(codec webrtc.RTPCodecCapability) *rtpAudioTrack {
+	return &rtpAudioTrack{codec: codec}
+}
+
+// Bind 在协商完成后由 pion 调用，选出音频和 telephone-event 的负载类型
+func (t *rtpAudioTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	var audio *webrtc.RTPCodecParameters
+	var eventType webrtc.PayloadType
+	hasEvent := false
+	for _, codec := range ctx.CodecParameters() {
+		switch {
+		case audio == nil && strings.EqualFold(codec.MimeType, t.codec.MimeType) && codec.ClockRate == t.codec.ClockRate:
+			audio = &codec
+		case strings.EqualFold(codec.MimeType, mimeTypeTelephoneEvent) && codec.ClockRate == t.codec.ClockRate:
+			// 事件与音频共用时间戳，只使用与音频时钟频率一致的 telephone-event
+			eventType = codec.PayloadType
+			hasEvent = true
+		}
+	}
+	if audio == nil {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bindings = append(t.bindings, rtpTrackBinding{
+		id:          ctx.ID(),
+		ssrc:        ctx.SSRC(),
+		payloadType: audio.PayloadType,
+		eventType:   eventType,
+		hasEvent:    hasEvent,
+		writeStream: ctx.WriteStream(),
+	})
+	return *audio, nil
+}
+
+// Unbind 在轨道被移除时由 pion 调用
+func (t *rtpAudioTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.bindings {
+		if t.bindings[i].id == ctx.ID() {
+			t.bindings = append(t.bindings[:i], t.bindings[i+1:]...)
+			return nil
+		}
+	}
+	return webrtc.ErrUnbindFailed
+}
+
+func (t *rtpAudioTrack) ID() string                { return "rustpbxgo-audio" }
+func (t *rtpAudioTrack) RID() string               { return "" }
+func (t *rtpAudioTrack) StreamID() string          { return "rustpbxgo-audio" }
+func (t *rtpAudioTrack) Kind() webrtc.RTPCodecType { return webrtc.RTPCodecTypeAudio }
+
+// Codec 返回轨道的编解码器
+func (t *rtpAudioTrack) Codec() webrtc.RTPCodecCapability {
+	return t.codec
+}
+
+// WriteSample 把一帧编码后的音频打包为 RTP 发送，DTMF 事件期间只推进时间戳
+func (t *rtpAudioTrack) WriteSample(sample media.Sample) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timestamp := t.timestamp
+	t.timestamp += uint32(sample.Duration.Seconds() * float64(t.codec.ClockRate))
+	if t.muted {
+		return nil
+	}
+	return t.write(false, false, timestamp, sample.Data)
+}
+
+// write 向每个绑定发送一个 RTP 包，event 为 true 时使用 telephone-event 负载类型，调用时需持有 mu
+func (t *rtpAudioTrack) write(event, marker bool, timestamp uint32, payload []byte) error {
+	sequenceNumber := t.sequenceNumber
+	t.sequenceNumber++
+	var firstErr error
+	for _, b := range t.bindings {
+		pt := b.payloadType
+		if event {
+			if !b.hasEvent {
+				continue
+			}
+			pt = b.eventType
+		}
+		header := rtp.Header{
+			Version:        2,
+			Marker:         marker,
+			PayloadType:    uint8(pt),
+			SequenceNumber: sequenceNumber,
+			Timestamp:      timestamp,
+			SSRC:           uint32(b.ssrc),
+		}
+		if _, err := b.writeStream.WriteRTP(&header, payload); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// canSendEvents 对方是否协商了 telephone-event
+func (t *rtpAudioTrack) canSendEvents() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range t.bindings {
+		if b.hasEvent {
+			return true
+		}
+	}
+	return false
+}
+
+// beginEvent 开始一个 DTMF 事件，暂停音频并返回事件使用的时间戳
+func (t *rtpAudioTrack) beginEvent() uint32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.muted = true
+	return t.timestamp
+}
+
+// writeEvent 发送一个 telephone-event 包
+func (t *rtpAudioTrack) writeEvent(marker bool, timestamp uint32, payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.write(true, marker, timestamp, payload)
+}
+
+// endEvent 结束 DTMF 事件，恢复发送音频
+func (t *rtpAudioTrack) endEvent() {
+	t.mu.Lock()
+	t.muted = false
+	t.mu.Unlock()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// trickleICE 保存 trickle ICE 模式下的候选地址
+// 本地候选在开始转发之前先排队，远程候选在设置远程描述之前先排队
+type trickleICE struct {
+	mu        sync.Mutex
+	local     []string
+	send      func(candidates []string) error
+	remote    []string
+	remoteSet bool
+}
+
+// WithTrickleICE 开启 trickle ICE：Setup 不等待候选收集完成，候选地址收集到后通过 ForwardCandidates 发送
+func WithTrickleICE() MediaOption {
+	return func(mh *MediaHandler) {
+		mh.trickle = &trickleICE{}
+	}
+}
+
+// onLocalCandidate 处理本地收集到的候选，已经开始转发时直接发送
+func (mh *MediaHandler) onLocalCandidate(candidate *webrtc.ICECandidate) {
+	if mh.trickle == nil || candidate == nil {
+		return
+	}
+	value := candidate.ToJSON().Candidate
+	t := mh.trickle
+	t.mu.Lock()
+	send := t.send
+	if send == nil {
+		t.local = append(t.local, value)
+	}
+	t.mu.Unlock()
+	if send != nil {
+		if err := send([]string{value}); err != nil {
+			mh.logger.Warnf("Failed to send ICE candidate: %v", err)
+		}
+	}
+}
+
+// ForwardCandidates 开始把本地候选发送给服务器，先一次性发送已排队的候选
+func (mh *MediaHandler) ForwardCandidates(send func(candidates []string) error) error {
+	if mh.trickle == nil {
+		return nil
+	}
+	t := mh.trickle
+	t.mu.Lock()
+	pending := t.local
+	t.local = nil
+	t.send = send
+	t.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	mh.logger.Infof("Sending %d queued ICE candidates", len(pending))
+	return send(pending)
+}
+
+// AddRemoteCandidate 添加服务器发来的候选，远程描述还没有设置时先排队
+func (mh *MediaHandler) AddRemoteCandidate(candidate string) error {
+	t := mh.trickle
+	if t == nil {
+		return mh.peerConnection.AddICECandidate(webrtc.ICECandidateInit{Candidate: candidate})
+	}
+	t.mu.Lock()
+	if !t.remoteSet {
+		t.remote = append(t.remote, candidate)
+		t.mu.Unlock()
+		return nil
+	}
+	t.mu.Unlock()
+	return mh.peerConnection.AddICECandidate(webrtc.ICECandidateInit{Candidate: candidate})
+}
+
+// flushRemoteCandidates 设置远程描述后添加排队的远程候选
+func (mh *MediaHandler) flushRemoteCandidates() {
+	if mh.trickle == nil {
+		return
+	}
+	t := mh.trickle
+	t.mu.Lock()
+	pending := t.remote
+	t.remote = nil
+	t.remoteSet = true
+	t.mu.Unlock()
+	for _, candidate := range pending {
+		if err := mh.peerConnection.AddICECandidate(webrtc.ICECandidateInit{Candidate: candidate}); err != nil {
+			mh.logger.Warnf("Failed to add remote ICE candidate %s: %v", candidate, err)
+		}
+	}
+}
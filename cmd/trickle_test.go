@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// 测试 trickle ICE：offer 不含候选，双方通过候选交换建立连接
+func TestMediaHandler_TrickleICE(t *testing.T) {
+	handler, err := NewMediaHandler(context.Background(), logrus.New(),
+		WithTrickleICE(), WithAudioSource(&silenceSource{}), WithAudioSink(&nullSink{}))
+	if err != nil {
+		t.Fatalf("NewMediaHandler returned an error: %v", err)
+	}
+	defer handler.Stop()
+	connected := make(chan struct{})
+	var once sync.Once
+
+	offerSdp, err := handler.Setup("pcmu", nil)
+	if err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+	if strings.Contains(offerSdp, "a=candidate") {
+		t.Errorf("trickle offer should not contain candidates")
+	}
+
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterCodec(audioCodecs["pcmu"].parameters(), webrtc.RTPCodecTypeAudio)
+	remote, err := webrtc.NewAPI(webrtc.WithMediaEngine(&mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create remote peer connection: %v", err)
+	}
+	defer remote.Close()
+	remote.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			once.Do(func() { close(connected) })
+		}
+	})
+	// 远程候选在设置 answer 之前到达，需要排队
+	remote.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			handler.AddRemoteCandidate(candidate.ToJSON().Candidate)
+		}
+	})
+
+	if err := remote.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSdp}); err != nil {
+		t.Fatalf("Failed to set remote offer: %v", err)
+	}
+	answer, err := remote.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create answer: %v", err)
+	}
+	if err := remote.SetLocalDescription(answer); err != nil {
+		t.Fatalf("Failed to set local answer: %v", err)
+	}
+	<-webrtc.GatheringCompletePromise(remote)
+
+	if err := handler.SetupAnswer(answer.SDP); err != nil {
+		t.Fatalf("SetupAnswer returned an error: %v", err)
+	}
+	err = handler.ForwardCandidates(func(candidates []string) error {
+		for _, candidate := range candidates {
+			if err := remote.AddICECandidate(webrtc.ICECandidateInit{Candidate: candidate}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForwardCandidates returned an error: %v", err)
+	}
+
+	select {
+	case <-connected:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("peer connection not connected with trickled candidates")
+	}
+}
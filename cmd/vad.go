@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// VADConfig 本地能量 VAD 的参数
+type VADConfig struct {
+	ThresholdDB float64       // 判定为语音的最低电平，单位 dBFS
+	MinZCR      float64       // 过零率下限，过滤工频哼声等低频噪声
+	MaxZCR      float64       // 过零率上限，过滤白噪声、嘶声
+	MinSpeech   time.Duration // 连续语音达到该时长才认为开始说话，过滤按键声、咳嗽等短促噪声
+	Hangover    time.Duration // 连续静音达到该时长才认为停止说话，避免字间停顿被切断
+}
+
+// DefaultVADConfig 返回默认的 VAD 参数
+func DefaultVADConfig() VADConfig {
+	return VADConfig{
+		ThresholdDB: -40,
+		MinZCR:      0.02,
+		MaxZCR:      0.45,
+		MinSpeech:   60 * time.Millisecond,
+		Hangover:    300 * time.Millisecond,
+	}
+}
+
+// energyVAD 基于短时能量和过零率的语音活动检测，按帧处理采集的麦克风数据
+type energyVAD struct {
+	config     VADConfig
+	sampleRate int
+	speech     time.Duration // 当前连续语音的时长
+	silence    time.Duration // 说话状态下连续静音的时长
+	speaking   bool
+}
+
+// newEnergyVAD 创建 VAD，sampleRate 为输入数据的采样率
+func newEnergyVAD(config VADConfig, sampleRate int) *energyVAD {
+	return &energyVAD{config: config, sampleRate: sampleRate}
+}
+
+// frameLevel 计算一帧 S16LE 数据的电平（dBFS）和过零率（每个采样的过零次数）
+func frameLevel(frame []byte) (float64, float64) {
+	samples := len(frame) / 2
+	if samples == 0 {
+		return math.Inf(-1), 0
+	}
+	var sum float64
+	crossings := 0
+	prev := int16(0)
+	for i := 0; i < samples; i++ {
+		sample := int16(binary.LittleEndian.Uint16(frame[i*2:]))
+		sum += float64(sample) * float64(sample)
+		if i > 0 && (sample >= 0) != (prev >= 0) {
+			crossings++
+		}
+		prev = sample
+	}
+	rms := math.Sqrt(sum / float64(samples))
+	if rms == 0 {
+		return math.Inf(-1), 0
+	}
+	return 20 * math.Log10(rms/32768), float64(crossings) / float64(samples)
+}
+
+// isSpeech 判断一帧是否像语音
+func (v *energyVAD) isSpeech(frame []byte) bool {
+	level, zcr := frameLevel(frame)
+	return level >= v.config.ThresholdDB && zcr >= v.config.MinZCR && zcr <= v.config.MaxZCR
+}
+
+// Process 处理一帧数据，说话状态变化时 changed 为 true
+func (v *energyVAD) Process(frame []byte) (changed bool, speaking bool) {
+	duration := time.Duration(len(frame)/2) * time.Second / time.Duration(v.sampleRate)
+	if v.isSpeech(frame) {
+		v.speech += duration
+		v.silence = 0
+		if !v.speaking && v.speech >= v.config.MinSpeech {
+			v.speaking = true
+			return true, true
+		}
+		return false, v.speaking
+	}
+	v.speech = 0
+	if v.speaking {
+		v.silence += duration
+		if v.silence >= v.config.Hangover {
+			v.speaking = false
+			v.silence = 0
+			return true, false
+		}
+	}
+	return false, v.speaking
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+// 测试持续语音达到最短时长后开始说话，静音超过拖尾时长后停止说话，短促噪声不触发
+func TestEnergyVAD_MinSpeechAndHangover(t *testing.T) {
+	vad := newEnergyVAD(DefaultVADConfig(), 8000)
+	tone := sinePCM(440, 8000, 160)
+	silence := make([]byte, 320)
+
+	// 单帧的短促声音不触发
+	if changed, _ := vad.Process(tone); changed {
+		t.Fatalf("single frame should not start speech")
+	}
+	vad.Process(silence)
+
+	for i := 0; i < 3; i++ {
+		changed, speaking := vad.Process(tone)
+		if changed != (i == 2) || speaking != (i == 2) {
+			t.Fatalf("frame %d: changed %v speaking %v", i, changed, speaking)
+		}
+	}
+	// 300ms 拖尾内的停顿不结束说话
+	for i := 0; i < 14; i++ {
+		if changed, speaking := vad.Process(silence); changed || !speaking {
+			t.Fatalf("speech ended too early at silent frame %d", i)
+		}
+	}
+	if changed, speaking := vad.Process(silence); !changed || speaking {
+		t.Fatalf("expected speech to end after the hangover")
+	}
+}
+
+// 测试过零率过滤：低频哼声和低于阈值的声音不认为是语音
+func TestEnergyVAD_RejectsHumAndQuietSound(t *testing.T) {
+	vad := newEnergyVAD(DefaultVADConfig(), 8000)
+	hum := sinePCM(50, 8000, 8000)
+	for i := 0; i+320 <= len(hum); i += 320 {
+		if _, speaking := vad.Process(hum[i : i+320]); speaking {
+			t.Fatalf("50Hz hum detected as speech")
+		}
+	}
+	quiet := sinePCM(440, 8000, 160)
+	for i := 0; i+1 < len(quiet); i += 2 {
+		sample := int16(uint16(quiet[i]) | uint16(quiet[i+1])<<8)
+		sample /= 1000
+		quiet[i], quiet[i+1] = byte(sample), byte(uint16(sample)>>8)
+	}
+	for i := 0; i < 10; i++ {
+		if _, speaking := vad.Process(quiet); speaking {
+			t.Fatalf("sound below the threshold detected as speech")
+		}
+	}
+}
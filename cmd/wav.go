@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// wavHeaderSize 标准 PCM WAV 文件头长度
+const wavHeaderSize = 44
+
+// readWAV 读取 16 位 PCM WAV 文件，多声道时混合为单声道，返回 S16LE 数据和采样率
+func readWAV(path string) ([]byte, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, fmt.Errorf("%s is not a WAV file", path)
+	}
+
+	var sampleRate, channels, bitsPerSample int
+	var pcm []byte
+	// 遍历 RIFF 块，找到 fmt 和 data
+	for offset := 12; offset+8 <= len(data); {
+		chunkID := string(data[offset : offset+4])
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := data[offset+8:]
+		if chunkSize > len(body) {
+			chunkSize = len(body)
+		}
+		body = body[:chunkSize]
+		switch chunkID {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, 0, fmt.Errorf("invalid fmt chunk in %s", path)
+			}
+			format := binary.LittleEndian.Uint16(body[0:2])
+			if format != 1 && format != 0xFFFE {
+				return nil, 0, fmt.Errorf("%s is not PCM encoded", path)
+			}
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+		case "data":
+			pcm = body
+		}
+		offset += 8 + chunkSize + chunkSize%2
+	}
+	if sampleRate == 0 || pcm == nil {
+		return nil, 0, fmt.Errorf("%s has no fmt or data chunk", path)
+	}
+	if bitsPerSample != 16 {
+		return nil, 0, fmt.Errorf("%s has %d bits per sample, only 16 is supported", path, bitsPerSample)
+	}
+	if channels > 1 {
+		pcm = downmix(pcm, channels)
+	}
+	return pcm, sampleRate, nil
+}
+
+// downmix 把交织的多声道 S16LE 数据平均混合为单声道
+func downmix(pcm []byte, channels int) []byte {
+	frames := len(pcm) / (2 * channels)
+	mono := make([]byte, frames*2)
+	for i := 0; i < frames; i++ {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			sum += int(int16(binary.LittleEndian.Uint16(pcm[(i*channels+c)*2:])))
+		}
+		binary.LittleEndian.PutUint16(mono[i*2:], uint16(int16(sum/channels)))
+	}
+	return mono
+}
+
+// wavWriter 写入 16 位 PCM WAV 文件，关闭时回填文件头中的长度
+type wavWriter struct {
+	file       *os.File
+	sampleRate int
+	channels   int
+	dataSize   int
+}
+
+// newWAVWriter 创建 WAV 文件并写入文件头
+func newWAVWriter(path string, sampleRate, channels int) (*wavWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &wavWriter{
+		file:       file,
+		sampleRate: sampleRate,
+		channels:   channels,
+	}
+	if _, err := file.Write(w.header()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// header 根据当前数据长度生成文件头
+func (w *wavWriter) header() []byte {
+	header := make([]byte, wavHeaderSize)
+	blockAlign := w.channels * 2
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+w.dataSize))
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], 1)
+	binary.LittleEndian.PutUint16(header[22:24], uint16(w.channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(w.sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(w.sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:36], 16)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(w.dataSize))
+	return header
+}
+
+// Write 追加 S16LE 音频数据
+func (w *wavWriter) Write(pcm []byte) (int, error) {
+	n, err := w.file.Write(pcm)
+	w.dataSize += n
+	return n, err
+}
+
+// Flush 回填当前长度到文件头，进程异常退出时已写入的数据仍是合法的 WAV 文件
+func (w *wavWriter) Flush() error {
+	_, err := w.file.WriteAt(w.header(), 0)
+	return err
+}
+
+// Close 回填文件头并关闭文件
+func (w *wavWriter) Close() error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		w.file.Close()
+		return err
+	}
+	if _, err := w.file.Write(w.header()); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
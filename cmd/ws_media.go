@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// websocketCodec 选择 websocket 媒体传输使用的编解码器，取 --codec 中第一个支持的编解码器
+// 没有 SDP 协商，服务器按 invite 中的 codec 收发音频
+func websocketCodec(spec string) (*audioCodec, error) {
+	codecs, err := parseCodecList(spec)
+	if err != nil {
+		return nil, err
+	}
+	return codecs[0], nil
+}
+
+// StartWebsocket 使用 websocket 传输媒体：编码后的音频帧通过 send 发送，收到的音频帧交给 ReceiveWebsocketAudio
+// 适用于 UDP 或 ICE 被阻断的环境，在服务器应答后调用
+func (mh *MediaHandler) StartWebsocket(codecName string, send func(payload []byte) error) error {
+	codec, ok := audioCodecs[strings.ToLower(codecName)]
+	if !ok {
+		return fmt.Errorf("unsupported codec: %s", codecName)
+	}
+	decoder, err := codec.newDecoder()
+	if err != nil {
+		return fmt.Errorf("failed to create %s decoder: %w", codec.Name, err)
+	}
+	mh.mu.Lock()
+	if mh.connected.Load() || mh.sendAudio != nil {
+		mh.mu.Unlock()
+		return fmt.Errorf("media is already started")
+	}
+	mh.codec = codec
+	mh.sendAudio = send
+	mh.mu.Unlock()
+	// 启动声卡时会通过 audioContext 获取 mu，不能在持有 mu 时调用
+	mh.startMedia()
+	// 播放缓冲区创建之后才开始接收音频
+	mh.mu.Lock()
+	mh.decoder = decoder
+	mh.mu.Unlock()
+	mh.logger.Infof("Websocket media started with %s", codec.Name)
+	return nil
+}
+
+// ReceiveWebsocketAudio 处理服务器通过 websocket 发来的一帧编码音频
+// websocket 基于 TCP，音频按顺序到达，不需要抖动缓冲，由播放缓冲区吸收到达时间的抖动
+func (mh *MediaHandler) ReceiveWebsocketAudio(payload []byte) {
+	mh.mu.Lock()
+	decoder := mh.decoder
+	connected := mh.connected.Load()
+	mh.mu.Unlock()
+	if decoder == nil || !connected {
+		return
+	}
+	audioData, err := decoder.Decode(payload)
+	if err != nil {
+		mh.logger.Warnf("Failed to decode websocket audio: %v", err)
+		return
+	}
+	if len(audioData) > 0 {
+		mh.deliverInbound(audioData)
+	}
+}
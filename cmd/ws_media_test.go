@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/malgo"
+	"github.com/gorilla/websocket"
+	"github.com/restsend/rustpbxgo"
+	"github.com/sirupsen/logrus"
+)
+
+// 测试 websocket 媒体传输：服务器把收到的音频帧原样发回，本地播放的音频应与发送的一致
+func TestMediaHandler_Websocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	upgrader := websocket.Upgrader{}
+	path := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path <- r.URL.Path
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if mt == websocket.BinaryMessage {
+				conn.WriteMessage(websocket.BinaryMessage, data)
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := rustpbxgo.NewClient("ws"+strings.TrimPrefix(server.URL, "http"), rustpbxgo.WithLogger(logger), rustpbxgo.WithContext(ctx))
+	reference := loopbackTestSignal(8000, time.Second)
+	sink := &memorySink{}
+	mh, err := NewMediaHandler(ctx, logger, WithAudioSource(&pcmSource{pcm: reference, sampleRate: 8000}), WithAudioSink(sink))
+	if err != nil {
+		t.Fatalf("NewMediaHandler returned an error: %v", err)
+	}
+	defer mh.Stop()
+	client.OnAudio = mh.ReceiveWebsocketAudio
+	if err := client.Connect("websocket"); err != nil {
+		t.Fatalf("Connect returned an error: %v", err)
+	}
+	defer client.Shutdown()
+	if got := <-path; got != "/call/websocket" {
+		t.Errorf("expected /call/websocket, got %s", got)
+	}
+
+	if err := mh.StartWebsocket("pcmu", client.SendAudio); err != nil {
+		t.Fatalf("StartWebsocket returned an error: %v", err)
+	}
+	if err := mh.StartWebsocket("pcmu", client.SendAudio); err == nil {
+		t.Error("expected an error when starting the media twice")
+	}
+	time.Sleep(time.Second + loopbackMaxLatency)
+
+	comparison := compareWaveforms(reference, sink.Data(), 8000)
+	if comparison.Score < 0.5 {
+		t.Errorf("expected the echoed audio to match, score %.3f", comparison.Score)
+	}
+}
+
+// contextSink 启动时像声卡输出一样通过 contextFn 获取音频设备上下文
+type contextSink struct {
+	nullSink
+	contextFn func() (*malgo.AllocatedContext, error)
+}
+
+func (s *contextSink) Start(sampleRate int, fill func(output []byte)) error {
+	if _, err := s.contextFn(); err != nil {
+		return err
+	}
+	return s.nullSink.Start(sampleRate, fill)
+}
+
+// 测试启动 websocket 媒体时输出设备获取音频设备上下文不会死锁
+func TestMediaHandler_StartWebsocketDeviceContext(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	sink := &contextSink{}
+	mh, err := NewMediaHandler(context.Background(), logger, WithAudioSource(&silenceSource{}), WithAudioSink(sink))
+	if err != nil {
+		t.Fatalf("NewMediaHandler returned an error: %v", err)
+	}
+	sink.contextFn = mh.audioContext
+
+	started := make(chan error, 1)
+	go func() {
+		started <- mh.StartWebsocket("pcmu", func(payload []byte) error { return nil })
+	}()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("StartWebsocket returned an error: %v", err)
+		}
+		mh.Stop()
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartWebsocket deadlocked while the sink acquired the audio context")
+	}
+}
+
+// 测试 websocket 媒体发送音频的同时发送 TTS 命令，两者共用客户端的写锁
+func TestSendTTS_ConcurrentWithAudio(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	upgrader := websocket.Upgrader{}
+	texts := make(chan string, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var command rustpbxgo.TtsCommand
+			if mt == websocket.TextMessage && json.Unmarshal(data, &command) == nil && command.Command == "tts" {
+				texts <- command.Text
+			}
+		}
+	}))
+	defer server.Close()
+	client := rustpbxgo.NewClient("ws"+strings.TrimPrefix(server.URL, "http"), rustpbxgo.WithLogger(logger), rustpbxgo.WithContext(ctx))
+	if err := client.Connect("websocket"); err != nil {
+		t.Fatalf("Connect returned an error: %v", err)
+	}
+	defer client.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		frame := make([]byte, 160)
+		for i := 0; i < 200; i++ {
+			client.SendAudio(frame)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		sendTTS(client, logger, "hello", "101001")
+	}
+	<-done
+
+	for i := 0; i < 50; i++ {
+		select {
+		case text := <-texts:
+			if text != "hello" {
+				t.Fatalf("unexpected TTS text: %q", text)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d of 50 TTS commands", i)
+		}
+	}
+}